@@ -14,40 +14,47 @@ import (
 
 // ConfYaml is config structure.
 type ConfYaml struct {
-	Core   SectionCore  `yaml:"core"`
-	API    SectionAPI   `yaml:"api"`
-	Source SourceAPI    `yaml:"source"`
-	Log    SectionLog   `yaml:"log"`
-	Queue  SectionQueue `yaml:"queue"`
-	Stat   SectionStat  `yaml:"stat"`
+	Core     SectionCore          `yaml:"core"`
+	API      SectionAPI           `yaml:"api"`
+	Source   SourceAPI            `yaml:"source"`
+	Sources  map[string]SourceAPI `yaml:"sources"`
+	Log      SectionLog           `yaml:"log"`
+	Queue    SectionQueue         `yaml:"queue"`
+	Stat     SectionStat          `yaml:"stat"`
+	Scrape   SectionScrape        `yaml:"scrape"`
+	Schedule SectionSchedule      `yaml:"schedule"`
 }
 
 // SectionCore is sub section of config.
 type SectionCore struct {
-	Enabled         bool           `yaml:"enabled"`
-	Address         string         `yaml:"address"`
-	ShutdownTimeout int64          `yaml:"shutdown_timeout"`
-	Port            string         `yaml:"port"`
-	MaxNotification int64          `yaml:"max_notification"`
-	WorkerNum       int64          `yaml:"worker_num"`
-	QueueNum        int64          `yaml:"queue_num"`
-	Mode            string         `yaml:"mode"`
-	Sync            bool           `yaml:"sync"`
-	SSL             bool           `yaml:"ssl"`
-	CertPath        string         `yaml:"cert_path"`
-	KeyPath         string         `yaml:"key_path"`
-	CertBase64      string         `yaml:"cert_base64"`
-	KeyBase64       string         `yaml:"key_base64"`
-	HTTPProxy       string         `yaml:"http_proxy"`
-	FeedbackURL     string         `yaml:"feedback_hook_url"`
-	FeedbackTimeout int64          `yaml:"feedback_timeout"`
-	PID             SectionPID     `yaml:"pid"`
-	AutoTLS         SectionAutoTLS `yaml:"auto_tls"`
+	Enabled           bool             `yaml:"enabled"`
+	Address           string           `yaml:"address"`
+	ShutdownTimeout   int64            `yaml:"shutdown_timeout"`
+	Port              string           `yaml:"port"`
+	MaxNotification   int64            `yaml:"max_notification"`
+	WorkerNum         int64            `yaml:"worker_num"`
+	QueueNum          int64            `yaml:"queue_num"`
+	Mode              string           `yaml:"mode"`
+	Sync              bool             `yaml:"sync"`
+	IdempotencyWindow int64            `yaml:"idempotency_window"`
+	MaxPanics         int64            `yaml:"max_panics"`
+	SSL               bool             `yaml:"ssl"`
+	CertPath          string           `yaml:"cert_path"`
+	KeyPath           string           `yaml:"key_path"`
+	CertBase64        string           `yaml:"cert_base64"`
+	KeyBase64         string           `yaml:"key_base64"`
+	HTTPProxy         string           `yaml:"http_proxy"`
+	FeedbackURL       string           `yaml:"feedback_hook_url"`
+	FeedbackTimeout   int64            `yaml:"feedback_timeout"`
+	PID               SectionPID       `yaml:"pid"`
+	Autoscale         SectionAutoscale `yaml:"autoscale"`
+	AutoTLS           SectionAutoTLS   `yaml:"auto_tls"`
 }
 
 // SectionAPI is sub section of config.
 type SectionAPI struct {
 	PushURI    string `yaml:"push_uri"`
+	ScrapeURI  string `yaml:"scrape_uri"`
 	StatGoURI  string `yaml:"stat_go_uri"`
 	StatAppURI string `yaml:"stat_app_uri"`
 	ConfigURI  string `yaml:"config_uri"`
@@ -56,17 +63,160 @@ type SectionAPI struct {
 	HealthURI  string `yaml:"health_uri"`
 }
 
-// SourceAPI
+// SourceAPI is the config of a scrape source, the source block is the
+// one of the default source when sources has no block for it.
 type SourceAPI struct {
-	BaseURI               string `yaml:"base_uri"`
-	CtxTimeout            int    `yaml:"ctx_timeout"`
-	CtxKeepAlive          int    `yaml:"ctx_keepalive"`
-	MaxIdleConnsPerHost   int    `yaml:"max_idle_cons_per_host"`
-	MaxIdleConns          int    `yaml:"max_idle_con"`
-	IdleConnTimeout       int    `yaml:"idle_con_timeout"`
-	TLSHandshakeTimeout   int    `yaml:"tls_handshake_timeout"`
-	ExpectContinueTimeout int    `yaml:"expect_continue_timeout"`
-	HttpTimeout           int    `yaml:"http_timeout"`
+	BaseURI               string            `yaml:"base_uri"`
+	Headers               map[string]string `yaml:"headers"`
+	CtxTimeout            int               `yaml:"ctx_timeout"`
+	CtxKeepAlive          int               `yaml:"ctx_keepalive"`
+	MaxIdleConnsPerHost   int               `yaml:"max_idle_cons_per_host"`
+	MaxIdleConns          int               `yaml:"max_idle_con"`
+	IdleConnTimeout       int               `yaml:"idle_con_timeout"`
+	TLSHandshakeTimeout   int               `yaml:"tls_handshake_timeout"`
+	ExpectContinueTimeout int               `yaml:"expect_continue_timeout"`
+	HttpTimeout           int               `yaml:"http_timeout"`
+	MaxConnsPerHost       int               `yaml:"max_cons_per_host"`
+	Proxy                 string            `yaml:"proxy"`
+	CookieJar             bool              `yaml:"cookie_jar"`
+	TLS                   SectionSourceTLS  `yaml:"tls"`
+	RateLimit             SectionRateLimit  `yaml:"rate_limit"`
+	Breaker               SectionBreaker    `yaml:"breaker"`
+	Cache                 SectionCache      `yaml:"cache"`
+	Strict                bool              `yaml:"strict"`
+	AUMUnit               string            `yaml:"aum_unit"`
+	RequestTimeout        int               `yaml:"request_timeout"`
+	Retry                 SectionRetry      `yaml:"retry"`
+}
+
+// SourceConfig returns the config of the scrape source
+func (c ConfYaml) SourceConfig(name string) (SourceAPI, bool) {
+	if src, ok := c.Sources[name]; ok {
+		return src, true
+	}
+	if name == DefaultSource {
+		return c.Source, true
+	}
+	return SourceAPI{}, false
+}
+
+// SectionSourceTLS is sub section of config.
+type SectionSourceTLS struct {
+	CAPath             string `yaml:"ca_path"`
+	CertPath           string `yaml:"cert_path"`
+	KeyPath            string `yaml:"key_path"`
+	MinVersion         string `yaml:"min_version"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
+}
+
+// SectionRateLimit is sub section of config.
+type SectionRateLimit struct {
+	// Rate in request per second, zero is unlimited
+	Rate          float64 `yaml:"rate"`
+	Burst         int     `yaml:"burst"`
+	MaxConcurrent int     `yaml:"max_concurrent"`
+	// MinDelay and MaxDelay in millisecond
+	MinDelay int `yaml:"min_delay"`
+	MaxDelay int `yaml:"max_delay"`
+	// GlobalRate in request per second shared by the instances
+	GlobalRate int64 `yaml:"global_rate"`
+}
+
+// SectionBreaker is sub section of config.
+type SectionBreaker struct {
+	// Failures in a row opening the breaker, zero is disabled
+	Failures int `yaml:"failures"`
+	// ErrorRate of the window opening the breaker, zero is disabled
+	ErrorRate   float64 `yaml:"error_rate"`
+	MinRequests int     `yaml:"min_requests"`
+	// Window and OpenTimeout in second
+	Window           int `yaml:"window"`
+	OpenTimeout      int `yaml:"open_timeout"`
+	HalfOpenRequests int `yaml:"half_open_requests"`
+}
+
+// SectionCache is sub section of config.
+type SectionCache struct {
+	// TTL in second a response is served without request, zero is disabled
+	TTL int `yaml:"ttl"`
+	// StaleTTL in second a stale response is kept for conditional requests
+	StaleTTL int `yaml:"stale_ttl"`
+}
+
+// SectionRetry is sub section of config.
+type SectionRetry struct {
+	MaxAttempts int     `yaml:"max_attempts"`
+	Backoff     int     `yaml:"backoff"`
+	MaxBackoff  int     `yaml:"max_backoff"`
+	Jitter      float64 `yaml:"jitter"`
+	Statuses    []int   `yaml:"statuses"`
+}
+
+// DefaultRetry returns the retry policy used when source.retry is not set.
+func DefaultRetry() SectionRetry {
+	return SectionRetry{
+		MaxAttempts: 3,
+		Backoff:     500,
+		MaxBackoff:  10000,
+		Jitter:      0.2,
+		Statuses:    []int{429, 502, 503, 504},
+	}
+}
+
+// SectionScrape is sub section of config.
+type SectionScrape struct {
+	DefaultPreset string                   `yaml:"default_preset"`
+	Presets       map[string]SectionPreset `yaml:"presets"`
+}
+
+// SectionPreset is the query form sent to source_json_for_favorite.php
+type SectionPreset struct {
+	FundTypes       []string `yaml:"fund_types" json:"fund_types"`
+	HiLoSelect      string   `yaml:"hiloselect" json:"hiloselect"`
+	PerformanceType string   `yaml:"performance_type" json:"performance_type"`
+	Availability    string   `yaml:"availability" json:"availability"`
+	AUMLowerCheck   bool     `yaml:"aum_lower_check" json:"aum_lower_check"`
+	AUMLowerValue   int64    `yaml:"aum_lower_value" json:"aum_lower_value"`
+	AUMBetweenCheck bool     `yaml:"aum_between_check" json:"aum_between_check"`
+	AUMBetweenLow   int64    `yaml:"aum_between_low" json:"aum_between_low"`
+	AUMBetweenHigh  int64    `yaml:"aum_between_high" json:"aum_between_high"`
+	AUMGreaterCheck bool     `yaml:"aum_greater_check" json:"aum_greater_check"`
+	AUMGreaterValue int64    `yaml:"aum_greater_value" json:"aum_greater_value"`
+	FundNonSyariah  bool     `yaml:"fund_non_syariah" json:"fund_non_syariah"`
+	FundSyariah     bool     `yaml:"fund_syariah" json:"fund_syariah"`
+	ETFNonSyariah   bool     `yaml:"etf_non_syariah" json:"etf_non_syariah"`
+	ETFSyariah      bool     `yaml:"etf_syariah" json:"etf_syariah"`
+}
+
+// DefaultPreset is the preset used when nothing is configured,
+// it returns every available fund.
+func DefaultPreset() SectionPreset {
+	return SectionPreset{
+		FundTypes:       []string{"mm", "fi", "balance", "equity"},
+		HiLoSelect:      "1yr",
+		PerformanceType: "nav",
+		Availability:    "available",
+		AUMLowerCheck:   true,
+		AUMLowerValue:   500,
+		AUMBetweenCheck: true,
+		AUMBetweenLow:   500,
+		AUMBetweenHigh:  2000,
+		AUMGreaterCheck: true,
+		AUMGreaterValue: 2000,
+		FundNonSyariah:  true,
+		FundSyariah:     true,
+		ETFNonSyariah:   true,
+		ETFSyariah:      true,
+	}
+}
+
+// SectionSchedule is sub section of config.
+type SectionSchedule struct {
+	Enabled  bool              `yaml:"enabled"`
+	Timezone string            `yaml:"timezone"`
+	Jitter   int64             `yaml:"jitter"`
+	Holidays []string          `yaml:"holidays"`
+	Jobs     map[string]string `yaml:"jobs"`
 }
 
 // SectionLog is sub section of config.
@@ -81,14 +231,38 @@ type SectionLog struct {
 
 // SectionStat is sub section of config.
 type SectionStat struct {
-	Engine string       `yaml:"engine"`
-	Redis  SectionRedis `yaml:"redis"`
+	Engine   string          `yaml:"engine"`
+	Redis    SectionRedis    `yaml:"redis"`
+	Snapshot SectionSnapshot `yaml:"snapshot"`
+}
+
+// SectionSnapshot is sub section of config.
+type SectionSnapshot struct {
+	Engine string `yaml:"engine"`
+	Path   string `yaml:"path"`
 }
 
 // SectionQueue is sub section of config.
 type SectionQueue struct {
-	Engine string     `yaml:"engine"`
-	NSQ    SectionNSQ `yaml:"nsq"`
+	Engine string             `yaml:"engine"`
+	NSQ    SectionNSQ         `yaml:"nsq"`
+	NATS   SectionNATS        `yaml:"nats"`
+	Redis  SectionRedisStream `yaml:"redis"`
+	Disk   SectionDisk        `yaml:"disk"`
+	Lanes  SectionLanes       `yaml:"lanes"`
+}
+
+// SectionLanes is sub section of config.
+type SectionLanes struct {
+	High   SectionLane `yaml:"high"`
+	Normal SectionLane `yaml:"normal"`
+	Low    SectionLane `yaml:"low"`
+}
+
+// SectionLane is sub section of config.
+type SectionLane struct {
+	Weight   int `yaml:"weight"`
+	Capacity int `yaml:"capacity"`
 }
 
 // SectionNSQ is sub section of config.
@@ -96,6 +270,37 @@ type SectionNSQ struct {
 	Addr    string `yaml:"addr"`
 	Topic   string `yaml:"topic"`
 	Channel string `yaml:"channel"`
+	// MaxReqTimeout in second is the --max-req-timeout of nsqd, the max
+	// delay of a job
+	MaxReqTimeout int `yaml:"max_req_timeout"`
+}
+
+// SectionNATS is sub section of config.
+type SectionNATS struct {
+	Addr       string `yaml:"addr"`
+	Stream     string `yaml:"stream"`
+	Subject    string `yaml:"subject"`
+	Durable    string `yaml:"durable"`
+	MaxDeliver int    `yaml:"max_deliver"`
+	AckWait    int    `yaml:"ack_wait"`
+	NakDelay   int    `yaml:"nak_delay"`
+}
+
+// SectionRedisStream is sub section of config.
+type SectionRedisStream struct {
+	Addr       string `yaml:"addr"`
+	Password   string `yaml:"password"`
+	DB         int    `yaml:"db"`
+	Stream     string `yaml:"stream"`
+	Group      string `yaml:"group"`
+	Consumer   string `yaml:"consumer"`
+	MaxDeliver int    `yaml:"max_deliver"`
+	ClaimIdle  int    `yaml:"claim_idle"`
+}
+
+// SectionDisk is sub section of config.
+type SectionDisk struct {
+	Path string `yaml:"path"`
 }
 
 // SectionRedis is sub section of config.
@@ -119,6 +324,15 @@ type SectionPID struct {
 	Override bool   `yaml:"override"`
 }
 
+// SectionAutoscale is sub section of config.
+type SectionAutoscale struct {
+	Enabled    bool  `yaml:"enabled"`
+	MinWorkers int64 `yaml:"min_workers"`
+	MaxWorkers int64 `yaml:"max_workers"`
+	Interval   int64 `yaml:"interval"`
+	MaxLatency int64 `yaml:"max_latency"`
+}
+
 // LoadConf load config from file and read in environment variables that match
 func LoadConf(confPath ...string) (ConfYaml, error) {
 	var conf ConfYaml
@@ -163,6 +377,8 @@ func LoadConf(confPath ...string) (ConfYaml, error) {
 	conf.Core.QueueNum = int64(viper.GetInt("core.queue_num"))
 	conf.Core.Mode = viper.GetString("core.mode")
 	conf.Core.Sync = viper.GetBool("core.sync")
+	conf.Core.IdempotencyWindow = viper.GetInt64("core.idempotency_window")
+	conf.Core.MaxPanics = viper.GetInt64("core.max_panics")
 	conf.Core.FeedbackURL = viper.GetString("core.feedback_hook_url")
 	conf.Core.FeedbackTimeout = int64(viper.GetInt("core.feedback_timeout"))
 	conf.Core.SSL = viper.GetBool("core.ssl")
@@ -175,12 +391,18 @@ func LoadConf(confPath ...string) (ConfYaml, error) {
 	conf.Core.PID.Enabled = viper.GetBool("core.pid.enabled")
 	conf.Core.PID.Path = viper.GetString("core.pid.path")
 	conf.Core.PID.Override = viper.GetBool("core.pid.override")
+	conf.Core.Autoscale.Enabled = viper.GetBool("core.autoscale.enabled")
+	conf.Core.Autoscale.MinWorkers = viper.GetInt64("core.autoscale.min_workers")
+	conf.Core.Autoscale.MaxWorkers = viper.GetInt64("core.autoscale.max_workers")
+	conf.Core.Autoscale.Interval = viper.GetInt64("core.autoscale.interval")
+	conf.Core.Autoscale.MaxLatency = viper.GetInt64("core.autoscale.max_latency")
 	conf.Core.AutoTLS.Enabled = viper.GetBool("core.auto_tls.enabled")
 	conf.Core.AutoTLS.Folder = viper.GetString("core.auto_tls.folder")
 	conf.Core.AutoTLS.Host = viper.GetString("core.auto_tls.host")
 
 	// Api
 	conf.API.PushURI = viper.GetString("api.push_uri")
+	conf.API.ScrapeURI = viper.GetString("api.scrape_uri")
 	conf.API.StatGoURI = viper.GetString("api.stat_go_uri")
 	conf.API.StatAppURI = viper.GetString("api.stat_app_uri")
 	conf.API.ConfigURI = viper.GetString("api.config_uri")
@@ -188,16 +410,39 @@ func LoadConf(confPath ...string) (ConfYaml, error) {
 	conf.API.MetricURI = viper.GetString("api.metric_uri")
 	conf.API.HealthURI = viper.GetString("api.health_uri")
 
+	if conf.API.ScrapeURI == "" {
+		conf.API.ScrapeURI = "/api/scrape"
+	}
+
 	// Source
-	conf.Source.BaseURI = viper.GetString("source.base_uri")
-	conf.Source.CtxTimeout = viper.GetInt("source.ctx_timeout")
-	conf.Source.CtxKeepAlive = viper.GetInt("source.ctx_keepalive")
-	conf.Source.MaxIdleConnsPerHost = viper.GetInt("source.max_idle_cons_per_host")
-	conf.Source.MaxIdleConns = viper.GetInt("source.max_idle_con")
-	conf.Source.IdleConnTimeout = viper.GetInt("source.idle_con_timeout")
-	conf.Source.TLSHandshakeTimeout = viper.GetInt("source.tls_handshake_timeout")
-	conf.Source.ExpectContinueTimeout = viper.GetInt("source.expect_continue_timeout")
-	conf.Source.HttpTimeout = viper.GetInt("source.http_timeout")
+	conf.Source = loadSource("source.")
+	conf.Sources = map[string]SourceAPI{}
+	for name := range viper.GetStringMap("sources") {
+		conf.Sources[name] = loadSource("sources." + name + ".")
+	}
+
+	// Scrape
+	conf.Scrape.DefaultPreset = viper.GetString("scrape.default_preset")
+	conf.Scrape.Presets = map[string]SectionPreset{}
+	for name := range viper.GetStringMap("scrape.presets") {
+		conf.Scrape.Presets[name] = loadPreset("scrape.presets." + name + ".")
+	}
+	if conf.Scrape.DefaultPreset == "" {
+		conf.Scrape.DefaultPreset = "default"
+	}
+	if _, ok := conf.Scrape.Presets[conf.Scrape.DefaultPreset]; !ok {
+		conf.Scrape.Presets[conf.Scrape.DefaultPreset] = DefaultPreset()
+	}
+
+	// Schedule
+	conf.Schedule.Enabled = viper.GetBool("schedule.enabled")
+	conf.Schedule.Timezone = viper.GetString("schedule.timezone")
+	conf.Schedule.Jitter = int64(viper.GetInt("schedule.jitter"))
+	conf.Schedule.Holidays = viper.GetStringSlice("schedule.holidays")
+	conf.Schedule.Jobs = viper.GetStringMapString("schedule.jobs")
+	if conf.Schedule.Timezone == "" {
+		conf.Schedule.Timezone = "Asia/Jakarta"
+	}
 
 	// log
 	conf.Log.Format = viper.GetString("log.format")
@@ -212,20 +457,218 @@ func LoadConf(confPath ...string) (ConfYaml, error) {
 	conf.Queue.NSQ.Addr = viper.GetString("queue.nsq.addr")
 	conf.Queue.NSQ.Topic = viper.GetString("queue.nsq.topic")
 	conf.Queue.NSQ.Channel = viper.GetString("queue.nsq.channel")
+	conf.Queue.NSQ.MaxReqTimeout = viper.GetInt("queue.nsq.max_req_timeout")
+	conf.Queue.NATS.Addr = viper.GetString("queue.nats.addr")
+	conf.Queue.NATS.Stream = viper.GetString("queue.nats.stream")
+	conf.Queue.NATS.Subject = viper.GetString("queue.nats.subject")
+	conf.Queue.NATS.Durable = viper.GetString("queue.nats.durable")
+	conf.Queue.NATS.MaxDeliver = viper.GetInt("queue.nats.max_deliver")
+	conf.Queue.NATS.AckWait = viper.GetInt("queue.nats.ack_wait")
+	conf.Queue.NATS.NakDelay = viper.GetInt("queue.nats.nak_delay")
+	conf.Queue.Redis.Addr = viper.GetString("queue.redis.addr")
+	conf.Queue.Redis.Password = viper.GetString("queue.redis.password")
+	conf.Queue.Redis.DB = viper.GetInt("queue.redis.db")
+	conf.Queue.Redis.Stream = viper.GetString("queue.redis.stream")
+	conf.Queue.Redis.Group = viper.GetString("queue.redis.group")
+	conf.Queue.Redis.Consumer = viper.GetString("queue.redis.consumer")
+	conf.Queue.Redis.MaxDeliver = viper.GetInt("queue.redis.max_deliver")
+	conf.Queue.Redis.ClaimIdle = viper.GetInt("queue.redis.claim_idle")
+	conf.Queue.Disk.Path = viper.GetString("queue.disk.path")
+	conf.Queue.Lanes.High.Weight = viper.GetInt("queue.lanes.high.weight")
+	conf.Queue.Lanes.High.Capacity = viper.GetInt("queue.lanes.high.capacity")
+	conf.Queue.Lanes.Normal.Weight = viper.GetInt("queue.lanes.normal.weight")
+	conf.Queue.Lanes.Normal.Capacity = viper.GetInt("queue.lanes.normal.capacity")
+	conf.Queue.Lanes.Low.Weight = viper.GetInt("queue.lanes.low.weight")
+	conf.Queue.Lanes.Low.Capacity = viper.GetInt("queue.lanes.low.capacity")
 
 	// Stat Engine
 	conf.Stat.Engine = viper.GetString("stat.engine")
 	conf.Stat.Redis.Addr = viper.GetString("stat.redis.addr")
 	conf.Stat.Redis.Password = viper.GetString("stat.redis.password")
 	conf.Stat.Redis.DB = viper.GetInt("stat.redis.db")
+	conf.Stat.Snapshot.Engine = viper.GetString("stat.snapshot.engine")
+	conf.Stat.Snapshot.Path = viper.GetString("stat.snapshot.path")
+
+	// the redis queue shares the stat redis unless it has its own address
+	if conf.Queue.Redis.Addr == "" {
+		conf.Queue.Redis.Addr = conf.Stat.Redis.Addr
+		conf.Queue.Redis.Password = conf.Stat.Redis.Password
+		conf.Queue.Redis.DB = conf.Stat.Redis.DB
+	}
+
+	if conf.Queue.Lanes.High.Weight == 0 {
+		conf.Queue.Lanes.High.Weight = 6
+	}
+	if conf.Queue.Lanes.Normal.Weight == 0 {
+		conf.Queue.Lanes.Normal.Weight = 3
+	}
+	if conf.Queue.Lanes.Low.Weight == 0 {
+		conf.Queue.Lanes.Low.Weight = 1
+	}
+
+	if conf.Core.IdempotencyWindow == int64(0) {
+		conf.Core.IdempotencyWindow = int64(300)
+	}
+
+	if conf.Core.MaxPanics == int64(0) {
+		conf.Core.MaxPanics = int64(3)
+	}
+
+	if conf.Core.ShutdownTimeout == int64(0) {
+		conf.Core.ShutdownTimeout = int64(30)
+	}
 
 	if conf.Core.WorkerNum == int64(0) {
 		conf.Core.WorkerNum = int64(runtime.NumCPU())
 	}
 
+	if conf.Core.Autoscale.MinWorkers == int64(0) {
+		conf.Core.Autoscale.MinWorkers = int64(1)
+	}
+
+	if conf.Core.Autoscale.MaxWorkers == int64(0) {
+		conf.Core.Autoscale.MaxWorkers = conf.Core.WorkerNum * 4
+	}
+
+	if conf.Core.Autoscale.Interval == int64(0) {
+		conf.Core.Autoscale.Interval = int64(10)
+	}
+
 	if conf.Core.QueueNum == int64(0) {
 		conf.Core.QueueNum = int64(8192)
 	}
 
 	return conf, nil
 }
+
+// loadPreset read a preset, keys which are not set keep the DefaultPreset value.
+func loadPreset(prefix string) SectionPreset {
+	p := DefaultPreset()
+
+	if viper.IsSet(prefix + "fund_types") {
+		p.FundTypes = viper.GetStringSlice(prefix + "fund_types")
+	}
+	if viper.IsSet(prefix + "hiloselect") {
+		p.HiLoSelect = viper.GetString(prefix + "hiloselect")
+	}
+	if viper.IsSet(prefix + "performance_type") {
+		p.PerformanceType = viper.GetString(prefix + "performance_type")
+	}
+	if viper.IsSet(prefix + "availability") {
+		p.Availability = viper.GetString(prefix + "availability")
+	}
+	if viper.IsSet(prefix + "aum_lower_check") {
+		p.AUMLowerCheck = viper.GetBool(prefix + "aum_lower_check")
+	}
+	if viper.IsSet(prefix + "aum_lower_value") {
+		p.AUMLowerValue = viper.GetInt64(prefix + "aum_lower_value")
+	}
+	if viper.IsSet(prefix + "aum_between_check") {
+		p.AUMBetweenCheck = viper.GetBool(prefix + "aum_between_check")
+	}
+	if viper.IsSet(prefix + "aum_between_low") {
+		p.AUMBetweenLow = viper.GetInt64(prefix + "aum_between_low")
+	}
+	if viper.IsSet(prefix + "aum_between_high") {
+		p.AUMBetweenHigh = viper.GetInt64(prefix + "aum_between_high")
+	}
+	if viper.IsSet(prefix + "aum_greater_check") {
+		p.AUMGreaterCheck = viper.GetBool(prefix + "aum_greater_check")
+	}
+	if viper.IsSet(prefix + "aum_greater_value") {
+		p.AUMGreaterValue = viper.GetInt64(prefix + "aum_greater_value")
+	}
+	if viper.IsSet(prefix + "fund_non_syariah") {
+		p.FundNonSyariah = viper.GetBool(prefix + "fund_non_syariah")
+	}
+	if viper.IsSet(prefix + "fund_syariah") {
+		p.FundSyariah = viper.GetBool(prefix + "fund_syariah")
+	}
+	if viper.IsSet(prefix + "etf_non_syariah") {
+		p.ETFNonSyariah = viper.GetBool(prefix + "etf_non_syariah")
+	}
+	if viper.IsSet(prefix + "etf_syariah") {
+		p.ETFSyariah = viper.GetBool(prefix + "etf_syariah")
+	}
+
+	return p
+}
+
+// loadSource read the source block under prefix
+func loadSource(prefix string) SourceAPI {
+	src := SourceAPI{
+		BaseURI:               viper.GetString(prefix + "base_uri"),
+		Headers:               viper.GetStringMapString(prefix + "headers"),
+		CtxTimeout:            viper.GetInt(prefix + "ctx_timeout"),
+		CtxKeepAlive:          viper.GetInt(prefix + "ctx_keepalive"),
+		MaxIdleConnsPerHost:   viper.GetInt(prefix + "max_idle_cons_per_host"),
+		MaxIdleConns:          viper.GetInt(prefix + "max_idle_con"),
+		IdleConnTimeout:       viper.GetInt(prefix + "idle_con_timeout"),
+		TLSHandshakeTimeout:   viper.GetInt(prefix + "tls_handshake_timeout"),
+		ExpectContinueTimeout: viper.GetInt(prefix + "expect_continue_timeout"),
+		HttpTimeout:           viper.GetInt(prefix + "http_timeout"),
+		MaxConnsPerHost:       viper.GetInt(prefix + "max_cons_per_host"),
+		Proxy:                 viper.GetString(prefix + "proxy"),
+		CookieJar:             viper.GetBool(prefix + "cookie_jar"),
+		TLS: SectionSourceTLS{
+			CAPath:             viper.GetString(prefix + "tls.ca_path"),
+			CertPath:           viper.GetString(prefix + "tls.cert_path"),
+			KeyPath:            viper.GetString(prefix + "tls.key_path"),
+			MinVersion:         viper.GetString(prefix + "tls.min_version"),
+			InsecureSkipVerify: viper.GetBool(prefix + "tls.insecure_skip_verify"),
+		},
+		Strict:         viper.GetBool(prefix + "strict"),
+		AUMUnit:        viper.GetString(prefix + "aum_unit"),
+		RequestTimeout: viper.GetInt(prefix + "request_timeout"),
+		RateLimit: SectionRateLimit{
+			Rate:          viper.GetFloat64(prefix + "rate_limit.rate"),
+			Burst:         viper.GetInt(prefix + "rate_limit.burst"),
+			MaxConcurrent: viper.GetInt(prefix + "rate_limit.max_concurrent"),
+			MinDelay:      viper.GetInt(prefix + "rate_limit.min_delay"),
+			MaxDelay:      viper.GetInt(prefix + "rate_limit.max_delay"),
+			GlobalRate:    viper.GetInt64(prefix + "rate_limit.global_rate"),
+		},
+		Breaker: SectionBreaker{
+			Failures:         viper.GetInt(prefix + "breaker.failures"),
+			ErrorRate:        viper.GetFloat64(prefix + "breaker.error_rate"),
+			MinRequests:      viper.GetInt(prefix + "breaker.min_requests"),
+			Window:           viper.GetInt(prefix + "breaker.window"),
+			OpenTimeout:      viper.GetInt(prefix + "breaker.open_timeout"),
+			HalfOpenRequests: viper.GetInt(prefix + "breaker.half_open_requests"),
+		},
+		Cache: SectionCache{
+			TTL:      viper.GetInt(prefix + "cache.ttl"),
+			StaleTTL: viper.GetInt(prefix + "cache.stale_ttl"),
+		},
+		Retry: loadRetry(prefix + "retry."),
+	}
+	if src.RequestTimeout == 0 {
+		src.RequestTimeout = 30
+	}
+
+	return src
+}
+
+// loadRetry read the retry policy under prefix, unset keys keep the
+// DefaultRetry value.
+func loadRetry(prefix string) SectionRetry {
+	r := DefaultRetry()
+
+	if viper.IsSet(prefix + "max_attempts") {
+		r.MaxAttempts = viper.GetInt(prefix + "max_attempts")
+	}
+	if viper.IsSet(prefix + "backoff") {
+		r.Backoff = viper.GetInt(prefix + "backoff")
+	}
+	if viper.IsSet(prefix + "max_backoff") {
+		r.MaxBackoff = viper.GetInt(prefix + "max_backoff")
+	}
+	if viper.IsSet(prefix + "jitter") {
+		r.Jitter = viper.GetFloat64(prefix + "jitter")
+	}
+	if viper.IsSet(prefix + "statuses") {
+		r.Statuses = viper.GetIntSlice(prefix + "statuses")
+	}
+
+	return r
+}
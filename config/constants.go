@@ -4,4 +4,6 @@ const (
 	// DefaultPort is the default port of the application server
 	DefaultPort      = 8090
 	DefaultUserAgent = "go_scrape"
+	// DefaultSource is the scrape source of the jobs without source
+	DefaultSource = "indopremier"
 )
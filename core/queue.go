@@ -10,6 +10,10 @@ var (
 	NSQ Queue = "nsq"
 	// NATS Connective Technology for Adaptive Edge & Distributed Systems
 	NATS Queue = "nats"
+	// RedisStream consumer group on a Redis stream
+	RedisStream Queue = "redis"
+	// DiskQueue for local queue persisted on disk
+	DiskQueue Queue = "disk"
 )
 
 // IsLocalQueue check is Local Queue
@@ -0,0 +1,121 @@
+package fund
+
+// Column is the position of a value inside a single aaData row
+// returned by source_json_for_favorite.php.
+type Column int
+
+const (
+	// ColID is the internal fund id
+	ColID Column = 0
+	// ColCode is the fund product code
+	ColCode Column = 1
+	// ColName is the fund name
+	ColName Column = 2
+	// ColManager is the investment manager
+	ColManager Column = 3
+	// ColType is the fund type (mm, fi, balance, equity)
+	ColType Column = 4
+	// ColLastNAV is the last net asset value per unit
+	ColLastNAV Column = 5
+	// ColReturn1D is the 1 day return
+	ColReturn1D Column = 6
+	// ColReturn3D is the 3 day return
+	ColReturn3D Column = 7
+	// ColReturn1M is the 1 month return
+	ColReturn1M Column = 8
+	// ColReturn3M is the 3 month return
+	ColReturn3M Column = 9
+	// ColReturn6M is the 6 month return
+	ColReturn6M Column = 10
+	// ColReturn9M is the 9 month return
+	ColReturn9M Column = 11
+	// ColReturnYTD is the year to date return
+	ColReturnYTD Column = 12
+	// ColReturn1Y is the 1 year return
+	ColReturn1Y Column = 13
+	// ColReturn3Y is the 3 year return
+	ColReturn3Y Column = 14
+	// ColReturn5Y is the 5 year return
+	ColReturn5Y Column = 15
+	// ColHiLo is the hi-lo range for the selected window
+	ColHiLo Column = 16
+	// ColSharpe is the sharpe ratio
+	ColSharpe Column = 17
+	// ColDrawdown is the maximum drawdown
+	ColDrawdown Column = 18
+	// ColDrawdownPeriod is the maximum drawdown period
+	ColDrawdownPeriod Column = 19
+	// ColHistRisk is the historical risk
+	ColHistRisk Column = 22
+	// ColAUM is the asset under management
+	ColAUM Column = 23
+
+	// MinColumns is the minimum row length needed to read every known column
+	MinColumns = int(ColAUM) + 1
+)
+
+var columnNames = map[Column]string{
+	ColID:             "id",
+	ColCode:           "code",
+	ColName:           "name",
+	ColManager:        "manager",
+	ColType:           "type",
+	ColLastNAV:        "last_nav",
+	ColReturn1D:       "return_1d",
+	ColReturn3D:       "return_3d",
+	ColReturn1M:       "return_1m",
+	ColReturn3M:       "return_3m",
+	ColReturn6M:       "return_6m",
+	ColReturn9M:       "return_9m",
+	ColReturnYTD:      "return_ytd",
+	ColReturn1Y:       "return_1y",
+	ColReturn3Y:       "return_3y",
+	ColReturn5Y:       "return_5y",
+	ColHiLo:           "hi_lo",
+	ColSharpe:         "sharpe",
+	ColDrawdown:       "drawdown",
+	ColDrawdownPeriod: "drawdown_period",
+	ColHistRisk:       "hist_risk",
+	ColAUM:            "aum",
+}
+
+// String returns the json field name of the column.
+func (c Column) String() string {
+	if name, ok := columnNames[c]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// Returns holds the performance of a fund over several windows, in percent.
+// A nil value means the source has no data for the window.
+type Returns struct {
+	OneDay     *float64 `json:"1d"`
+	ThreeDay   *float64 `json:"3d"`
+	OneMonth   *float64 `json:"1m"`
+	ThreeMonth *float64 `json:"3m"`
+	SixMonth   *float64 `json:"6m"`
+	NineMonth  *float64 `json:"9m"`
+	YTD        *float64 `json:"ytd"`
+	OneYear    *float64 `json:"1y"`
+	ThreeYear  *float64 `json:"3y"`
+	FiveYear   *float64 `json:"5y"`
+}
+
+// Fund is a single mutual fund row, missing numeric values are nil.
+type Fund struct {
+	ID             string   `json:"id"`
+	Code           string   `json:"code"`
+	Name           string   `json:"name"`
+	Manager        string   `json:"manager"`
+	Type           string   `json:"type"`
+	LastNAV        *float64 `json:"last_nav"`
+	Returns        Returns  `json:"returns"`
+	HiLo           *float64 `json:"hi_lo"`
+	Sharpe         *float64 `json:"sharpe"`
+	Drawdown       *float64 `json:"drawdown"`
+	DrawdownPeriod string   `json:"drawdown_period"`
+	HistRisk       *float64 `json:"hist_risk"`
+	// AUM is asset under management in IDR
+	AUM *float64 `json:"aum"`
+}
@@ -3,18 +3,27 @@ module github.com/natansdj/go_scrape
 go 1.16
 
 require (
+	github.com/alicebob/miniredis/v2 v2.23.0
 	github.com/appleboy/gin-status-api v1.1.0
 	github.com/gin-contrib/logger v0.2.0
 	github.com/gin-gonic/gin v1.7.2
 	github.com/go-redis/redis/v7 v7.4.1
 	github.com/json-iterator/go v1.1.11
 	github.com/mattn/go-isatty v0.0.12
+	github.com/modern-go/reflect2 v1.0.2 // indirect
+	github.com/nats-io/nats-server/v2 v2.7.4
+	github.com/nats-io/nats.go v1.14.0
+	github.com/nsqio/go-nsq v1.1.0
+	github.com/nsqio/nsq v1.2.1
 	github.com/prometheus/client_golang v1.11.0
+	github.com/robfig/cron/v3 v3.0.1
 	github.com/rs/zerolog v1.23.0
 	github.com/sirupsen/logrus v1.8.1
 	github.com/spf13/viper v1.8.1
 	github.com/stretchr/testify v1.7.0
 	github.com/thoas/stats v0.0.0-20190407194641-965cb2de1678
-	golang.org/x/crypto v0.0.0-20200622213623-75b288015ac9
+	go.etcd.io/bbolt v1.3.6
+	golang.org/x/crypto v0.0.0-20220112180741-5e0467b6c7ce
 	golang.org/x/sync v0.0.0-20210220032951-036812b2e83c
+	golang.org/x/time v0.0.0-20211116232009-f0f3c7e86c11
 )
@@ -0,0 +1,95 @@
+package go_scrape
+
+import (
+	"context"
+	"sync"
+
+	"github.com/natansdj/go_scrape/queue"
+	"github.com/natansdj/go_scrape/storage"
+)
+
+// runningJob is the cancel func of a running job and the copy of the job
+// abandoned when it's cancelled on shutdown
+type runningJob struct {
+	cancel context.CancelFunc
+	job    *ScrapeJob
+}
+
+// running keep the jobs running in this process
+var running = struct {
+	sync.Mutex
+	jobs map[string]runningJob
+}{
+	jobs: map[string]runningJob{},
+}
+
+// jobContext returns the context of the run, done when the job is cancelled,
+// its deadline passes or the context of the caller is done. The returned
+// func must be called when the run finishes.
+func jobContext(job *ScrapeJob) (context.Context, context.CancelFunc) {
+	ctx := job.Context()
+	stop := func() {}
+	if job.Deadline != nil {
+		ctx, stop = context.WithDeadline(ctx, *job.Deadline)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	if job.ID != "" {
+		abandoned := *job
+		abandoned.Wg, abandoned.Log, abandoned.Ctx = nil, nil, nil
+		abandoned.Attempt--
+		abandoned.History = append([]storage.Attempt{}, job.History...)
+
+		running.Lock()
+		running.jobs[job.ID] = runningJob{cancel: cancel, job: &abandoned}
+		running.Unlock()
+	}
+
+	return ctx, func() {
+		finishRunning(job.ID)
+		cancel()
+		stop()
+	}
+}
+
+// finishRunning unregister the job once its run is over, it reports false
+// when the job was taken by CancelRunning and is abandoned on shutdown.
+func finishRunning(id string) bool {
+	if id == "" {
+		return true
+	}
+
+	running.Lock()
+	defer running.Unlock()
+	_, ok := running.jobs[id]
+	delete(running.jobs, id)
+	return ok
+}
+
+// cancelRunning stop the job running in this process, it reports whether
+// the job is found.
+func cancelRunning(id string) bool {
+	running.Lock()
+	r, ok := running.jobs[id]
+	running.Unlock()
+
+	if ok {
+		r.cancel()
+	}
+	return ok
+}
+
+// CancelRunning stop the jobs running in this process on shutdown, it
+// returns them to be abandoned. Their runs record no outcome once they stop.
+func CancelRunning() []queue.QueuedMessage {
+	running.Lock()
+	defer running.Unlock()
+
+	msgs := make([]queue.QueuedMessage, 0, len(running.jobs))
+	for id, r := range running.jobs {
+		r.cancel()
+		msgs = append(msgs, r.job)
+		delete(running.jobs, id)
+	}
+	return msgs
+}
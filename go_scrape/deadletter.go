@@ -0,0 +1,107 @@
+package go_scrape
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/natansdj/go_scrape/config"
+	"github.com/natansdj/go_scrape/logx"
+	"github.com/natansdj/go_scrape/queue"
+	"github.com/natansdj/go_scrape/status"
+	"github.com/natansdj/go_scrape/storage"
+)
+
+// DeadLetter keep the message which can't be completed with its last error
+// and attempt history, so it can be inspected and requeued later.
+func DeadLetter(msg queue.QueuedMessage, err error) {
+	dl := &storage.DeadLetter{
+		ID:        NewJobID(),
+		Payload:   msg.Bytes(),
+		Error:     err.Error(),
+		Attempts:  []storage.Attempt{},
+		CreatedAt: time.Now(),
+	}
+
+	switch v := msg.(type) {
+	case *ScrapeJob:
+		dl.Kind = storage.KindScrape
+		dl.JobID = v.ID
+		dl.Source = v.Source
+		dl.Preset = v.Preset
+		dl.Attempts = append(dl.Attempts, v.History...)
+	case *PushNotification:
+		dl.Kind = storage.KindPush
+		dl.JobID = v.ID
+	case queue.RawMessage:
+		dl.Kind = storage.KindRaw
+		// the payload which is not json is kept as a json string
+		if !json.Valid(v) {
+			dl.Payload, _ = json.Marshal(string(v))
+		}
+	}
+
+	if len(dl.Attempts) == 0 {
+		dl.Attempts = append(dl.Attempts, storage.Attempt{Error: dl.Error, At: dl.CreatedAt, Stack: panicStack(err)})
+	}
+
+	if err := status.StatStorage.SaveDeadLetter(dl); err != nil {
+		logx.LogError.Errorf("can't save dead letter of %s job %s: %v", dl.Kind, dl.JobID, err)
+		return
+	}
+
+	logx.LogError.Errorf("%s job %s moved to dead letter %s: %s", dl.Kind, dl.JobID, dl.ID, dl.Error)
+}
+
+// Abandon move the message not started before the shutdown to the dead
+// letter store, so it can be requeued after the restart.
+func Abandon(msg queue.QueuedMessage) {
+	switch v := msg.(type) {
+	case *ScrapeJob:
+		trackJob(v, storage.JobCancelled, func(rec *storage.Job) {
+			rec.Error = ErrShutdown.Error()
+		})
+		defer v.WaitDone()
+	case *PushNotification:
+		defer v.WaitDone()
+	}
+
+	DeadLetter(msg, ErrShutdown)
+}
+
+// Requeue decode the payload of the dead letter back into the queue and
+// delete the dead letter.
+func Requeue(cfg config.ConfYaml, q *queue.Queue, dl *storage.DeadLetter) error {
+	var err error
+
+	switch dl.Kind {
+	case storage.KindScrape:
+		job := &ScrapeJob{}
+		if err = json.Unmarshal(dl.Payload, job); err != nil {
+			return err
+		}
+		job.Cfg = cfg
+		job.Deadline = nil
+		// a delayed job abandoned on shutdown keeps its due time
+		if at := job.DueAt(); !at.IsZero() {
+			job.RunAt = &at
+		}
+		job.Delay = 0
+		job.ScheduledAt = time.Now()
+		err = EnqueueScrape(q, job)
+	case storage.KindPush:
+		notification := &PushNotification{}
+		if err = json.Unmarshal(dl.Payload, notification); err != nil {
+			return err
+		}
+		notification.Cfg = cfg
+		err = q.Queue(notification)
+	default:
+		return fmt.Errorf("%w: %s", ErrUnknownMessage, dl.Kind)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	return status.StatStorage.DeleteDeadLetter(dl.ID)
+}
@@ -0,0 +1,98 @@
+package go_scrape
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/natansdj/go_scrape/queue"
+	"github.com/natansdj/go_scrape/status"
+	"github.com/natansdj/go_scrape/storage"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeadLetterAndRequeue(t *testing.T) {
+	cfg := testConfig(t)
+
+	job := &ScrapeJob{ID: NewJobID(), Cfg: cfg, Source: "bloomberg"}
+	err := RunScrapeJob(job)
+	assert.Error(t, err)
+	DeadLetter(job, err)
+
+	dls, err := status.StatStorage.ListDeadLetters(0)
+	assert.NoError(t, err)
+	assert.Len(t, dls, 1)
+
+	dl := dls[0]
+	assert.Equal(t, storage.KindScrape, dl.Kind)
+	assert.Equal(t, job.ID, dl.JobID)
+	assert.Equal(t, "bloomberg", dl.Source)
+	assert.Len(t, dl.Attempts, 1)
+	assert.Equal(t, 1, dl.Attempts[0].Attempt)
+
+	w := &fullWorker{}
+	assert.NoError(t, Requeue(cfg, queue.NewQueue(w, 1), &dl))
+	assert.Len(t, w.queued, 1)
+
+	requeued := w.queued[0].(*ScrapeJob)
+	assert.Equal(t, job.ID, requeued.ID)
+	assert.Equal(t, 1, requeued.Attempt)
+	assert.Len(t, requeued.History, 1)
+
+	_, err = status.StatStorage.GetDeadLetter(dl.ID)
+	assert.Equal(t, storage.ErrDeadLetterNotFound, err)
+}
+
+func TestRequeueQueueFull(t *testing.T) {
+	cfg := testConfig(t)
+
+	DeadLetter(&PushNotification{ID: "push"}, errors.New("max capacity reached"))
+	dls, err := status.StatStorage.ListDeadLetters(0)
+	assert.NoError(t, err)
+	assert.Len(t, dls, 1)
+	assert.Equal(t, storage.KindPush, dls[0].Kind)
+	assert.Len(t, dls[0].Attempts, 1)
+
+	w := &fullWorker{queued: []queue.QueuedMessage{&PushNotification{}}}
+	assert.Error(t, Requeue(cfg, queue.NewQueue(w, 1), &dls[0]))
+
+	_, err = status.StatStorage.GetDeadLetter(dls[0].ID)
+	assert.NoError(t, err)
+}
+
+func TestAbandon(t *testing.T) {
+	cfg := testConfig(t)
+
+	wg := &sync.WaitGroup{}
+	job := &ScrapeJob{Cfg: cfg, Wg: wg}
+	job.AddWaitCount()
+	w := &fullWorker{}
+	assert.NoError(t, EnqueueScrape(queue.NewQueue(w, 1), job))
+
+	Abandon(job)
+	wg.Wait()
+
+	rec, err := status.StatStorage.GetJob(job.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, storage.JobCancelled, rec.State)
+	assert.Equal(t, ErrShutdown.Error(), rec.Error)
+
+	dls, err := status.StatStorage.ListDeadLetters(0)
+	assert.NoError(t, err)
+	assert.Len(t, dls, 1)
+	assert.Equal(t, job.ID, dls[0].JobID)
+	assert.Equal(t, ErrShutdown.Error(), dls[0].Error)
+}
+
+func TestDeadLetterRawMessage(t *testing.T) {
+	testConfig(t)
+
+	DeadLetter(queue.RawMessage("not json"), queue.DecodeError(errors.New("invalid character")))
+	dls, err := status.StatStorage.ListDeadLetters(0)
+	assert.NoError(t, err)
+	assert.Len(t, dls, 1)
+	assert.Equal(t, storage.KindRaw, dls[0].Kind)
+	assert.Equal(t, `"not json"`, string(dls[0].Payload))
+	assert.Contains(t, dls[0].Error, "can't decode message")
+}
@@ -1,8 +1,7 @@
 package go_scrape
 
 import (
-	"net/http"
-	"net/url"
+	"context"
 	"sync"
 
 	"github.com/natansdj/go_scrape/config"
@@ -54,9 +53,11 @@ type RequestPush struct {
 
 // PushNotification is single notification request
 type PushNotification struct {
-	Wg  *sync.WaitGroup
-	Log *[]logx.LogPushEntry
-	Cfg config.ConfYaml
+	Wg  *sync.WaitGroup      `json:"-"`
+	Log *[]logx.LogPushEntry `json:"-"`
+	Cfg config.ConfYaml      `json:"-"`
+	// Ctx of the caller, the notification isn't sent once it's done
+	Ctx context.Context `json:"-"`
 
 	// Common
 	ID               string      `json:"notif_id,omitempty"`
@@ -120,6 +121,14 @@ func (p *PushNotification) AddWaitCount() {
 	}
 }
 
+// Context returns the context of the caller, background when not set
+func (p *PushNotification) Context() context.Context {
+	if p.Ctx != nil {
+		return p.Ctx
+	}
+	return context.Background()
+}
+
 // AddLog record fail log of notification
 func (p *PushNotification) AddLog(log logx.LogPushEntry) {
 	if p.Log != nil {
@@ -136,19 +145,6 @@ func (p *PushNotification) Bytes() []byte {
 	return b
 }
 
-// SetProxy only working for FCM server.
-func SetProxy(proxy string) error {
-	proxyURL, err := url.ParseRequestURI(proxy)
-	if err != nil {
-		return err
-	}
-
-	http.DefaultTransport = &http.Transport{Proxy: http.ProxyURL(proxyURL)}
-	logx.LogAccess.Debug("Set http proxy as " + proxy)
-
-	return nil
-}
-
 // SendNotification send notification
 func SendNotification(req queue.QueuedMessage) {
 	v, _ := req.(*PushNotification)
@@ -157,6 +153,11 @@ func SendNotification(req queue.QueuedMessage) {
 		v.WaitDone()
 	}()
 
+	if err := v.Context().Err(); err != nil {
+		logx.LogAccess.Infof("skip push notification %s: %v", v.ID, err)
+		return
+	}
+
 	switch v.Platform {
 	default:
 		PushToPlatform(*v)
@@ -0,0 +1,87 @@
+package go_scrape
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/natansdj/go_scrape/logx"
+	"github.com/natansdj/go_scrape/status"
+	"github.com/natansdj/go_scrape/storage"
+)
+
+// IdempotencyKeyTTL is how long a key given by the client is kept
+const IdempotencyKeyTTL = 24 * time.Hour
+
+// idempotencyKey returns the storage key of the job and how long it's kept.
+// The key given by the client wins, otherwise it's derived from the source,
+// preset and params of the job due in the same window. Empty key disables
+// the deduplication.
+func (j *ScrapeJob) idempotencyKey(now time.Time) (string, time.Duration) {
+	if j.IdempotencyKey != "" {
+		return "client:" + j.IdempotencyKey, IdempotencyKeyTTL
+	}
+
+	window := time.Duration(j.Cfg.Core.IdempotencyWindow) * time.Second
+	if window <= 0 {
+		return "", 0
+	}
+
+	at := j.DueAt()
+	if at.IsZero() {
+		at = j.ScheduledAt
+	}
+	if at.IsZero() {
+		at = now
+	}
+
+	params, err := json.Marshal(j.Params)
+	if err != nil {
+		return "", 0
+	}
+
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00%s\x00%d", j.Source, j.Preset, params, at.Truncate(window).Unix())
+
+	// keep the key until the end of the window of the due time
+	ttl := at.Truncate(window).Add(window).Sub(now)
+	if ttl < time.Second {
+		ttl = time.Second
+	}
+
+	return "job:" + hex.EncodeToString(h.Sum(nil)), ttl
+}
+
+// claimJob make the job the owner of its idempotency key. It returns the
+// id of the job already owning the key, empty when the job is new. A key
+// owned by a failed or cancelled job is taken over.
+func claimJob(job *ScrapeJob, key string, ttl time.Duration) (string, error) {
+	for retry := 0; ; retry++ {
+		owner, err := status.StatStorage.ClaimIdempotencyKey(key, job.ID, ttl)
+		if err != nil || owner == job.ID {
+			return "", err
+		}
+
+		// the record of the owner may not be saved yet
+		rec, err := status.StatStorage.GetJob(owner)
+		if retry > 0 || err != nil || (rec.State != storage.JobFailed && rec.State != storage.JobCancelled) {
+			return owner, nil
+		}
+
+		if err := status.StatStorage.DeleteIdempotencyKey(key); err != nil {
+			return "", err
+		}
+	}
+}
+
+// releaseJob free the idempotency key of the job which couldn't be queued
+func releaseJob(job *ScrapeJob, key string) {
+	if key == "" {
+		return
+	}
+
+	if err := status.StatStorage.DeleteIdempotencyKey(key); err != nil {
+		logx.LogError.Errorf("can't release idempotency key of job %s: %v", job.ID, err)
+	}
+}
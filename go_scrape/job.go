@@ -0,0 +1,184 @@
+package go_scrape
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/natansdj/go_scrape/logx"
+	"github.com/natansdj/go_scrape/queue"
+	"github.com/natansdj/go_scrape/status"
+	"github.com/natansdj/go_scrape/storage"
+)
+
+// ErrJobNotCancellable is returned when the job already finished
+var ErrJobNotCancellable = errors.New("job can't be cancelled")
+
+// NewJobID returns a time ordered unique job id
+func NewJobID() string {
+	return storage.NewSortableID(time.Now())
+}
+
+// EnqueueScrape assign an id to the job, record it as queued and add it
+// to the queue. A job due later is recorded as scheduled and held by the
+// queue until then. The job is recorded as failed when the queue is full.
+// A job with the idempotency key of an existing job isn't queued, it takes
+// the id of that job and is marked as duplicate.
+func EnqueueScrape(q *queue.Queue, job *ScrapeJob) error {
+	job.Normalize()
+	if job.ID == "" {
+		job.ID = NewJobID()
+	}
+
+	var key string
+	if status.StatStorage != nil {
+		var ttl time.Duration
+		key, ttl = job.idempotencyKey(time.Now())
+		if key != "" {
+			owner, err := claimJob(job, key, ttl)
+			if err != nil {
+				return err
+			}
+			if owner != "" {
+				logx.LogAccess.Infof("scrape job %s collapsed into job %s", job.ID, owner)
+				job.ID = owner
+				job.Duplicate = true
+				return nil
+			}
+		}
+	}
+
+	var err error
+	if at := job.DueAt(); at.After(time.Now()) {
+		resetJob(job, storage.JobScheduled, func(rec *storage.Job) {
+			rec.RunAt = &at
+			rec.IdempotencyKey = key
+		})
+		err = q.QueueAt(job, at)
+	} else {
+		resetJob(job, storage.JobQueued, func(rec *storage.Job) {
+			rec.RunAt = nil
+			rec.IdempotencyKey = key
+		})
+		err = q.Queue(job)
+	}
+
+	if err != nil {
+		trackJob(job, storage.JobFailed, func(rec *storage.Job) {
+			rec.Error = err.Error()
+		})
+		releaseJob(job, key)
+		return err
+	}
+
+	return nil
+}
+
+// CancelJob record the job as cancelled. A scheduled or queued job is
+// skipped when the queue hands it to a worker, a running job stops its
+// requests right away when it runs in this process, otherwise before its
+// next attempt.
+func CancelJob(id string) (*storage.Job, error) {
+	var rec *storage.Job
+	var cancelled bool
+	err := status.StatStorage.UpdateJob(id, func(cur *storage.Job) *storage.Job {
+		rec, cancelled = cur, false
+		if cur == nil {
+			return nil
+		}
+
+		switch cur.State {
+		case storage.JobScheduled, storage.JobQueued, storage.JobRunning, storage.JobRetried:
+		default:
+			return nil
+		}
+
+		now := time.Now()
+		cur.State = storage.JobCancelled
+		cur.Error = "cancelled by request"
+		cur.UpdatedAt = now
+		cur.FinishedAt = &now
+		cancelled = true
+		return cur
+	})
+	if err != nil {
+		return nil, err
+	}
+	if rec == nil {
+		return nil, storage.ErrJobNotFound
+	}
+	if !cancelled {
+		return rec, fmt.Errorf("%w: %s", ErrJobNotCancellable, rec.State)
+	}
+
+	cancelRunning(id)
+
+	return rec, nil
+}
+
+// jobCancelled report whether the job record is cancelled
+func jobCancelled(job *ScrapeJob) bool {
+	if job.ID == "" || status.StatStorage == nil {
+		return false
+	}
+
+	rec, err := status.StatStorage.GetJob(job.ID)
+	return err == nil && rec.State == storage.JobCancelled
+}
+
+// trackJob move the job record into the state, jobs without id are not
+// tracked. The record which can't move into the state is kept, so a
+// cancelled or finished job is never overwritten.
+func trackJob(job *ScrapeJob, state storage.JobState, update func(*storage.Job)) {
+	moveJob(job, state, false, update)
+}
+
+// resetJob move the job record into the state whatever its state, the job
+// is queued again for a new lifecycle.
+func resetJob(job *ScrapeJob, state storage.JobState, update func(*storage.Job)) {
+	moveJob(job, state, true, update)
+}
+
+func moveJob(job *ScrapeJob, state storage.JobState, force bool, update func(*storage.Job)) {
+	if job.ID == "" || status.StatStorage == nil {
+		return
+	}
+
+	err := status.StatStorage.UpdateJob(job.ID, func(rec *storage.Job) *storage.Job {
+		now := time.Now()
+		switch {
+		case rec == nil:
+			rec = &storage.Job{
+				ID:        job.ID,
+				Source:    job.Source,
+				Preset:    job.Preset,
+				CreatedAt: now,
+			}
+		case !force && !rec.State.CanMoveTo(state):
+			logx.LogAccess.Debugf("job %s stays %s instead of %s", job.ID, rec.State, state)
+			return nil
+		}
+
+		rec.State = state
+		rec.Attempt = job.Attempt
+		rec.UpdatedAt = now
+
+		switch {
+		case state == storage.JobRunning:
+			rec.StartedAt = &now
+			rec.FinishedAt = nil
+			rec.Error = ""
+			rec.Stack = ""
+		case state.IsFinal():
+			rec.FinishedAt = &now
+		}
+
+		if update != nil {
+			update(rec)
+		}
+		return rec
+	})
+	if err != nil {
+		logx.LogError.Errorf("can't save job %s state %s: %v", job.ID, state, err)
+	}
+}
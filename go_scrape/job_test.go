@@ -0,0 +1,275 @@
+package go_scrape
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/natansdj/go_scrape/queue"
+	"github.com/natansdj/go_scrape/status"
+	"github.com/natansdj/go_scrape/storage"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fullWorker accept a single message
+type fullWorker struct {
+	queued []queue.QueuedMessage
+}
+
+func (w *fullWorker) BeforeRun() error        { return nil }
+func (w *fullWorker) Run(chan struct{}) error { return nil }
+func (w *fullWorker) AfterRun() error         { return nil }
+func (w *fullWorker) Shutdown() error         { return nil }
+func (w *fullWorker) Capacity() int           { return 1 }
+func (w *fullWorker) Usage() int              { return len(w.queued) }
+
+func (w *fullWorker) Queue(msg queue.QueuedMessage) error {
+	if len(w.queued) >= 1 {
+		return errors.New("max capacity reached")
+	}
+	w.queued = append(w.queued, msg)
+	return nil
+}
+
+// delayWorker hold the delayed messages
+type delayWorker struct {
+	fullWorker
+	at []time.Time
+}
+
+func (w *delayWorker) QueueAt(msg queue.QueuedMessage, at time.Time) error {
+	w.at = append(w.at, at)
+	return w.Queue(msg)
+}
+
+func TestEnqueueScrape(t *testing.T) {
+	cfg := testConfig(t)
+	q := queue.NewQueue(&fullWorker{}, 1)
+
+	job := &ScrapeJob{Cfg: cfg}
+	assert.NoError(t, EnqueueScrape(q, job))
+	assert.NotEmpty(t, job.ID)
+
+	rec, err := status.StatStorage.GetJob(job.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, storage.JobQueued, rec.State)
+	assert.Equal(t, "default", rec.Preset)
+
+	full := &ScrapeJob{Cfg: cfg}
+	assert.Error(t, EnqueueScrape(q, full))
+
+	rec, err = status.StatStorage.GetJob(full.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, storage.JobFailed, rec.State)
+	assert.NotEmpty(t, rec.Error)
+}
+
+func TestRunScrapeJobTracking(t *testing.T) {
+	cfg := testConfig(t)
+
+	job := &ScrapeJob{ID: NewJobID(), Cfg: cfg, Source: "bloomberg"}
+	assert.Error(t, RunScrapeJob(job))
+
+	rec, err := status.StatStorage.GetJob(job.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, storage.JobFailed, rec.State)
+	assert.Equal(t, 1, rec.Attempt)
+	assert.NotNil(t, rec.StartedAt)
+	assert.NotNil(t, rec.FinishedAt)
+}
+
+func TestEnqueueScrapeDelayed(t *testing.T) {
+	cfg := testConfig(t)
+	w := &delayWorker{}
+	q := queue.NewQueue(w, 1)
+
+	now := time.Now()
+	job := &ScrapeJob{Cfg: cfg, ScheduledAt: now, Delay: 60}
+	assert.NoError(t, EnqueueScrape(q, job))
+	assert.Equal(t, []time.Time{now.Add(time.Minute)}, w.at)
+
+	rec, err := status.StatStorage.GetJob(job.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, storage.JobScheduled, rec.State)
+	assert.True(t, rec.RunAt.Equal(now.Add(time.Minute)))
+
+	// the queue can't delay
+	at := now.Add(time.Hour)
+	job = &ScrapeJob{Cfg: cfg, RunAt: &at}
+	assert.Equal(t, queue.ErrDelayNotSupported, EnqueueScrape(queue.NewQueue(&fullWorker{}, 1), job))
+}
+
+func TestCancelJob(t *testing.T) {
+	cfg := testConfig(t)
+	q := queue.NewQueue(&fullWorker{}, 1)
+
+	job := &ScrapeJob{Cfg: cfg, Source: "bloomberg"}
+	assert.NoError(t, EnqueueScrape(q, job))
+
+	rec, err := CancelJob(job.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, storage.JobCancelled, rec.State)
+	assert.NotNil(t, rec.FinishedAt)
+
+	// the cancelled job is never moved out of its final state
+	trackJob(job, storage.JobSucceeded, nil)
+	rec, err = status.StatStorage.GetJob(job.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, storage.JobCancelled, rec.State)
+
+	// the cancelled job is skipped
+	assert.NoError(t, RunScrapeJob(job))
+	rec, err = status.StatStorage.GetJob(job.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, storage.JobCancelled, rec.State)
+	assert.Equal(t, 0, rec.Attempt)
+
+	_, err = CancelJob(job.ID)
+	assert.True(t, errors.Is(err, ErrJobNotCancellable))
+
+	_, err = CancelJob("unknown")
+	assert.Equal(t, storage.ErrJobNotFound, err)
+}
+
+func TestEnqueueScrapeIdempotency(t *testing.T) {
+	cfg := testConfig(t)
+	cfg.Core.IdempotencyWindow = 300
+	w := &delayWorker{}
+	q := queue.NewQueue(w, 1)
+
+	job := &ScrapeJob{Cfg: cfg}
+	assert.NoError(t, EnqueueScrape(q, job))
+	assert.False(t, job.Duplicate)
+
+	// the same job in the window collapses into the queued one
+	dup := &ScrapeJob{Cfg: cfg}
+	assert.NoError(t, EnqueueScrape(q, dup))
+	assert.True(t, dup.Duplicate)
+	assert.Equal(t, job.ID, dup.ID)
+	assert.Len(t, w.queued, 1)
+
+	// the key of the client wins over the derived one
+	keyed := &ScrapeJob{Cfg: cfg, IdempotencyKey: "foo"}
+	assert.Error(t, EnqueueScrape(q, keyed))
+	rec, err := status.StatStorage.GetJob(keyed.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, storage.JobFailed, rec.State)
+
+	// the key of the job which can't be queued is released
+	w.queued = nil
+	retry := &ScrapeJob{Cfg: cfg, IdempotencyKey: "foo"}
+	assert.NoError(t, EnqueueScrape(q, retry))
+	assert.False(t, retry.Duplicate)
+	assert.NotEqual(t, keyed.ID, retry.ID)
+
+	rec, err = status.StatStorage.GetJob(retry.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, "client:foo", rec.IdempotencyKey)
+
+	again := &ScrapeJob{Cfg: cfg, IdempotencyKey: "foo"}
+	assert.NoError(t, EnqueueScrape(q, again))
+	assert.True(t, again.Duplicate)
+	assert.Equal(t, retry.ID, again.ID)
+}
+
+func TestCancelRunningJob(t *testing.T) {
+	started := make(chan struct{}, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+
+	cfg := testConfig(t)
+	cfg.Source.BaseURI = srv.URL + "/"
+
+	job := &ScrapeJob{ID: NewJobID(), Cfg: cfg}
+	done := make(chan error, 1)
+	go func() {
+		done <- RunScrapeJob(job)
+	}()
+
+	select {
+	case <-started:
+	case <-time.After(time.Second):
+		t.Fatal("request is not sent")
+	}
+
+	_, err := CancelJob(job.ID)
+	assert.NoError(t, err)
+
+	select {
+	case err := <-done:
+		// the cancelled job is not a failure
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("job is not stopped")
+	}
+
+	rec, err := status.StatStorage.GetJob(job.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, storage.JobCancelled, rec.State)
+	assert.Equal(t, "cancelled by request", rec.Error)
+	assert.Equal(t, int64(0), status.StatStorage.GetFailureCount())
+}
+
+func TestCancelRunningOnShutdown(t *testing.T) {
+	started := make(chan struct{}, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+
+	cfg := testConfig(t)
+	cfg.Source.BaseURI = srv.URL + "/"
+
+	wg := &sync.WaitGroup{}
+	job := &ScrapeJob{ID: NewJobID(), Cfg: cfg, Wg: wg}
+	job.AddWaitCount()
+	done := make(chan error, 1)
+	go func() {
+		done <- RunScrapeJob(job)
+	}()
+
+	select {
+	case <-started:
+	case <-time.After(time.Second):
+		t.Fatal("request is not sent")
+	}
+
+	msgs := CancelRunning()
+	assert.Len(t, msgs, 1)
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("job is not stopped")
+	}
+	wg.Wait()
+
+	// the run records nothing, the abandoned copy is dead lettered
+	rec, err := status.StatStorage.GetJob(job.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, storage.JobRunning, rec.State)
+
+	abandoned := msgs[0].(*ScrapeJob)
+	assert.Nil(t, abandoned.Wg)
+	assert.Equal(t, 0, abandoned.Attempt)
+	Abandon(abandoned)
+
+	rec, err = status.StatStorage.GetJob(job.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, storage.JobCancelled, rec.State)
+	assert.Equal(t, ErrShutdown.Error(), rec.Error)
+
+	dls, err := status.StatStorage.ListDeadLetters(0)
+	assert.NoError(t, err)
+	assert.Len(t, dls, 1)
+	assert.Equal(t, job.ID, dls[0].JobID)
+	assert.Empty(t, CancelRunning())
+}
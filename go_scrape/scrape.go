@@ -0,0 +1,433 @@
+package go_scrape
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/natansdj/go_scrape/config"
+	"github.com/natansdj/go_scrape/logx"
+	"github.com/natansdj/go_scrape/queue"
+	"github.com/natansdj/go_scrape/scrape"
+	"github.com/natansdj/go_scrape/status"
+	"github.com/natansdj/go_scrape/storage"
+)
+
+var (
+	// ErrDeadlineExceeded is returned when the job starts after its deadline
+	ErrDeadlineExceeded = errors.New("job deadline exceeded")
+	// ErrUnknownSource is returned when the job source is not registered
+	ErrUnknownSource = scrape.ErrUnknownSource
+	// ErrUnknownMessage is returned when the queued message can't be run
+	ErrUnknownMessage = errors.New("unknown queued message")
+	// ErrShutdown is the error of the jobs not started before the shutdown
+	ErrShutdown = errors.New("queue is shut down before the job started")
+)
+
+// RequestScrape support multiple scrape job request.
+type RequestScrape struct {
+	Jobs []ScrapeJob `json:"jobs" binding:"required"`
+}
+
+// ScrapeJob is a single scrape request for the queue
+type ScrapeJob struct {
+	Wg  *sync.WaitGroup `json:"-"`
+	Log *ScrapeLogs     `json:"-"`
+	Cfg config.ConfYaml `json:"-"`
+	// Ctx of the caller, the run stops once it's done
+	Ctx context.Context `json:"-"`
+
+	ID          string           `json:"id,omitempty"`
+	Source      string           `json:"source,omitempty"`
+	Preset      string           `json:"preset,omitempty"`
+	Params      *scrape.Override `json:"params,omitempty"`
+	Priority    queue.Priority   `json:"priority,omitempty"`
+	Attempt     int              `json:"attempt"`
+	Deadline    *time.Time       `json:"deadline,omitempty"`
+	ScheduledAt time.Time        `json:"scheduled_at"`
+	// RunAt or Delay second after ScheduledAt hold the job in the queue
+	RunAt *time.Time `json:"run_at,omitempty"`
+	Delay int64      `json:"delay,omitempty"`
+	// History of the failed attempts, kept across requeue
+	History []storage.Attempt `json:"history,omitempty"`
+	// Panics of the job, kept across requeue until it's quarantined
+	Panics int `json:"panics,omitempty"`
+	// IdempotencyKey of the client, derived from the job when empty
+	IdempotencyKey string `json:"idempotency_key,omitempty"`
+	// Duplicate is set when the job collapsed into an existing one
+	Duplicate bool `json:"-"`
+}
+
+// ScrapeLog is the outcome of a scrape job
+type ScrapeLog struct {
+	JobID     string `json:"job_id,omitempty"`
+	Source    string `json:"source"`
+	Preset    string `json:"preset"`
+	Attempt   int    `json:"attempt"`
+	RunID     string `json:"run_id,omitempty"`
+	FundCount int    `json:"fund_count"`
+	Error     string `json:"error,omitempty"`
+}
+
+// ScrapeLogs collect the outcome of jobs run by several workers
+type ScrapeLogs struct {
+	mu      sync.Mutex
+	entries []ScrapeLog
+}
+
+// Add append the outcome
+func (l *ScrapeLogs) Add(log ScrapeLog) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.entries = append(l.entries, log)
+}
+
+// Entries returns a copy of all outcomes
+func (l *ScrapeLogs) Entries() []ScrapeLog {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]ScrapeLog{}, l.entries...)
+}
+
+// Bytes for queue message
+func (j *ScrapeJob) Bytes() []byte {
+	b, err := json.Marshal(j)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// QueuePriority returns the lane of the job, normal when not set
+func (j *ScrapeJob) QueuePriority() queue.Priority {
+	if j.Priority == "" {
+		return queue.PriorityNormal
+	}
+	return j.Priority
+}
+
+// WaitDone decrements the WaitGroup counter.
+func (j *ScrapeJob) WaitDone() {
+	if j.Wg != nil {
+		j.Wg.Done()
+	}
+}
+
+// AddWaitCount increments the WaitGroup counter.
+func (j *ScrapeJob) AddWaitCount() {
+	if j.Wg != nil {
+		j.Wg.Add(1)
+	}
+}
+
+// Context returns the context of the caller, background when not set
+func (j *ScrapeJob) Context() context.Context {
+	if j.Ctx != nil {
+		return j.Ctx
+	}
+	return context.Background()
+}
+
+// AddLog record the outcome of the job
+func (j *ScrapeJob) AddLog(log ScrapeLog) {
+	if j.Log != nil {
+		j.Log.Add(log)
+	}
+}
+
+// Expired report whether the deadline has passed
+func (j *ScrapeJob) Expired(now time.Time) bool {
+	return j.Deadline != nil && now.After(*j.Deadline)
+}
+
+// AddAttempt record a failed attempt into the history
+func (j *ScrapeJob) AddAttempt(err error) {
+	j.History = append(j.History, storage.Attempt{
+		Attempt: j.Attempt,
+		Error:   err.Error(),
+		At:      time.Now(),
+		Stack:   panicStack(err),
+	})
+}
+
+// Panicked returns the copy of the job queued again by the supervisor after
+// a panic, the caller of the job is not waiting for the copy.
+func (j *ScrapeJob) Panicked() (queue.QueuedMessage, int) {
+	next := *j
+	next.Wg, next.Log, next.Ctx = nil, nil, nil
+	next.RunAt, next.Delay = nil, 0
+	next.History = append([]storage.Attempt{}, j.History...)
+	next.Panics++
+
+	return &next, next.Panics
+}
+
+// panicStack returns the stack of the panic error, empty for other errors
+func panicStack(err error) string {
+	var panicErr *queue.PanicError
+	if errors.As(err, &panicErr) {
+		return panicErr.Stack
+	}
+	return ""
+}
+
+// DueAt returns when the job should run, zero when it runs right away
+func (j *ScrapeJob) DueAt() time.Time {
+	if j.RunAt != nil {
+		return *j.RunAt
+	}
+	if j.Delay > 0 {
+		return j.ScheduledAt.Add(time.Duration(j.Delay) * time.Second)
+	}
+	return time.Time{}
+}
+
+// Normalize fill the default source and preset
+func (j *ScrapeJob) Normalize() {
+	if j.Source == "" {
+		j.Source = scrape.SourceName
+	}
+	if j.Preset == "" {
+		j.Preset = j.Cfg.Scrape.DefaultPreset
+	}
+}
+
+// Run is the queue worker function, it runs scrape jobs and push
+// notifications.
+func Run(msg queue.QueuedMessage) error {
+	return NewRunFunc(nil, nil)(msg)
+}
+
+// NewRunFunc returns the queue worker function which runs the scrape jobs
+// with the http clients of the factory, a nil factory is the
+// scrape.DefaultClientFactory. The job short-circuited by the breaker of its
+// source is queued again with requeue, it fails when requeue is nil.
+func NewRunFunc(clients *scrape.ClientFactory, requeue func(queue.QueuedMessage, time.Time) error) func(queue.QueuedMessage) error {
+	return func(msg queue.QueuedMessage) error {
+		switch v := msg.(type) {
+		case *ScrapeJob:
+			return runScrapeJob(v, clients, requeue)
+		case *PushNotification:
+			SendNotification(v)
+			return nil
+		default:
+			return fmt.Errorf("%w: %T", ErrUnknownMessage, msg)
+		}
+	}
+}
+
+// Decode returns the queued message of a payload created by Bytes. Push
+// notifications always have a platform, anything else is a scrape job.
+// The config is not part of the payload so it's attached here.
+func Decode(cfg config.ConfYaml, b []byte) (queue.QueuedMessage, error) {
+	var probe struct {
+		Platform *int `json:"platform"`
+	}
+	if err := json.Unmarshal(b, &probe); err != nil {
+		return nil, err
+	}
+
+	if probe.Platform != nil {
+		notification := &PushNotification{}
+		if err := json.Unmarshal(b, notification); err != nil {
+			return nil, err
+		}
+		notification.Cfg = cfg
+		return notification, nil
+	}
+
+	job := &ScrapeJob{}
+	if err := json.Unmarshal(b, job); err != nil {
+		return nil, err
+	}
+	job.Cfg = cfg
+
+	return job, nil
+}
+
+// RunScrapeJob fetch the preset, save the run into snapshot storage
+// and record the outcome.
+func RunScrapeJob(job *ScrapeJob) error {
+	return runScrapeJob(job, nil, nil)
+}
+
+func runScrapeJob(job *ScrapeJob, clients *scrape.ClientFactory, requeue func(queue.QueuedMessage, time.Time) error) (err error) {
+	defer job.WaitDone()
+
+	if jobCancelled(job) {
+		logx.LogAccess.Infof("scrape job %s is cancelled, skip it", job.ID)
+		return nil
+	}
+
+	job.Normalize()
+	job.Attempt++
+
+	ctx, cancel := jobContext(job)
+	defer cancel()
+
+	log := ScrapeLog{
+		JobID:   job.ID,
+		Source:  job.Source,
+		Preset:  job.Preset,
+		Attempt: job.Attempt,
+	}
+
+	trackJob(job, storage.JobRunning, nil)
+
+	// the short-circuited job is queued again once its source may recover
+	var shortCircuit *scrape.BreakerOpenError
+
+	defer func() {
+		// the panic is recorded as the failure of the attempt
+		if r := recover(); r != nil {
+			err = queue.NewPanicError(r)
+		}
+
+		if !finishRunning(job.ID) {
+			// the job is cancelled on shutdown, which abandons it
+			logx.LogAccess.Infof("scrape job %s/%s attempt %d is abandoned on shutdown", job.Source, job.Preset, job.Attempt)
+			err = nil
+			return
+		}
+
+		switch {
+		case err == nil && shortCircuit != nil:
+			logx.LogAccess.Infof("scrape job %s/%s attempt %d is short-circuited, retry at %s", job.Source, job.Preset, job.Attempt, shortCircuit.RetryAt.Format(time.RFC3339))
+			log.Error = shortCircuit.Error()
+			job.AddLog(log)
+			return
+		case err != nil && ctx.Err() == context.DeadlineExceeded:
+			err = ErrDeadlineExceeded
+		case err != nil && ctx.Err() == context.Canceled:
+			// the cancelled job is not a failure
+			logx.LogAccess.Infof("scrape job %s/%s attempt %d is cancelled", job.Source, job.Preset, job.Attempt)
+			log.Error = err.Error()
+			trackJob(job, storage.JobCancelled, func(rec *storage.Job) {
+				if rec.Error == "" {
+					rec.Error = "cancelled: " + log.Error
+				}
+			})
+			job.AddLog(log)
+			err = nil
+			return
+		}
+
+		if err != nil {
+			log.Error = err.Error()
+			job.AddAttempt(err)
+			status.StatStorage.AddFailureCount(1)
+			logx.LogError.Errorf("scrape job %s/%s attempt %d: %v", job.Source, job.Preset, job.Attempt, err)
+			trackJob(job, storage.JobFailed, func(rec *storage.Job) {
+				rec.Error = log.Error
+				rec.Stack = panicStack(err)
+			})
+		} else {
+			status.StatStorage.AddSuccessCount(1)
+			logx.LogAccess.Infof("scrape job %s/%s saved run %s with %d funds", job.Source, job.Preset, log.RunID, log.FundCount)
+			trackJob(job, storage.JobSucceeded, func(rec *storage.Job) {
+				rec.RunID = log.RunID
+				rec.FundCount = log.FundCount
+			})
+		}
+		job.AddLog(log)
+	}()
+
+	if job.Expired(time.Now()) {
+		return ErrDeadlineExceeded
+	}
+
+	src, srcCfg, err := scrape.ResolveSource(job.Cfg, job.Source)
+	if err != nil {
+		return err
+	}
+
+	if clients == nil {
+		clients = scrape.DefaultClientFactory(job.Cfg)
+	}
+	client, err := clients.Client(job.Source)
+	if err != nil {
+		return err
+	}
+
+	preset, err := scrape.Resolve(job.Cfg, job.Preset)
+	if err != nil {
+		return err
+	}
+
+	if job.Params != nil {
+		if preset, err = job.Params.Apply(preset); err != nil {
+			return err
+		}
+	}
+
+	if err = scrape.Validate(preset); err != nil {
+		return err
+	}
+
+	policy := scrape.NewRetryPolicy(srcCfg)
+	policy.Limiter = clients.Limiter(job.Source)
+	policy.Breaker = clients.Breaker(job.Source)
+	policy.OnRetry = func(attempt int, err error, wait time.Duration) {
+		// the job may be cancelled on another instance
+		if jobCancelled(job) {
+			cancel()
+			return
+		}
+		job.AddAttempt(fmt.Errorf("request attempt %d: %w", attempt, err))
+		trackJob(job, storage.JobRetried, func(rec *storage.Job) {
+			rec.Error = fmt.Sprintf("request attempt %d: %v, retry in %s", attempt, err, wait)
+		})
+	}
+
+	result, err := scrape.FetchSource(ctx, client, src, srcCfg, preset, policy)
+	if errors.As(err, &shortCircuit) && requeue != nil {
+		if err = delayJob(job, shortCircuit, requeue); err != nil {
+			shortCircuit = nil
+		}
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	if jobCancelled(job) {
+		cancel()
+	}
+	if err = ctx.Err(); err != nil {
+		return err
+	}
+
+	run := storage.NewSnapshot(job.Source, job.Preset, result.Funds)
+	if err = status.SnapshotStorage.SaveRun(run); err != nil {
+		return fmt.Errorf("can't save snapshot: %w", err)
+	}
+
+	log.RunID = run.ID
+	log.FundCount = run.FundCount
+
+	return nil
+}
+
+// delayJob queue a copy of the short-circuited job at the retry time of
+// the breaker. The attempt is not counted and the caller doesn't wait for
+// the copy.
+func delayJob(job *ScrapeJob, open *scrape.BreakerOpenError, requeue func(queue.QueuedMessage, time.Time) error) error {
+	at := open.RetryAt
+	if job.Deadline != nil && job.Deadline.Before(at) {
+		return fmt.Errorf("%w, the deadline is before the retry", open)
+	}
+
+	retry := *job
+	retry.Wg, retry.Log, retry.Ctx = nil, nil, nil
+	retry.Attempt--
+	retry.RunAt = &at
+
+	trackJob(job, storage.JobScheduled, func(rec *storage.Job) {
+		rec.RunAt = &at
+		rec.Error = open.Error()
+	})
+
+	return requeue(&retry, at)
+}
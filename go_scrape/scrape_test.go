@@ -0,0 +1,223 @@
+package go_scrape
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/natansdj/go_scrape/config"
+	"github.com/natansdj/go_scrape/queue"
+	"github.com/natansdj/go_scrape/scrape"
+	"github.com/natansdj/go_scrape/status"
+	"github.com/natansdj/go_scrape/storage"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type mockMessage struct{}
+
+func (mockMessage) Bytes() []byte {
+	return []byte("mock")
+}
+
+func testConfig(t *testing.T) config.ConfYaml {
+	cfg := config.ConfYaml{}
+	cfg.Stat.Engine = "memory"
+	cfg.Scrape.DefaultPreset = "default"
+	cfg.Scrape.Presets = map[string]config.SectionPreset{
+		"default": config.DefaultPreset(),
+	}
+
+	assert.NoError(t, status.InitAppStatus(cfg))
+	assert.NoError(t, status.InitSnapshotStorage(cfg))
+
+	return cfg
+}
+
+func TestRunUnknownMessage(t *testing.T) {
+	err := Run(mockMessage{})
+	assert.True(t, errors.Is(err, ErrUnknownMessage))
+}
+
+func TestRunScrapeJobDeadline(t *testing.T) {
+	cfg := testConfig(t)
+	deadline := time.Now().Add(-time.Minute)
+
+	wg := &sync.WaitGroup{}
+	logs := &ScrapeLogs{}
+	job := &ScrapeJob{Cfg: cfg, Deadline: &deadline, Wg: wg, Log: logs}
+	job.AddWaitCount()
+
+	err := Run(job)
+	wg.Wait()
+	assert.Equal(t, ErrDeadlineExceeded, err)
+	assert.Equal(t, 1, job.Attempt)
+	assert.Equal(t, int64(1), status.StatStorage.GetFailureCount())
+
+	entries := logs.Entries()
+	assert.Len(t, entries, 1)
+	assert.Equal(t, "default", entries[0].Preset)
+	assert.Equal(t, ErrDeadlineExceeded.Error(), entries[0].Error)
+}
+
+func TestRunScrapeJobUnknownSource(t *testing.T) {
+	cfg := testConfig(t)
+
+	err := RunScrapeJob(&ScrapeJob{Cfg: cfg, Source: "bloomberg"})
+	assert.True(t, errors.Is(err, ErrUnknownSource))
+}
+
+func TestRunScrapeJob(t *testing.T) {
+	var fundType string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fundType = r.URL.Query().Get("fundtype")
+		_, _ = w.Write([]byte(`{"aaData":[` +
+			`["1","A","Fund A","MI","equity","1000","0","0","0","0","0","0","0","0","0","0","0","0","0","0","","","0","1"]` +
+			`]}`))
+	}))
+	defer srv.Close()
+
+	cfg := testConfig(t)
+	cfg.Source.BaseURI = srv.URL + "/"
+
+	logs := &ScrapeLogs{}
+	job := &ScrapeJob{
+		Cfg:    cfg,
+		Params: &scrape.Override{FundTypes: []string{"equity"}},
+		Log:    logs,
+	}
+	assert.NoError(t, Run(job))
+	assert.Equal(t, "equity", fundType)
+	assert.Equal(t, int64(1), status.StatStorage.GetSuccessCount())
+
+	entries := logs.Entries()
+	assert.Len(t, entries, 1)
+	assert.Equal(t, 1, entries[0].FundCount)
+
+	run, err := status.SnapshotStorage.Latest()
+	assert.NoError(t, err)
+	assert.Equal(t, entries[0].RunID, run.ID)
+	assert.Equal(t, "default", run.Preset)
+}
+
+func TestDecode(t *testing.T) {
+	cfg := testConfig(t)
+
+	job := &ScrapeJob{ID: "1", Source: scrape.SourceName, Preset: "default", Attempt: 2}
+	msg, err := Decode(cfg, job.Bytes())
+	assert.NoError(t, err)
+	decoded, ok := msg.(*ScrapeJob)
+	assert.True(t, ok)
+	assert.Equal(t, "1", decoded.ID)
+	assert.Equal(t, 2, decoded.Attempt)
+	assert.Equal(t, "default", decoded.Cfg.Scrape.DefaultPreset)
+
+	notification := &PushNotification{ID: "2", Tokens: []string{"a"}, Platform: 1}
+	msg, err = Decode(cfg, notification.Bytes())
+	assert.NoError(t, err)
+	push, ok := msg.(*PushNotification)
+	assert.True(t, ok)
+	assert.Equal(t, "2", push.ID)
+
+	_, err = Decode(cfg, []byte("not json"))
+	assert.Error(t, err)
+}
+
+func TestRunScrapeJobPanic(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"aaData":[]}`))
+	}))
+	defer srv.Close()
+
+	cfg := testConfig(t)
+	cfg.Source.BaseURI = srv.URL + "/"
+
+	// the nil snapshot storage panics
+	snapshots := status.SnapshotStorage
+	status.SnapshotStorage = nil
+	defer func() {
+		status.SnapshotStorage = snapshots
+	}()
+
+	job := &ScrapeJob{ID: NewJobID(), Cfg: cfg}
+	err := RunScrapeJob(job)
+	var panicErr *queue.PanicError
+	assert.True(t, errors.As(err, &panicErr))
+	assert.Len(t, job.History, 1)
+	assert.NotEmpty(t, job.History[0].Stack)
+
+	rec, err := status.StatStorage.GetJob(job.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, storage.JobFailed, rec.State)
+	assert.Contains(t, rec.Stack, "RunScrapeJob")
+
+	// the copy queued again by the supervisor runs without the caller
+	job.Wg = &sync.WaitGroup{}
+	job.Wg.Add(1)
+	msg, panics := job.Panicked()
+	assert.Equal(t, 1, panics)
+	next := msg.(*ScrapeJob)
+	assert.Nil(t, next.Wg)
+	assert.Equal(t, 1, next.Panics)
+	assert.Equal(t, job.History, next.History)
+
+	assert.Error(t, RunScrapeJob(next))
+	assert.Equal(t, 2, next.Attempt)
+	assert.Len(t, next.History, 2)
+	assert.Len(t, job.History, 1)
+}
+
+func TestRunScrapeJobShortCircuit(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	cfg := testConfig(t)
+	cfg.Source.BaseURI = srv.URL + "/"
+	cfg.Source.Breaker.Failures = 1
+
+	clients := scrape.NewClientFactory(cfg)
+	var requeued *ScrapeJob
+	var retryAt time.Time
+	run := NewRunFunc(clients, func(msg queue.QueuedMessage, at time.Time) error {
+		requeued = msg.(*ScrapeJob)
+		retryAt = at
+		return nil
+	})
+
+	// the failure opens the breaker
+	assert.Error(t, run(&ScrapeJob{ID: NewJobID(), Cfg: cfg}))
+	assert.Nil(t, requeued)
+
+	wg := &sync.WaitGroup{}
+	logs := &ScrapeLogs{}
+	job := &ScrapeJob{ID: NewJobID(), Cfg: cfg, Wg: wg, Log: logs}
+	job.AddWaitCount()
+	assert.NoError(t, run(job))
+	wg.Wait()
+
+	// the copy is queued again without the waiting caller
+	assert.NotNil(t, requeued)
+	assert.Equal(t, job.ID, requeued.ID)
+	assert.Equal(t, 0, requeued.Attempt)
+	assert.Nil(t, requeued.Wg)
+	assert.Equal(t, retryAt, *requeued.RunAt)
+	assert.True(t, retryAt.After(time.Now()))
+
+	entries := logs.Entries()
+	assert.Len(t, entries, 1)
+	assert.Contains(t, entries[0].Error, "circuit breaker")
+
+	rec, err := status.StatStorage.GetJob(job.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, storage.JobScheduled, rec.State)
+	assert.Equal(t, int64(1), status.StatStorage.GetFailureCount())
+
+	// without requeue the short-circuited job fails
+	err = NewRunFunc(clients, nil)(&ScrapeJob{ID: NewJobID(), Cfg: cfg})
+	assert.True(t, errors.Is(err, scrape.ErrBreakerOpen))
+}
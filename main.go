@@ -6,10 +6,17 @@ import (
 	"fmt"
 	"github.com/natansdj/go_scrape/config"
 	"github.com/natansdj/go_scrape/core"
+	"github.com/natansdj/go_scrape/go_scrape"
 	"github.com/natansdj/go_scrape/logx"
 	"github.com/natansdj/go_scrape/queue"
+	"github.com/natansdj/go_scrape/queue/disk"
+	"github.com/natansdj/go_scrape/queue/nats"
+	"github.com/natansdj/go_scrape/queue/nsq"
+	"github.com/natansdj/go_scrape/queue/redisstream"
 	"github.com/natansdj/go_scrape/queue/simple"
 	"github.com/natansdj/go_scrape/router"
+	"github.com/natansdj/go_scrape/scheduler"
+	"github.com/natansdj/go_scrape/scrape"
 	"github.com/natansdj/go_scrape/status"
 	"log"
 	"net"
@@ -42,6 +49,68 @@ func withContextFunc(ctx context.Context, f func()) context.Context {
 	return ctx
 }
 
+// laneWeights returns the dispatch weight of every priority lane
+func laneWeights(cfg config.ConfYaml) map[queue.Priority]int {
+	return map[queue.Priority]int{
+		queue.PriorityHigh:   cfg.Queue.Lanes.High.Weight,
+		queue.PriorityNormal: cfg.Queue.Lanes.Normal.Weight,
+		queue.PriorityLow:    cfg.Queue.Lanes.Low.Weight,
+	}
+}
+
+// supervisor returns the supervisor of the jobs and the workers, the
+// panics are counted and the job panicking repeatedly is moved to the dead
+// letter store
+func supervisor(cfg config.ConfYaml) *queue.Supervisor {
+	return queue.NewSupervisor(
+		queue.WithMaxPanics(int(cfg.Core.MaxPanics)),
+		queue.WithPanicFunc(func(queue.QueuedMessage, *queue.PanicError) {
+			status.StatStorage.AddPanicCount(1)
+		}),
+		queue.WithQuarantineFunc(go_scrape.DeadLetter),
+	)
+}
+
+// autoscaler returns the autoscaler of the worker pool, resized by the
+// queue usage and the upstream latency
+func autoscaler(cfg config.ConfYaml, q *queue.Queue) *queue.Autoscaler {
+	return queue.NewAutoscaler(
+		q,
+		queue.WithScaleBounds(int(cfg.Core.Autoscale.MinWorkers), int(cfg.Core.Autoscale.MaxWorkers)),
+		queue.WithScaleInterval(time.Duration(cfg.Core.Autoscale.Interval)*time.Second),
+		queue.WithMaxLatency(time.Duration(cfg.Core.Autoscale.MaxLatency)*time.Millisecond),
+		queue.WithLatencyFunc(scrape.Latency),
+	)
+}
+
+// cancelWait is how long the running jobs cancelled on shutdown have to
+// stop before they're moved to the dead letter store
+const cancelWait = time.Second
+
+// shutdown drain the queue up to the shutdown timeout, the running jobs are
+// cancelled then and moved to the dead letter store with the jobs not
+// started, then close the storage.
+// It runs once the http server and the scheduler are stopped.
+func shutdown(cfg config.ConfYaml, q *queue.Queue) {
+	timeout := time.Duration(cfg.Core.ShutdownTimeout) * time.Second
+	logx.LogAccess.Infof("drain the queue system in %s", timeout)
+	report := q.Release(timeout, go_scrape.Abandon)
+	logx.LogAccess.Infof(
+		"queue system is closed: %d jobs pending, %d finished, %d failed, %d abandoned, %d cancelled, %d moved to dead letter",
+		report.Pending, report.Finished, report.Failed, report.Abandoned, report.Cancelled, report.Drained+report.Cancelled,
+	)
+
+	// close the connection with storage
+	logx.LogAccess.Info("close the storage connection: ", cfg.Stat.Engine)
+	if err := status.StatStorage.Close(); err != nil {
+		logx.LogError.Fatal("can't close the storage connection: ", err.Error())
+	}
+	logx.LogAccess.Info("close the snapshot storage: ", cfg.Stat.Snapshot.Engine)
+	if err := status.SnapshotStorage.Close(); err != nil {
+		logx.LogError.Fatal("can't close the snapshot storage: ", err.Error())
+	}
+}
+
 func main() {
 	opts := config.ConfYaml{}
 
@@ -95,6 +164,12 @@ func main() {
 		return
 	}
 
+	for name, preset := range cfg.Scrape.Presets {
+		if err = scrape.Validate(preset); err != nil {
+			logx.LogError.Fatalf("invalid scrape preset %s: %v", name, err)
+		}
+	}
+
 	if opts.Core.PID.Path != "" {
 		cfg.Core.PID.Path = opts.Core.PID.Path
 		cfg.Core.PID.Enabled = true
@@ -109,55 +184,164 @@ func main() {
 		logx.LogError.Fatal(err)
 	}
 
-	// Initialize Client
-	config.InitClient(cfg)
+	if err = status.InitSnapshotStorage(cfg); err != nil {
+		logx.LogError.Fatal(err)
+	}
+
+	// Initialize the http client of every source
+	clients := scrape.NewClientFactory(cfg)
+	scrape.SetDefaultClientFactory(clients)
+	for _, name := range scrape.Sources() {
+		if _, ok := cfg.SourceConfig(name); !ok {
+			continue
+		}
+		if _, err = clients.Client(name); err != nil {
+			logx.LogError.Fatal(err)
+		}
+	}
+
+	// the jobs short-circuited by a breaker are queued again, q is set
+	// before the workers start
+	var q *queue.Queue
+	requeue := func(msg queue.QueuedMessage, at time.Time) error {
+		return q.QueueAt(msg, at)
+	}
+
+	sup := supervisor(cfg)
+	run := sup.Wrap(go_scrape.NewRunFunc(clients, requeue))
 
 	var w queue.Worker
 	switch core.Queue(cfg.Queue.Engine) {
 	case core.LocalQueue:
 		w = simple.NewWorker(
 			simple.WithQueueNum(int(cfg.Core.QueueNum)),
+			simple.WithRunFunc(run),
+			simple.WithWeights(laneWeights(cfg)),
+			simple.WithLaneCapacity(queue.PriorityHigh, cfg.Queue.Lanes.High.Capacity),
+			simple.WithLaneCapacity(queue.PriorityNormal, cfg.Queue.Lanes.Normal.Capacity),
+			simple.WithLaneCapacity(queue.PriorityLow, cfg.Queue.Lanes.Low.Capacity),
+		)
+	case core.NSQ:
+		w, err = nsq.NewWorker(
+			nsq.WithAddr(cfg.Queue.NSQ.Addr),
+			nsq.WithTopic(cfg.Queue.NSQ.Topic),
+			nsq.WithChannel(cfg.Queue.NSQ.Channel),
+			nsq.WithMaxInFlight(int(cfg.Core.QueueNum)),
+			nsq.WithMaxDelay(time.Duration(cfg.Queue.NSQ.MaxReqTimeout)*time.Second),
+			nsq.WithRunFunc(run),
+			nsq.WithWeights(laneWeights(cfg)),
+			nsq.WithDecodeFunc(func(b []byte) (queue.QueuedMessage, error) {
+				return go_scrape.Decode(cfg, b)
+			}),
+		)
+		if err != nil {
+			logx.LogError.Fatal(err)
+		}
+	case core.NATS:
+		w = nats.NewWorker(
+			nats.WithAddr(cfg.Queue.NATS.Addr),
+			nats.WithStream(cfg.Queue.NATS.Stream),
+			nats.WithSubject(cfg.Queue.NATS.Subject),
+			nats.WithDurable(cfg.Queue.NATS.Durable),
+			nats.WithMaxInFlight(int(cfg.Core.QueueNum)),
+			nats.WithRunFunc(run),
+			nats.WithMaxDeliver(cfg.Queue.NATS.MaxDeliver),
+			nats.WithAckWait(time.Duration(cfg.Queue.NATS.AckWait)*time.Second),
+			nats.WithNakDelay(time.Duration(cfg.Queue.NATS.NakDelay)*time.Second),
+			nats.WithWeights(laneWeights(cfg)),
+			nats.WithDecodeFunc(func(b []byte) (queue.QueuedMessage, error) {
+				return go_scrape.Decode(cfg, b)
+			}),
+		)
+	case core.RedisStream:
+		w = redisstream.NewWorker(
+			redisstream.WithAddr(cfg.Queue.Redis.Addr),
+			redisstream.WithPassword(cfg.Queue.Redis.Password),
+			redisstream.WithDB(cfg.Queue.Redis.DB),
+			redisstream.WithStream(cfg.Queue.Redis.Stream),
+			redisstream.WithGroup(cfg.Queue.Redis.Group),
+			redisstream.WithConsumer(cfg.Queue.Redis.Consumer),
+			redisstream.WithMaxLen(cfg.Core.QueueNum),
+			redisstream.WithRunFunc(run),
+			redisstream.WithWeights(laneWeights(cfg)),
+			redisstream.WithLaneCapacity(queue.PriorityHigh, int64(cfg.Queue.Lanes.High.Capacity)),
+			redisstream.WithLaneCapacity(queue.PriorityNormal, int64(cfg.Queue.Lanes.Normal.Capacity)),
+			redisstream.WithLaneCapacity(queue.PriorityLow, int64(cfg.Queue.Lanes.Low.Capacity)),
+			redisstream.WithMaxDeliver(int64(cfg.Queue.Redis.MaxDeliver)),
+			redisstream.WithClaimIdle(time.Duration(cfg.Queue.Redis.ClaimIdle)*time.Second),
+			redisstream.WithDecodeFunc(func(b []byte) (queue.QueuedMessage, error) {
+				return go_scrape.Decode(cfg, b)
+			}),
 		)
-	//case core.NSQ:
-	//	w = nsq.NewWorker()
+	case core.DiskQueue:
+		w, err = disk.NewWorker(
+			disk.WithPath(cfg.Queue.Disk.Path),
+			disk.WithQueueNum(int(cfg.Core.QueueNum)),
+			disk.WithRunFunc(run),
+			disk.WithWeights(laneWeights(cfg)),
+			disk.WithLaneCapacity(queue.PriorityHigh, cfg.Queue.Lanes.High.Capacity),
+			disk.WithLaneCapacity(queue.PriorityNormal, cfg.Queue.Lanes.Normal.Capacity),
+			disk.WithLaneCapacity(queue.PriorityLow, cfg.Queue.Lanes.Low.Capacity),
+			disk.WithDecodeFunc(func(b []byte) (queue.QueuedMessage, error) {
+				return go_scrape.Decode(cfg, b)
+			}),
+		)
+		if err != nil {
+			logx.LogError.Fatal(err)
+		}
 	default:
 		logx.LogError.Fatalf("we don't support queue engine: %s", cfg.Queue.Engine)
 	}
 
-	q := queue.NewQueue(w, int(cfg.Core.WorkerNum))
+	q = queue.NewQueue(
+		w,
+		int(cfg.Core.WorkerNum),
+		queue.WithSupervisor(sup),
+		queue.WithCancelFunc(go_scrape.CancelRunning, cancelWait),
+	)
 	q.Start()
 
-	finished := make(chan struct{})
-	ctx := withContextFunc(context.Background(), func() {
-		logx.LogAccess.Info("close the queue system")
-		// stop queue system
-		//q.Shutdown()
-		// wait job completed
-		//q.Wait()
-		close(finished)
-		// close the connection with storage
-		logx.LogAccess.Info("close the storage connection: ", cfg.Stat.Engine)
-		if err := status.StatStorage.Close(); err != nil {
-			logx.LogError.Fatal("can't close the storage connection: ", err.Error())
+	var sched *scheduler.Scheduler
+	if cfg.Schedule.Enabled {
+		if sched, err = scheduler.New(cfg, q); err != nil {
+			logx.LogError.Fatal(err)
 		}
+	}
+
+	ctx := withContextFunc(context.Background(), func() {
+		logx.LogAccess.Info("stop the http server and the scheduler")
 	})
 
 	defer func() {
 		var g errgroup.Group
 		// Run httpd server
 		g.Go(func() error {
-			return router.RunHTTPServer(ctx, cfg, q)
+			return router.RunHTTPServer(ctx, cfg, q, clients)
 		})
 
-		// check job completely
+		// Run worker pool autoscaler
+		if cfg.Core.Autoscale.Enabled {
+			g.Go(func() error {
+				return autoscaler(cfg, q).Run(ctx)
+			})
+		}
+
+		// Run scheduler
+		if sched != nil {
+			g.Go(func() error {
+				return sched.Run(ctx)
+			})
+		}
+
+		// wait the shutdown signal
 		g.Go(func() error {
-			select {
-			case <-finished:
-			}
+			<-ctx.Done()
 			return nil
 		})
 
-		if err = g.Wait(); err != nil {
+		err = g.Wait()
+		shutdown(cfg, q)
+		if err != nil {
 			logx.LogError.Fatal(err)
 		}
 	}()
@@ -1,6 +1,7 @@
 package metric
 
 import (
+	"github.com/natansdj/go_scrape/queue"
 	"github.com/natansdj/go_scrape/status"
 
 	"github.com/prometheus/client_golang/prometheus"
@@ -12,12 +13,33 @@ const namespace = "go_scrape_"
 // exposes go_scrape metrics for prometheus
 type Metrics struct {
 	TotalPushCount *prometheus.Desc
+	SuccessCount   *prometheus.Desc
+	FailureCount   *prometheus.Desc
+	RetryCount     *prometheus.Desc
+	PanicCount     *prometheus.Desc
+	RateLimitWait  *prometheus.Desc
+	CacheHitCount  *prometheus.Desc
+	CacheMissCount *prometheus.Desc
 	QueueUsage     *prometheus.Desc
+	LaneUsage      *prometheus.Desc
+	LaneCapacity   *prometheus.Desc
+	QueueWorkers   *prometheus.Desc
+	BreakerOpen    *prometheus.Desc
 	GetQueueUsage  func() int
+	GetQueueLanes  func() []queue.Lane
+	GetWorkers     func() int
+	// GetBreakers returns the state of the circuit breaker by source
+	GetBreakers func() map[string]string
 }
 
 var getGetQueueUsage = func() int { return 0 }
 
+var getGetQueueLanes = func() []queue.Lane { return nil }
+
+var getGetWorkers = func() int { return 0 }
+
+var getGetBreakers = func() map[string]string { return nil }
+
 // NewMetrics returns a new Metrics with all prometheus.Desc initialized
 func NewMetrics(c ...func() int) Metrics {
 	m := Metrics{
@@ -26,12 +48,70 @@ func NewMetrics(c ...func() int) Metrics {
 			"Number of push count",
 			nil, nil,
 		),
+		SuccessCount: prometheus.NewDesc(
+			namespace+"scrape_success_count",
+			"Number of succeeded scrape job",
+			nil, nil,
+		),
+		FailureCount: prometheus.NewDesc(
+			namespace+"scrape_failure_count",
+			"Number of failed scrape job",
+			nil, nil,
+		),
+		RetryCount: prometheus.NewDesc(
+			namespace+"scrape_retry_count",
+			"Number of retried upstream request",
+			nil, nil,
+		),
+		PanicCount: prometheus.NewDesc(
+			namespace+"panic_count",
+			"Number of recovered panic of jobs and workers",
+			nil, nil,
+		),
+		RateLimitWait: prometheus.NewDesc(
+			namespace+"rate_limit_wait_seconds",
+			"Time spent waiting for the upstream rate limits",
+			nil, nil,
+		),
+		CacheHitCount: prometheus.NewDesc(
+			namespace+"cache_hit_count",
+			"Number of upstream responses served from the cache",
+			nil, nil,
+		),
+		CacheMissCount: prometheus.NewDesc(
+			namespace+"cache_miss_count",
+			"Number of upstream responses missing in the cache",
+			nil, nil,
+		),
 		QueueUsage: prometheus.NewDesc(
 			namespace+"queue_usage",
 			"Length of internal queue",
 			nil, nil,
 		),
+		LaneUsage: prometheus.NewDesc(
+			namespace+"queue_lane_usage",
+			"Length of internal queue by priority",
+			[]string{"priority"}, nil,
+		),
+		LaneCapacity: prometheus.NewDesc(
+			namespace+"queue_lane_capacity",
+			"Capacity of internal queue by priority",
+			[]string{"priority"}, nil,
+		),
+		QueueWorkers: prometheus.NewDesc(
+			namespace+"queue_workers",
+			"Number of workers of internal queue",
+			nil, nil,
+		),
+		BreakerOpen: prometheus.NewDesc(
+			namespace+"breaker_open",
+			"Whether the circuit breaker of the source is open",
+			[]string{"source"}, nil,
+		),
 		GetQueueUsage: getGetQueueUsage,
+		GetQueueLanes: getGetQueueLanes,
+		GetWorkers:    getGetWorkers,
+		GetBreakers:   getGetBreakers,
 	}
 
 	if len(c) > 0 {
@@ -44,7 +124,18 @@ func NewMetrics(c ...func() int) Metrics {
 // Describe returns all possible prometheus.Desc
 func (c Metrics) Describe(ch chan<- *prometheus.Desc) {
 	ch <- c.TotalPushCount
+	ch <- c.SuccessCount
+	ch <- c.FailureCount
+	ch <- c.RetryCount
+	ch <- c.PanicCount
+	ch <- c.RateLimitWait
+	ch <- c.CacheHitCount
+	ch <- c.CacheMissCount
 	ch <- c.QueueUsage
+	ch <- c.LaneUsage
+	ch <- c.LaneCapacity
+	ch <- c.QueueWorkers
+	ch <- c.BreakerOpen
 }
 
 // Collect returns the metrics with values
@@ -54,9 +145,75 @@ func (c Metrics) Collect(ch chan<- prometheus.Metric) {
 		prometheus.CounterValue,
 		float64(status.StatStorage.GetTotalCount()),
 	)
+	ch <- prometheus.MustNewConstMetric(
+		c.SuccessCount,
+		prometheus.CounterValue,
+		float64(status.StatStorage.GetSuccessCount()),
+	)
+	ch <- prometheus.MustNewConstMetric(
+		c.FailureCount,
+		prometheus.CounterValue,
+		float64(status.StatStorage.GetFailureCount()),
+	)
+	ch <- prometheus.MustNewConstMetric(
+		c.RetryCount,
+		prometheus.CounterValue,
+		float64(status.StatStorage.GetRetryCount()),
+	)
+	ch <- prometheus.MustNewConstMetric(
+		c.PanicCount,
+		prometheus.CounterValue,
+		float64(status.StatStorage.GetPanicCount()),
+	)
+	ch <- prometheus.MustNewConstMetric(
+		c.RateLimitWait,
+		prometheus.CounterValue,
+		float64(status.StatStorage.GetRateLimitWait())/1000,
+	)
+	ch <- prometheus.MustNewConstMetric(
+		c.CacheHitCount,
+		prometheus.CounterValue,
+		float64(status.StatStorage.GetCacheHit()),
+	)
+	ch <- prometheus.MustNewConstMetric(
+		c.CacheMissCount,
+		prometheus.CounterValue,
+		float64(status.StatStorage.GetCacheMiss()),
+	)
 	ch <- prometheus.MustNewConstMetric(
 		c.QueueUsage,
 		prometheus.GaugeValue,
 		float64(c.GetQueueUsage()),
 	)
+	ch <- prometheus.MustNewConstMetric(
+		c.QueueWorkers,
+		prometheus.GaugeValue,
+		float64(c.GetWorkers()),
+	)
+	for _, lane := range c.GetQueueLanes() {
+		ch <- prometheus.MustNewConstMetric(
+			c.LaneUsage,
+			prometheus.GaugeValue,
+			float64(lane.Usage),
+			string(lane.Priority),
+		)
+		ch <- prometheus.MustNewConstMetric(
+			c.LaneCapacity,
+			prometheus.GaugeValue,
+			float64(lane.Capacity),
+			string(lane.Priority),
+		)
+	}
+	for source, state := range c.GetBreakers() {
+		var open float64
+		if state == "open" {
+			open = 1
+		}
+		ch <- prometheus.MustNewConstMetric(
+			c.BreakerOpen,
+			prometheus.GaugeValue,
+			open,
+			source,
+		)
+	}
 }
@@ -3,6 +3,8 @@ package metric
 import (
 	"testing"
 
+	"github.com/natansdj/go_scrape/queue"
+
 	"github.com/stretchr/testify/assert"
 )
 
@@ -13,3 +15,31 @@ func TestNewMetrics(t *testing.T) {
 	m = NewMetrics(func() int { return 1 })
 	assert.Equal(t, 1, m.GetQueueUsage())
 }
+
+func TestQueueLanes(t *testing.T) {
+	m := NewMetrics()
+	assert.Empty(t, m.GetQueueLanes())
+
+	m.GetQueueLanes = func() []queue.Lane {
+		return []queue.Lane{{Priority: queue.PriorityHigh, Capacity: 2, Usage: 1}}
+	}
+	assert.Equal(t, 1, m.GetQueueLanes()[0].Usage)
+}
+
+func TestQueueWorkers(t *testing.T) {
+	m := NewMetrics()
+	assert.Equal(t, 0, m.GetWorkers())
+
+	m.GetWorkers = func() int { return 4 }
+	assert.Equal(t, 4, m.GetWorkers())
+}
+
+func TestBreakers(t *testing.T) {
+	m := NewMetrics()
+	assert.Empty(t, m.GetBreakers())
+
+	m.GetBreakers = func() map[string]string {
+		return map[string]string{"indopremier": "open"}
+	}
+	assert.Equal(t, "open", m.GetBreakers()["indopremier"])
+}
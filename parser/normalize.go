@@ -0,0 +1,198 @@
+package parser
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Unit is a multiplier used to convert a scaled amount into IDR.
+type Unit float64
+
+const (
+	// UnitIDR keep the value as is
+	UnitIDR Unit = 1
+	// UnitThousand is ribu (10^3)
+	UnitThousand Unit = 1e3
+	// UnitMillion is juta (10^6)
+	UnitMillion Unit = 1e6
+	// UnitBillion is miliar (10^9)
+	UnitBillion Unit = 1e9
+	// UnitTrillion is triliun (10^12)
+	UnitTrillion Unit = 1e12
+)
+
+// ErrInvalidNumber is returned when a cell is not a number after cleanup
+var ErrInvalidNumber = errors.New("invalid number")
+
+var (
+	tagPattern   = regexp.MustCompile(`<[^>]*>`)
+	spacePattern = regexp.MustCompile(`\s+`)
+
+	// missingValues are placeholders the source use for no data
+	missingValues = map[string]bool{
+		"":    true,
+		"-":   true,
+		"--":  true,
+		"n/a": true,
+		"na":  true,
+		"nan": true,
+	}
+
+	// unitSuffixes ordered longest first, so "jt" is not read as "t"
+	unitSuffixes = []struct {
+		suffix string
+		unit   Unit
+	}{
+		{"triliun", UnitTrillion},
+		{"miliar", UnitBillion},
+		{"milyar", UnitBillion},
+		{"juta", UnitMillion},
+		{"ribu", UnitThousand},
+		{"jt", UnitMillion},
+		{"rb", UnitThousand},
+		{"t", UnitTrillion},
+		{"m", UnitBillion},
+		{"b", UnitBillion},
+		{"k", UnitThousand},
+	}
+)
+
+// ParseUnit convert unit name from config into Unit, empty value is UnitBillion.
+func ParseUnit(name string) (Unit, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "", "billion", "miliar":
+		return UnitBillion, nil
+	case "idr", "rupiah":
+		return UnitIDR, nil
+	case "thousand", "ribu":
+		return UnitThousand, nil
+	case "million", "juta":
+		return UnitMillion, nil
+	case "trillion", "triliun":
+		return UnitTrillion, nil
+	}
+
+	return 0, fmt.Errorf("unknown unit: %s", name)
+}
+
+// CleanText remove html tags, decode entities and collapse spaces.
+func CleanText(s string) string {
+	s = tagPattern.ReplaceAllString(s, " ")
+	s = html.UnescapeString(s)
+	s = strings.ReplaceAll(s, "\u00a0", " ")
+	s = spacePattern.ReplaceAllString(s, " ")
+
+	return strings.TrimSpace(s)
+}
+
+// IsMissing report whether the cell is a placeholder for no data.
+func IsMissing(s string) bool {
+	return missingValues[strings.ToLower(CleanText(s))]
+}
+
+// ParseNumber convert locale formatted text into float64.
+// A nil value with nil error means the cell is explicitly missing.
+//
+// Both "1.234,56" (Indonesian) and "1,234.56" are accepted: when both
+// separators are present the last one is the decimal separator. With a
+// single kind of separator, several occurrences are thousand separators
+// and a single comma is the decimal separator. A single dot is read the
+// Indonesian way as thousand separator when exactly three digits follow
+// a non zero integer part ("2.500"), otherwise as decimal separator.
+func ParseNumber(s string) (*float64, error) {
+	s = CleanText(s)
+	if missingValues[strings.ToLower(s)] {
+		return nil, nil
+	}
+
+	s = strings.TrimSpace(strings.TrimSuffix(s, "%"))
+	s = strings.ReplaceAll(s, " ", "")
+	s = strings.ReplaceAll(s, "\u2212", "-")
+
+	negative := false
+	if strings.HasPrefix(s, "(") && strings.HasSuffix(s, ")") {
+		negative = true
+		s = s[1 : len(s)-1]
+	}
+
+	s = normalizeSeparators(s)
+	if s == "" {
+		return nil, ErrInvalidNumber
+	}
+
+	val, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return nil, ErrInvalidNumber
+	}
+
+	if negative {
+		val = -val
+	}
+
+	return &val, nil
+}
+
+// ParseAmount convert an amount with optional currency and unit suffix
+// into IDR, e.g. "Rp 1,2 T" or "250,5 M". Amount without suffix is
+// multiplied by the given default unit.
+func ParseAmount(s string, defaultUnit Unit) (*float64, error) {
+	s = strings.ToLower(CleanText(s))
+	if missingValues[s] {
+		return nil, nil
+	}
+
+	s = strings.TrimSpace(strings.TrimPrefix(s, "idr"))
+	s = strings.TrimSpace(strings.TrimPrefix(s, "rp."))
+	s = strings.TrimSpace(strings.TrimPrefix(s, "rp"))
+
+	unit := defaultUnit
+	for _, u := range unitSuffixes {
+		if strings.HasSuffix(s, u.suffix) {
+			unit = u.unit
+			s = strings.TrimSpace(strings.TrimSuffix(s, u.suffix))
+			break
+		}
+	}
+
+	val, err := ParseNumber(s)
+	if err != nil || val == nil {
+		return val, err
+	}
+
+	*val *= float64(unit)
+
+	return val, nil
+}
+
+func normalizeSeparators(s string) string {
+	dots := strings.Count(s, ".")
+	commas := strings.Count(s, ",")
+
+	switch {
+	case dots > 0 && commas > 0:
+		if strings.LastIndex(s, ",") > strings.LastIndex(s, ".") {
+			s = strings.ReplaceAll(s, ".", "")
+			s = strings.Replace(s, ",", ".", 1)
+		} else {
+			s = strings.ReplaceAll(s, ",", "")
+		}
+	case commas == 1:
+		s = strings.Replace(s, ",", ".", 1)
+	case commas > 1:
+		s = strings.ReplaceAll(s, ",", "")
+	case dots > 1:
+		s = strings.ReplaceAll(s, ".", "")
+	case dots == 1:
+		i := strings.Index(s, ".")
+		intPart := strings.TrimLeft(s[:i], "+-")
+		if len(s)-i-1 == 3 && intPart != "" && strings.Trim(intPart, "0") != "" {
+			s = strings.Replace(s, ".", "", 1)
+		}
+	}
+
+	return s
+}
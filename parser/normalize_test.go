@@ -0,0 +1,99 @@
+package parser
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseNumber(t *testing.T) {
+	tests := []struct {
+		in  string
+		out float64
+	}{
+		{"1500", 1500},
+		{"1500.25", 1500.25},
+		{"1.500,25", 1500.25},
+		{"1,500.25", 1500.25},
+		{"1.234.567", 1234567},
+		{"2.500", 2500},
+		{"0.125", 0.125},
+		{"1234.5678", 1234.5678},
+		{"1,234,567", 1234567},
+		{"12,5", 12.5},
+		{"12,5%", 12.5},
+		{" -3,75 % ", -3.75},
+		{"(2,5)", -2.5},
+		{"−1,5", -1.5},
+		{"<span class=\"red\">-0,25%</span>", -0.25},
+	}
+
+	for _, tt := range tests {
+		val, err := ParseNumber(tt.in)
+		assert.NoError(t, err, tt.in)
+		if assert.NotNil(t, val, tt.in) {
+			assert.Equal(t, tt.out, *val, tt.in)
+		}
+	}
+}
+
+func TestParseNumberMissing(t *testing.T) {
+	for _, in := range []string{"", " ", "-", "--", "N/A", "<span>-</span>"} {
+		val, err := ParseNumber(in)
+		assert.NoError(t, err, in)
+		assert.Nil(t, val, in)
+	}
+}
+
+func TestParseNumberInvalid(t *testing.T) {
+	for _, in := range []string{"abc", "1,2,3.4.5", "%", "1.2.3,4,5"} {
+		_, err := ParseNumber(in)
+		assert.Equal(t, ErrInvalidNumber, err, in)
+	}
+}
+
+func TestParseAmount(t *testing.T) {
+	tests := []struct {
+		in   string
+		unit Unit
+		out  float64
+	}{
+		{"1,2 T", UnitBillion, 1.2e12},
+		{"Rp 250,5 M", UnitIDR, 250.5e9},
+		{"Rp. 10 Miliar", UnitIDR, 10e9},
+		{"750 Jt", UnitBillion, 750e6},
+		{"3 juta", UnitBillion, 3e6},
+		{"IDR 1.000", UnitIDR, 1000},
+		{"2.500", UnitBillion, 2500e9},
+	}
+
+	for _, tt := range tests {
+		val, err := ParseAmount(tt.in, tt.unit)
+		assert.NoError(t, err, tt.in)
+		if assert.NotNil(t, val, tt.in) {
+			assert.InDelta(t, tt.out, *val, 1e-3, tt.in)
+		}
+	}
+
+	val, err := ParseAmount("-", UnitBillion)
+	assert.NoError(t, err)
+	assert.Nil(t, val)
+}
+
+func TestParseUnit(t *testing.T) {
+	unit, err := ParseUnit("")
+	assert.NoError(t, err)
+	assert.Equal(t, UnitBillion, unit)
+
+	unit, err = ParseUnit("Million")
+	assert.NoError(t, err)
+	assert.Equal(t, UnitMillion, unit)
+
+	_, err = ParseUnit("gram")
+	assert.Error(t, err)
+}
+
+func TestCleanText(t *testing.T) {
+	assert.Equal(t, "Fund & Co", CleanText("<a href='#'>Fund &amp; Co</a>"))
+	assert.Equal(t, "a b", CleanText(" a \n b "))
+}
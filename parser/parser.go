@@ -0,0 +1,321 @@
+package parser
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/natansdj/go_scrape/fund"
+)
+
+var (
+	// ErrEmptyValue is returned when a required cell has no value
+	ErrEmptyValue = errors.New("empty value")
+	// ErrNotRow is returned when an aaData entry is not an array
+	ErrNotRow = errors.New("row is not an array")
+)
+
+// ColumnError describes a single cell which can't be converted.
+type ColumnError struct {
+	Column fund.Column
+	Value  interface{}
+	Err    error
+}
+
+func (e *ColumnError) Error() string {
+	return fmt.Sprintf("column %d (%s) value %q: %v", e.Column, e.Column, fmt.Sprint(e.Value), e.Err)
+}
+
+// Unwrap returns the underlying conversion error.
+func (e *ColumnError) Unwrap() error {
+	return e.Err
+}
+
+// RowError collects every column error of a single row.
+type RowError struct {
+	Row     int
+	Err     error
+	Columns []*ColumnError
+	// Skipped is true when the row is not part of the parsed funds
+	Skipped bool
+}
+
+func (e *RowError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("row %d: %v", e.Row, e.Err)
+	}
+
+	msg := make([]string, 0, len(e.Columns))
+	for _, c := range e.Columns {
+		msg = append(msg, c.Error())
+	}
+	return fmt.Sprintf("row %d: %s", e.Row, strings.Join(msg, "; "))
+}
+
+// MarshalJSON keep the error message readable in API response.
+func (e *RowError) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]interface{}{
+		"row":     e.Row,
+		"error":   e.Error(),
+		"skipped": e.Skipped,
+	})
+}
+
+// payload is the response body of source_json_for_favorite.php
+type payload struct {
+	AAData []interface{} `json:"aaData"`
+}
+
+// Decode read the source response and return the raw aaData rows.
+func Decode(r io.Reader) ([]interface{}, error) {
+	var p payload
+
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	if err := dec.Decode(&p); err != nil {
+		return nil, err
+	}
+
+	return p.AAData, nil
+}
+
+// Option for parser
+type Option func(*Parser)
+
+// Parser convert aaData rows into funds.
+type Parser struct {
+	strict  bool
+	aumUnit Unit
+}
+
+// WithStrict fail the whole row when a numeric cell is malformed,
+// instead of keeping the row with the value as missing.
+func WithStrict(strict bool) Option {
+	return func(p *Parser) {
+		p.strict = strict
+	}
+}
+
+// WithAUMUnit setup the unit of AUM cells without explicit suffix
+func WithAUMUnit(unit Unit) Option {
+	return func(p *Parser) {
+		p.aumUnit = unit
+	}
+}
+
+// New returns a Parser, default is non strict with AUM in billions of IDR.
+func New(opts ...Option) *Parser {
+	p := &Parser{
+		aumUnit: UnitBillion,
+	}
+
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	return p
+}
+
+// Parse convert all aaData rows with default parser.
+func Parse(rows []interface{}) ([]fund.Fund, []*RowError) {
+	return New().Parse(rows)
+}
+
+// ParseRow convert a single row with default parser.
+func ParseRow(row int, cells []interface{}) (fund.Fund, *RowError) {
+	return New().ParseRow(row, cells)
+}
+
+// Parse convert all aaData rows into funds. Rows which can't be
+// converted are skipped, every row with problem is reported in the
+// returned errors.
+func (p *Parser) Parse(rows []interface{}) ([]fund.Fund, []*RowError) {
+	funds, rowErrs, _ := p.ParseContext(context.Background(), rows)
+	return funds, rowErrs
+}
+
+// ParseContext is Parse which stops with the error of ctx once it's done.
+func (p *Parser) ParseContext(ctx context.Context, rows []interface{}) ([]fund.Fund, []*RowError, error) {
+	funds := make([]fund.Fund, 0, len(rows))
+	var rowErrs []*RowError
+
+	for i, raw := range rows {
+		if err := ctx.Err(); err != nil {
+			return nil, nil, err
+		}
+
+		cells, ok := raw.([]interface{})
+		if !ok {
+			rowErrs = append(rowErrs, &RowError{Row: i, Err: ErrNotRow, Skipped: true})
+			continue
+		}
+
+		f, err := p.ParseRow(i, cells)
+		if err != nil {
+			rowErrs = append(rowErrs, err)
+			if err.Skipped {
+				continue
+			}
+		}
+
+		funds = append(funds, f)
+	}
+
+	return funds, rowErrs, nil
+}
+
+// ParseRow convert a single positional row into a fund. The returned
+// error has Skipped set when the fund must not be used.
+func (p *Parser) ParseRow(row int, cells []interface{}) (fund.Fund, *RowError) {
+	var f fund.Fund
+
+	if len(cells) < fund.MinColumns {
+		return f, &RowError{
+			Row:     row,
+			Err:     fmt.Errorf("expected at least %d columns, got %d", fund.MinColumns, len(cells)),
+			Skipped: true,
+		}
+	}
+
+	r := &rowReader{cells: cells}
+
+	f.ID = r.text(fund.ColID, true)
+	f.Code = r.text(fund.ColCode, false)
+	f.Name = r.text(fund.ColName, true)
+	f.Manager = r.text(fund.ColManager, false)
+	f.Type = r.text(fund.ColType, false)
+	f.LastNAV = r.number(fund.ColLastNAV)
+	f.Returns.OneDay = r.number(fund.ColReturn1D)
+	f.Returns.ThreeDay = r.number(fund.ColReturn3D)
+	f.Returns.OneMonth = r.number(fund.ColReturn1M)
+	f.Returns.ThreeMonth = r.number(fund.ColReturn3M)
+	f.Returns.SixMonth = r.number(fund.ColReturn6M)
+	f.Returns.NineMonth = r.number(fund.ColReturn9M)
+	f.Returns.YTD = r.number(fund.ColReturnYTD)
+	f.Returns.OneYear = r.number(fund.ColReturn1Y)
+	f.Returns.ThreeYear = r.number(fund.ColReturn3Y)
+	f.Returns.FiveYear = r.number(fund.ColReturn5Y)
+	f.HiLo = r.number(fund.ColHiLo)
+	f.Sharpe = r.number(fund.ColSharpe)
+	f.Drawdown = r.number(fund.ColDrawdown)
+	f.DrawdownPeriod = r.text(fund.ColDrawdownPeriod, false)
+	f.HistRisk = r.number(fund.ColHistRisk)
+	f.AUM = r.amount(fund.ColAUM, p.aumUnit)
+
+	if len(r.errs) > 0 {
+		return f, &RowError{
+			Row:     row,
+			Columns: r.errs,
+			Skipped: p.strict || r.fatal,
+		}
+	}
+
+	return f, nil
+}
+
+// rowReader read typed values out of a row and collect the errors.
+type rowReader struct {
+	cells []interface{}
+	errs  []*ColumnError
+	// fatal is set when a required text column is invalid
+	fatal bool
+}
+
+func (r *rowReader) fail(col fund.Column, err error) {
+	r.errs = append(r.errs, &ColumnError{
+		Column: col,
+		Value:  r.cells[col],
+		Err:    err,
+	})
+}
+
+func (r *rowReader) text(col fund.Column, required bool) string {
+	var s string
+
+	switch v := r.cells[col].(type) {
+	case nil:
+	case string:
+		s = CleanText(v)
+	case json.Number:
+		s = v.String()
+	case float64:
+		s = strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		r.fail(col, fmt.Errorf("unexpected type %T", v))
+		r.fatal = r.fatal || required
+		return ""
+	}
+
+	if required && IsMissing(s) {
+		r.fail(col, ErrEmptyValue)
+		r.fatal = true
+		return ""
+	}
+
+	return s
+}
+
+func (r *rowReader) number(col fund.Column) *float64 {
+	var (
+		val *float64
+		err error
+	)
+
+	switch v := r.cells[col].(type) {
+	case nil:
+	case float64:
+		val = &v
+	case json.Number:
+		// the json numbers are not in the locale format
+		var n float64
+		if n, err = v.Float64(); err == nil {
+			val = &n
+		}
+	case string:
+		val, err = ParseNumber(v)
+	default:
+		err = fmt.Errorf("unexpected type %T", v)
+	}
+
+	if err != nil {
+		r.fail(col, err)
+		return nil
+	}
+
+	return val
+}
+
+func (r *rowReader) amount(col fund.Column, unit Unit) *float64 {
+	var (
+		val *float64
+		err error
+	)
+
+	switch v := r.cells[col].(type) {
+	case nil:
+	case float64:
+		n := v * float64(unit)
+		val = &n
+	case json.Number:
+		var n float64
+		if n, err = v.Float64(); err == nil {
+			n *= float64(unit)
+			val = &n
+		}
+	case string:
+		val, err = ParseAmount(v, unit)
+	default:
+		err = fmt.Errorf("unexpected type %T", v)
+	}
+
+	if err != nil {
+		r.fail(col, err)
+		return nil
+	}
+
+	return val
+}
@@ -0,0 +1,185 @@
+package parser
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/natansdj/go_scrape/fund"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testRow() []interface{} {
+	row := make([]interface{}, 32)
+	for i := range row {
+		row[i] = ""
+	}
+
+	row[fund.ColID] = "123"
+	row[fund.ColCode] = "ABCD"
+	row[fund.ColName] = "Reksa Dana Saham"
+	row[fund.ColManager] = "PT Manager"
+	row[fund.ColType] = "equity"
+	row[fund.ColLastNAV] = "1500.25"
+	row[fund.ColReturn1D] = "0.5"
+	row[fund.ColReturn3D] = "1"
+	row[fund.ColReturn1M] = "2"
+	row[fund.ColReturn3M] = "3"
+	row[fund.ColReturn6M] = "4"
+	row[fund.ColReturn9M] = "5"
+	row[fund.ColReturnYTD] = "6"
+	row[fund.ColReturn1Y] = "7"
+	row[fund.ColReturn3Y] = "8"
+	row[fund.ColReturn5Y] = "9"
+	row[fund.ColHiLo] = "10"
+	row[fund.ColSharpe] = "1.2"
+	row[fund.ColDrawdown] = "-12.5"
+	row[fund.ColDrawdownPeriod] = "120"
+	row[fund.ColHistRisk] = "3.4"
+	row[fund.ColAUM] = 2500.0
+
+	return row
+}
+
+func TestParseRow(t *testing.T) {
+	f, err := ParseRow(0, testRow())
+	assert.Nil(t, err)
+
+	assert.Equal(t, "123", f.ID)
+	assert.Equal(t, "ABCD", f.Code)
+	assert.Equal(t, "Reksa Dana Saham", f.Name)
+	assert.Equal(t, "equity", f.Type)
+	assert.Equal(t, 1500.25, *f.LastNAV)
+	assert.Equal(t, 0.5, *f.Returns.OneDay)
+	assert.Equal(t, 9.0, *f.Returns.FiveYear)
+	assert.Equal(t, -12.5, *f.Drawdown)
+	assert.Equal(t, "120", f.DrawdownPeriod)
+	assert.Equal(t, 2500e9, *f.AUM)
+}
+
+func TestParseRowLocaleFormat(t *testing.T) {
+	row := testRow()
+	row[fund.ColName] = `<a href="/fund/123">Reksa&nbsp;Dana <b>Saham</b></a>`
+	row[fund.ColLastNAV] = "1.500,25"
+	row[fund.ColReturn1D] = "-0,51%"
+	row[fund.ColReturn5Y] = "-"
+	row[fund.ColAUM] = "Rp 1,2 T"
+
+	f, err := ParseRow(0, row)
+	assert.Nil(t, err)
+	assert.Equal(t, "Reksa Dana Saham", f.Name)
+	assert.Equal(t, 1500.25, *f.LastNAV)
+	assert.Equal(t, -0.51, *f.Returns.OneDay)
+	assert.Nil(t, f.Returns.FiveYear)
+	assert.Equal(t, 1.2e12, *f.AUM)
+}
+
+func TestParseRowAUMUnit(t *testing.T) {
+	row := testRow()
+	row[fund.ColAUM] = "2.500"
+
+	f, err := New(WithAUMUnit(UnitMillion)).ParseRow(0, row)
+	assert.Nil(t, err)
+	assert.Equal(t, 2500e6, *f.AUM)
+}
+
+func TestParseRowStrictMode(t *testing.T) {
+	row := testRow()
+	row[fund.ColSharpe] = "abc"
+
+	// non strict keep the row, the value is missing
+	f, err := New().ParseRow(0, row)
+	assert.NotNil(t, err)
+	assert.False(t, err.Skipped)
+	assert.Nil(t, f.Sharpe)
+	assert.Equal(t, "123", f.ID)
+
+	_, err = New(WithStrict(true)).ParseRow(0, row)
+	assert.NotNil(t, err)
+	assert.True(t, err.Skipped)
+	assert.Equal(t, ErrInvalidNumber, err.Columns[0].Err)
+
+	funds, rowErrs := New(WithStrict(true)).Parse([]interface{}{row, testRow()})
+	assert.Len(t, funds, 1)
+	assert.Len(t, rowErrs, 1)
+}
+
+func TestParseRowColumnErrors(t *testing.T) {
+	row := testRow()
+	row[fund.ColName] = ""
+	row[fund.ColSharpe] = "abc"
+	row[fund.ColAUM] = true
+
+	_, err := ParseRow(3, row)
+	assert.NotNil(t, err)
+	assert.True(t, err.Skipped)
+	assert.Equal(t, 3, err.Row)
+	assert.Len(t, err.Columns, 3)
+	assert.Equal(t, fund.ColName, err.Columns[0].Column)
+	assert.Equal(t, ErrEmptyValue, err.Columns[0].Err)
+	assert.Equal(t, fund.ColSharpe, err.Columns[1].Column)
+	assert.Equal(t, fund.ColAUM, err.Columns[2].Column)
+	assert.Contains(t, err.Error(), "(sharpe)")
+}
+
+func TestParseRowTooShort(t *testing.T) {
+	_, err := ParseRow(1, []interface{}{"1", "2"})
+	assert.NotNil(t, err)
+	assert.Nil(t, err.Columns)
+}
+
+func TestDecodeAndParse(t *testing.T) {
+	body := `{"aaData":[` +
+		`["1","A","Fund A","MI","mm","1000","0","0","0","0","0","0","0","0","0","0","0","0","0","0","","","0",1.5],` +
+		`"broken",` +
+		`["2","B","","MI","fi","x","0","0","0","0","0","0","0","0","0","0","0","0","0","0","","","0","1"]` +
+		`]}`
+
+	rows, err := Decode(strings.NewReader(body))
+	assert.NoError(t, err)
+	assert.Len(t, rows, 3)
+
+	funds, rowErrs := Parse(rows)
+	assert.Len(t, funds, 1)
+	assert.Equal(t, "Fund A", funds[0].Name)
+	assert.Equal(t, 1.5e9, *funds[0].AUM)
+
+	assert.Len(t, rowErrs, 2)
+	assert.Equal(t, 1, rowErrs[0].Row)
+	assert.Equal(t, ErrNotRow, rowErrs[0].Err)
+	assert.Equal(t, 2, rowErrs[1].Row)
+	assert.Len(t, rowErrs[1].Columns, 2)
+}
+
+func TestDecodeJSONNumber(t *testing.T) {
+	// the json numbers don't follow the thousands separator rule
+	body := `{"aaData":[` +
+		`["1","A","Fund A","MI","mm",1500.250,1.234,"0","0","0","0","0","0","0","0","0","0","0","0","0","","","0",2.500]` +
+		`]}`
+
+	rows, err := Decode(strings.NewReader(body))
+	assert.NoError(t, err)
+
+	funds, rowErrs := Parse(rows)
+	assert.Len(t, rowErrs, 0)
+	assert.Len(t, funds, 1)
+	assert.Equal(t, 1500.25, *funds[0].LastNAV)
+	assert.Equal(t, 1.234, *funds[0].Returns.OneDay)
+	assert.Equal(t, 2.5e9, *funds[0].AUM)
+}
+
+func TestDecodeInvalidJSON(t *testing.T) {
+	_, err := Decode(strings.NewReader("{"))
+	assert.Error(t, err)
+}
+
+func TestParseContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	funds, rowErrs, err := New().ParseContext(ctx, []interface{}{testRow()})
+	assert.Equal(t, context.Canceled, err)
+	assert.Nil(t, funds)
+	assert.Nil(t, rowErrs)
+}
@@ -0,0 +1,133 @@
+package queue
+
+import (
+	"context"
+	"runtime"
+	"time"
+
+	"github.com/natansdj/go_scrape/logx"
+)
+
+// AutoscaleOption for the autoscaler
+type AutoscaleOption func(*Autoscaler)
+
+// Autoscaler resize the worker pool of the queue within its bounds. The
+// pool grows to a worker per queued job and shrinks by one worker when
+// some are idle, or when the upstream is slower than the max latency since
+// more workers only add load to it.
+type Autoscaler struct {
+	q          *Queue
+	min        int
+	max        int
+	interval   time.Duration
+	maxLatency time.Duration
+	latency    func() time.Duration
+}
+
+// WithScaleBounds setup the min and max number of workers
+func WithScaleBounds(min, max int) AutoscaleOption {
+	return func(a *Autoscaler) {
+		if min > 0 {
+			a.min = min
+		}
+		if max > 0 {
+			a.max = max
+		}
+	}
+}
+
+// WithScaleInterval setup the delay between two resizes
+func WithScaleInterval(d time.Duration) AutoscaleOption {
+	return func(a *Autoscaler) {
+		if d > 0 {
+			a.interval = d
+		}
+	}
+}
+
+// WithMaxLatency setup the upstream latency the pool doesn't grow above,
+// zero ignores the latency
+func WithMaxLatency(d time.Duration) AutoscaleOption {
+	return func(a *Autoscaler) {
+		a.maxLatency = d
+	}
+}
+
+// WithLatencyFunc setup the func returning the upstream latency
+func WithLatencyFunc(fn func() time.Duration) AutoscaleOption {
+	return func(a *Autoscaler) {
+		a.latency = fn
+	}
+}
+
+// NewAutoscaler returns an Autoscaler of the queue, default bounds are one
+// to twice the number of CPU, resized every 10 seconds.
+func NewAutoscaler(q *Queue, opts ...AutoscaleOption) *Autoscaler {
+	a := &Autoscaler{
+		q:        q,
+		min:      1,
+		max:      runtime.NumCPU() << 1,
+		interval: 10 * time.Second,
+		latency: func() time.Duration {
+			return 0
+		},
+	}
+
+	for _, opt := range opts {
+		opt(a)
+	}
+
+	if a.max < a.min {
+		a.max = a.min
+	}
+
+	return a
+}
+
+// Run resize the pool every interval until ctx is done
+func (a *Autoscaler) Run(ctx context.Context) error {
+	logx.LogAccess.Infof("autoscale the worker pool between %d and %d workers", a.min, a.max)
+
+	ticker := time.NewTicker(a.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			a.Scale()
+		}
+	}
+}
+
+// Scale resize the pool once and returns the number of workers
+func (a *Autoscaler) Scale() int {
+	workers := a.q.Workers()
+	usage := a.q.Usage()
+
+	target := workers
+	switch {
+	case a.maxLatency > 0 && a.latency() > a.maxLatency:
+		target--
+	case usage > workers:
+		target = usage
+	case usage < workers:
+		target--
+	}
+
+	if target < a.min {
+		target = a.min
+	}
+	if target > a.max {
+		target = a.max
+	}
+
+	if target != workers {
+		if err := a.q.Resize(target); err != nil {
+			logx.LogError.Error("can't resize the worker pool: ", err)
+		}
+	}
+
+	return a.q.Workers()
+}
@@ -0,0 +1,574 @@
+package disk
+
+import (
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/natansdj/go_scrape/config"
+	"github.com/natansdj/go_scrape/go_scrape"
+	"github.com/natansdj/go_scrape/logx"
+	"github.com/natansdj/go_scrape/queue"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	_ queue.Worker     = (*Worker)(nil)
+	_ queue.LaneWorker = (*Worker)(nil)
+	_ queue.Delayer    = (*Worker)(nil)
+)
+
+// Option for queue system
+type Option func(*Worker)
+
+var (
+	errMaxCapacity = queue.ErrMaxCapacity
+	errShutdown    = errors.New("disk worker is shut down")
+)
+
+// DefaultPath is the database file when queue.disk.path is empty
+const DefaultPath = "go_scrape-queue.db"
+
+// delayedBucket holds the delayed jobs, the key is the due time followed
+// by a sequence and the value is the lane followed by the payload
+var delayedBucket = []byte("delayed")
+
+// idleWait is how long the delay loop sleeps without delayed jobs
+const idleWait = time.Minute
+
+// Worker for local queue persisted in an embedded bolt database. Every job
+// is written to disk before it's queued and deleted once it's done, so the
+// jobs left by a crash or a restart are replayed on BeforeRun.
+//
+// Every priority has its own bucket and Run pick the lanes by weight. The
+// delayed jobs are moved to the bucket of their lane once they are due,
+// they count in the capacity of their lane.
+type Worker struct {
+	path         string
+	capacity     int
+	laneCapacity map[queue.Priority]int
+	weights      map[queue.Priority]int
+
+	db     *bolt.DB
+	keys   []chan uint64
+	picker *queue.Picker
+
+	runFunc    func(queue.QueuedMessage) error
+	failFunc   func(queue.QueuedMessage, error)
+	decodeFunc func([]byte) (queue.QueuedMessage, error)
+
+	// mu guards started and stopped against Queue and the handlers
+	mu        sync.RWMutex
+	started   bool
+	stopped   bool
+	startOnce sync.Once
+	startErr  error
+	stopOnce  sync.Once
+	busy      sync.WaitGroup
+	count     []int64
+	wake      chan struct{}
+	stop      chan struct{}
+	delaying  sync.WaitGroup
+}
+
+// laneBucket returns the bucket of the priority, the normal one is the
+// bucket of the jobs queued before the lanes
+func laneBucket(priority queue.Priority) []byte {
+	if priority == queue.PriorityNormal {
+		return []byte("jobs")
+	}
+	return []byte("jobs-" + string(priority))
+}
+
+// WithPath setup the database file
+func WithPath(path string) Option {
+	return func(w *Worker) {
+		if path != "" {
+			w.path = path
+		}
+	}
+}
+
+// WithQueueNum setup the capcity of queue
+func WithQueueNum(num int) Option {
+	return func(w *Worker) {
+		if num > 0 {
+			w.capacity = num
+		}
+	}
+}
+
+// WithLaneCapacity setup the capacity of a lane, zero means the lane is
+// only bounded by the queue capacity
+func WithLaneCapacity(priority queue.Priority, num int) Option {
+	return func(w *Worker) {
+		w.laneCapacity[priority] = num
+	}
+}
+
+// WithWeights setup how many jobs of each lane are dispatched in a round
+func WithWeights(weights map[queue.Priority]int) Option {
+	return func(w *Worker) {
+		for priority, weight := range weights {
+			if weight > 0 {
+				w.weights[priority] = weight
+			}
+		}
+	}
+}
+
+// WithRunFunc setup the run func of queue
+func WithRunFunc(fn func(queue.QueuedMessage) error) Option {
+	return func(w *Worker) {
+		w.runFunc = fn
+	}
+}
+
+// WithFailFunc setup the func called with the error of run func
+func WithFailFunc(fn func(queue.QueuedMessage, error)) Option {
+	return func(w *Worker) {
+		w.failFunc = fn
+	}
+}
+
+// WithDecodeFunc setup the func which turn the stored payload back into
+// the queued message
+func WithDecodeFunc(fn func([]byte) (queue.QueuedMessage, error)) Option {
+	return func(w *Worker) {
+		w.decodeFunc = fn
+	}
+}
+
+// NewWorker open the database file and count the jobs left in it
+func NewWorker(opts ...Option) (*Worker, error) {
+	w := &Worker{
+		path:         DefaultPath,
+		capacity:     runtime.NumCPU() << 1,
+		laneCapacity: map[queue.Priority]int{},
+		weights:      map[queue.Priority]int{},
+		runFunc:      go_scrape.Run,
+		failFunc:     go_scrape.DeadLetter,
+		decodeFunc: func(b []byte) (queue.QueuedMessage, error) {
+			return go_scrape.Decode(config.ConfYaml{}, b)
+		},
+	}
+	for priority, weight := range queue.DefaultWeights {
+		w.weights[priority] = weight
+	}
+
+	// Loop through each option
+	for _, opt := range opts {
+		// Call the option giving the instantiated
+		opt(w)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(w.path), os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	db, err := bolt.Open(w.path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	w.count = make([]int64, len(queue.Priorities))
+	err = db.Update(func(tx *bolt.Tx) error {
+		for i, priority := range queue.Priorities {
+			b, err := tx.CreateBucketIfNotExists(laneBucket(priority))
+			if err != nil {
+				return err
+			}
+			w.count[i] = int64(b.Stats().KeyN)
+		}
+
+		b, err := tx.CreateBucketIfNotExists(delayedBucket)
+		if err != nil {
+			return err
+		}
+		return b.ForEach(func(_, v []byte) error {
+			if len(v) > 0 && int(v[0]) < len(w.count) {
+				w.count[v[0]]++
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	w.db = db
+	w.picker = queue.NewPicker(w.weights)
+	w.wake = make(chan struct{}, 1)
+	w.stop = make(chan struct{})
+
+	// the jobs left on disk are replayed even when they are more than
+	// the capacity
+	for i := range queue.Priorities {
+		size := w.capacity
+		if int(w.count[i]) > size {
+			size = int(w.count[i])
+		}
+		w.keys = append(w.keys, make(chan uint64, size))
+	}
+
+	return w, nil
+}
+
+// BeforeRun replay the jobs left on disk once for all workers
+func (s *Worker) BeforeRun() error {
+	s.startOnce.Do(func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		var replayed int
+		s.startErr = s.db.View(func(tx *bolt.Tx) error {
+			for i, priority := range queue.Priorities {
+				err := tx.Bucket(laneBucket(priority)).ForEach(func(k, _ []byte) error {
+					s.keys[i] <- binary.BigEndian.Uint64(k)
+					replayed++
+					return nil
+				})
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if s.startErr != nil {
+			return
+		}
+
+		if replayed > 0 {
+			logx.LogAccess.Infof("replay %d jobs from the disk queue %s", replayed, s.path)
+		}
+		s.started = true
+
+		s.delaying.Add(1)
+		go s.delay()
+	})
+	return s.startErr
+}
+
+// delay move the due jobs to their lane until the worker is shut down
+func (s *Worker) delay() {
+	defer s.delaying.Done()
+
+	for {
+		timer := time.NewTimer(s.promote())
+		select {
+		case <-s.stop:
+			timer.Stop()
+			return
+		case <-s.wake:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+// promote move the due jobs to the bucket of their lane and returns the
+// wait until the next one is due.
+func (s *Worker) promote() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.stopped {
+		return idleWait
+	}
+
+	type due struct {
+		lane int
+		key  uint64
+	}
+
+	var (
+		moved []due
+		wait  = idleWait
+	)
+	now := time.Now()
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(delayedBucket)
+
+		var keys [][]byte
+		c := b.Cursor()
+		for k, _ := c.First(); k != nil; k, _ = c.Next() {
+			at := time.Unix(0, int64(binary.BigEndian.Uint64(k[:8])))
+			if at.After(now) {
+				wait = at.Sub(now)
+				break
+			}
+			keys = append(keys, append([]byte{}, k...))
+		}
+
+		for _, k := range keys {
+			v := b.Get(k)
+			if len(v) == 0 || int(v[0]) >= len(queue.Priorities) {
+				logx.LogError.Errorf("drop invalid delayed job from the disk queue %s", s.path)
+			} else {
+				lane := tx.Bucket(laneBucket(queue.Priorities[v[0]]))
+				key, err := lane.NextSequence()
+				if err != nil {
+					return err
+				}
+				if err := lane.Put(itob(key), v[1:]); err != nil {
+					return err
+				}
+				moved = append(moved, due{lane: int(v[0]), key: key})
+			}
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		logx.LogError.Errorf("can't move the due jobs of the disk queue: %v", err)
+		return time.Second
+	}
+
+	for _, d := range moved {
+		s.keys[d.lane] <- d.key
+	}
+	return wait
+}
+
+// AfterRun run script after start worker
+func (s *Worker) AfterRun() error {
+	return nil
+}
+
+// Run handle the jobs until the queue is shut down
+func (s *Worker) Run(quit chan struct{}) error {
+	for {
+		i, key, ok := s.next(quit)
+		if !ok || !s.handle(i, key) {
+			return nil
+		}
+	}
+}
+
+// next returns the key of the lane picked by weight, it blocks until a
+// lane has one and returns false when the queue is shut down.
+func (s *Worker) next(quit chan struct{}) (int, uint64, bool) {
+	if i := s.picker.Pick(func(i int) bool { return len(s.keys[i]) > 0 }); i >= 0 {
+		select {
+		case key := <-s.keys[i]:
+			return i, key, true
+		default:
+			// taken by another worker
+		}
+	}
+
+	select {
+	case <-quit:
+		return 0, 0, false
+	case key := <-s.keys[0]:
+		return 0, key, true
+	case key := <-s.keys[1]:
+		return 1, key, true
+	case key := <-s.keys[2]:
+		return 2, key, true
+	}
+}
+
+// handle run the job stored at key of the lane and delete it, it returns
+// false when the worker is shut down and the job is kept on disk for the
+// next start.
+func (s *Worker) handle(i int, key uint64) bool {
+	s.mu.RLock()
+	if s.stopped {
+		s.mu.RUnlock()
+		return false
+	}
+	s.busy.Add(1)
+	s.mu.RUnlock()
+	defer s.busy.Done()
+
+	bucket := laneBucket(queue.Priorities[i])
+
+	var payload []byte
+	err := s.db.View(func(tx *bolt.Tx) error {
+		if v := tx.Bucket(bucket).Get(itob(key)); v != nil {
+			payload = append([]byte{}, v...)
+		}
+		return nil
+	})
+	if err != nil {
+		logx.LogError.Errorf("can't read job %d from the disk queue: %v", key, err)
+		return true
+	}
+	if payload == nil {
+		return true
+	}
+
+	job, err := s.decodeFunc(payload)
+	if err != nil {
+		logx.LogError.Errorf("can't decode job %d from the disk queue: %v", key, err)
+		s.failFunc(queue.RawMessage(payload), queue.DecodeError(err))
+	} else if err := s.runFunc(job); err != nil {
+		s.failFunc(job, err)
+	}
+
+	s.delete(i, key)
+	return true
+}
+
+func (s *Worker) delete(i int, key uint64) {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(laneBucket(queue.Priorities[i])).Delete(itob(key))
+	})
+	if err != nil {
+		logx.LogError.Errorf("can't delete job %d from the disk queue: %v", key, err)
+		return
+	}
+	atomic.AddInt64(&s.count[i], -1)
+}
+
+// Shutdown stop taking jobs, wait the running ones and close the database.
+// The jobs not started yet stay on disk and are replayed on the next start.
+func (s *Worker) Shutdown() error {
+	var err error
+	s.stopOnce.Do(func() {
+		s.mu.Lock()
+		s.stopped = true
+		s.mu.Unlock()
+
+		close(s.stop)
+		s.delaying.Wait()
+		s.busy.Wait()
+		err = s.db.Close()
+	})
+	return err
+}
+
+// Capacity for the jobs on disk
+func (s *Worker) Capacity() int {
+	return s.capacity
+}
+
+// Usage for count of the jobs on disk, running ones included
+func (s *Worker) Usage() int {
+	var count int
+	for i := range s.count {
+		count += int(atomic.LoadInt64(&s.count[i]))
+	}
+	return count
+}
+
+// Lanes returns the capacity and the usage of every priority
+func (s *Worker) Lanes() []queue.Lane {
+	lanes := make([]queue.Lane, len(queue.Priorities))
+	for i, priority := range queue.Priorities {
+		lanes[i] = queue.Lane{
+			Priority: priority,
+			Weight:   s.picker.Weight(priority),
+			Capacity: s.laneCap(priority),
+			Usage:    int(atomic.LoadInt64(&s.count[i])),
+		}
+	}
+	return lanes
+}
+
+// laneCap returns the capacity of the lane, bounded by the worker capacity
+func (s *Worker) laneCap(priority queue.Priority) int {
+	if num := s.laneCapacity[priority]; num > 0 && num < s.capacity {
+		return num
+	}
+	return s.capacity
+}
+
+// reserve returns the lane of the job when both the worker and the lane
+// have room, the lock must be held.
+func (s *Worker) reserve(job queue.QueuedMessage) (int, error) {
+	if s.stopped {
+		return 0, errShutdown
+	}
+
+	priority := queue.PriorityOf(job)
+	i := priority.Index()
+	if s.Usage() >= s.capacity || int(atomic.LoadInt64(&s.count[i])) >= s.laneCap(priority) {
+		return i, errMaxCapacity
+	}
+	return i, nil
+}
+
+// Queue write the job to the bucket of its priority then send it to the
+// workers
+func (s *Worker) Queue(job queue.QueuedMessage) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i, err := s.reserve(job)
+	if err != nil {
+		return err
+	}
+	priority := queue.Priorities[i]
+
+	var key uint64
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(laneBucket(priority))
+		var err error
+		if key, err = b.NextSequence(); err != nil {
+			return err
+		}
+		return b.Put(itob(key), job.Bytes())
+	})
+	if err != nil {
+		return err
+	}
+	atomic.AddInt64(&s.count[i], 1)
+
+	// jobs queued before the start are sent by the replay
+	if s.started {
+		s.keys[i] <- key
+	}
+
+	return nil
+}
+
+// QueueAt write the job to the delayed bucket, it's moved to the bucket of
+// its priority once at is reached.
+func (s *Worker) QueueAt(job queue.QueuedMessage, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i, err := s.reserve(job)
+	if err != nil {
+		return err
+	}
+
+	err = s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(delayedBucket)
+		seq, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		key := append(itob(uint64(at.UnixNano())), itob(seq)...)
+		return b.Put(key, append([]byte{byte(i)}, job.Bytes()...))
+	})
+	if err != nil {
+		return err
+	}
+	atomic.AddInt64(&s.count[i], 1)
+
+	// the delay loop sleeps until the first due job
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+
+	return nil
+}
+
+// itob returns the big endian key, so the jobs are replayed in order
+func itob(v uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	return b
+}
@@ -0,0 +1,275 @@
+package disk
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/natansdj/go_scrape/queue"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type mockMessage struct {
+	msg string
+}
+
+func (m mockMessage) Bytes() []byte {
+	return []byte(m.msg)
+}
+
+type priorityMessage struct {
+	mockMessage
+	priority queue.Priority
+}
+
+func (m priorityMessage) QueuePriority() queue.Priority {
+	return m.priority
+}
+
+func decodeMock(b []byte) (queue.QueuedMessage, error) {
+	return mockMessage{msg: string(b)}, nil
+}
+
+func tempPath(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "disk-queue")
+	assert.NoError(t, err)
+
+	return filepath.Join(dir, "queue.db"), func() {
+		os.RemoveAll(dir)
+	}
+}
+
+func TestMaxCapacity(t *testing.T) {
+	path, clean := tempPath(t)
+	defer clean()
+
+	w, err := NewWorker(WithPath(path), WithQueueNum(2))
+	assert.NoError(t, err)
+	assert.Equal(t, 2, w.Capacity())
+	assert.Equal(t, 0, w.Usage())
+
+	assert.NoError(t, w.Queue(mockMessage{msg: "foo"}))
+	assert.NoError(t, w.Queue(mockMessage{msg: "bar"}))
+	assert.Equal(t, 2, w.Usage())
+	assert.Equal(t, errMaxCapacity, w.Queue(mockMessage{msg: "baz"}))
+
+	assert.NoError(t, w.Shutdown())
+	assert.Equal(t, errShutdown, w.Queue(mockMessage{msg: "baz"}))
+	// shutdown twice is safe
+	assert.NoError(t, w.Shutdown())
+}
+
+func TestReplayAfterRestart(t *testing.T) {
+	path, clean := tempPath(t)
+	defer clean()
+
+	w, err := NewWorker(WithPath(path))
+	assert.NoError(t, err)
+	assert.NoError(t, w.Queue(mockMessage{msg: "foo"}))
+	assert.NoError(t, w.Queue(mockMessage{msg: "bar"}))
+	// stopped before any worker started
+	assert.NoError(t, w.Shutdown())
+
+	received := make(chan string, 3)
+	w, err = NewWorker(
+		WithPath(path),
+		WithDecodeFunc(decodeMock),
+		WithRunFunc(func(msg queue.QueuedMessage) error {
+			received <- string(msg.Bytes())
+			return nil
+		}),
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, w.Usage())
+
+	q := queue.NewQueue(w, 1)
+	q.Start()
+	time.Sleep(100 * time.Millisecond)
+	assert.NoError(t, q.Queue(mockMessage{msg: "baz"}))
+
+	for _, expected := range []string{"foo", "bar", "baz"} {
+		select {
+		case msg := <-received:
+			assert.Equal(t, expected, msg)
+		case <-time.After(5 * time.Second):
+			t.Fatal("message is not received")
+		}
+	}
+
+	assert.Eventually(t, func() bool {
+		return w.Usage() == 0
+	}, time.Second, 10*time.Millisecond)
+
+	q.Shutdown()
+	q.Wait()
+}
+
+func TestPriorityLanes(t *testing.T) {
+	path, clean := tempPath(t)
+	defer clean()
+
+	w, err := NewWorker(WithPath(path), WithQueueNum(4), WithLaneCapacity(queue.PriorityLow, 1))
+	assert.NoError(t, err)
+	assert.NoError(t, w.Queue(priorityMessage{mockMessage{msg: "low"}, queue.PriorityLow}))
+	assert.Equal(t, errMaxCapacity, w.Queue(priorityMessage{mockMessage{msg: "low"}, queue.PriorityLow}))
+	assert.NoError(t, w.Queue(priorityMessage{mockMessage{msg: "high"}, queue.PriorityHigh}))
+	assert.Equal(t, 1, w.Lanes()[0].Usage)
+	assert.Equal(t, 1, w.Lanes()[2].Capacity)
+	assert.NoError(t, w.Shutdown())
+
+	// the lanes are replayed and the high one is dispatched first
+	received := make(chan string, 2)
+	w, err = NewWorker(
+		WithPath(path),
+		WithDecodeFunc(decodeMock),
+		WithRunFunc(func(msg queue.QueuedMessage) error {
+			received <- string(msg.Bytes())
+			return nil
+		}),
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, w.Usage())
+
+	q := queue.NewQueue(w, 1)
+	q.Start()
+
+	for _, expected := range []string{"high", "low"} {
+		select {
+		case msg := <-received:
+			assert.Equal(t, expected, msg)
+		case <-time.After(5 * time.Second):
+			t.Fatal("message is not received")
+		}
+	}
+
+	q.Shutdown()
+	q.Wait()
+}
+
+func TestQueueAt(t *testing.T) {
+	path, clean := tempPath(t)
+	defer clean()
+
+	w, err := NewWorker(WithPath(path), WithQueueNum(1))
+	assert.NoError(t, err)
+	at := time.Now().Add(300 * time.Millisecond)
+	assert.NoError(t, w.QueueAt(priorityMessage{mockMessage{msg: "foo"}, queue.PriorityHigh}, at))
+	// the delayed jobs count in the capacity
+	assert.Equal(t, errMaxCapacity, w.QueueAt(mockMessage{msg: "bar"}, at))
+	assert.NoError(t, w.Shutdown())
+	assert.Equal(t, errShutdown, w.QueueAt(mockMessage{msg: "bar"}, at))
+
+	// the delayed jobs are kept on disk
+	received := make(chan string, 1)
+	w, err = NewWorker(
+		WithPath(path),
+		WithDecodeFunc(decodeMock),
+		WithRunFunc(func(msg queue.QueuedMessage) error {
+			received <- string(msg.Bytes())
+			return nil
+		}),
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, w.Lanes()[0].Usage)
+
+	q := queue.NewQueue(w, 1)
+	q.Start()
+
+	select {
+	case msg := <-received:
+		assert.Equal(t, "foo", msg)
+		assert.False(t, time.Now().Before(at))
+	case <-time.After(5 * time.Second):
+		t.Fatal("message is not received")
+	}
+
+	assert.Eventually(t, func() bool {
+		return w.Usage() == 0
+	}, time.Second, 10*time.Millisecond)
+
+	q.Shutdown()
+	q.Wait()
+}
+
+func TestFailFunc(t *testing.T) {
+	path, clean := tempPath(t)
+	defer clean()
+
+	failed := make(chan error, 1)
+	w, err := NewWorker(
+		WithPath(path),
+		WithDecodeFunc(decodeMock),
+		WithRunFunc(func(msg queue.QueuedMessage) error {
+			return errors.New("upstream down")
+		}),
+		WithFailFunc(func(msg queue.QueuedMessage, err error) {
+			failed <- err
+		}),
+	)
+	assert.NoError(t, err)
+
+	q := queue.NewQueue(w, 1)
+	q.Start()
+	time.Sleep(100 * time.Millisecond)
+	assert.NoError(t, q.Queue(mockMessage{msg: "foo"}))
+
+	select {
+	case err := <-failed:
+		assert.EqualError(t, err, "upstream down")
+	case <-time.After(5 * time.Second):
+		t.Fatal("fail func is not called")
+	}
+
+	// the failed job is handed to the fail func and not replayed
+	assert.Eventually(t, func() bool {
+		return w.Usage() == 0
+	}, time.Second, 10*time.Millisecond)
+
+	q.Shutdown()
+	q.Wait()
+}
+
+func TestDecodeFailure(t *testing.T) {
+	path, clean := tempPath(t)
+	defer clean()
+
+	type failure struct {
+		msg queue.QueuedMessage
+		err error
+	}
+	failed := make(chan failure, 1)
+	w, err := NewWorker(
+		WithPath(path),
+		WithDecodeFunc(func(b []byte) (queue.QueuedMessage, error) {
+			return nil, errors.New("broken")
+		}),
+		WithRunFunc(func(msg queue.QueuedMessage) error {
+			t.Error("undecodable job is run")
+			return nil
+		}),
+		WithFailFunc(func(msg queue.QueuedMessage, err error) {
+			failed <- failure{msg, err}
+		}),
+	)
+	assert.NoError(t, err)
+
+	q := queue.NewQueue(w, 1)
+	q.Start()
+	assert.NoError(t, q.Queue(mockMessage{msg: "foo"}))
+
+	// the raw payload is handed to the fail func
+	select {
+	case f := <-failed:
+		assert.Equal(t, queue.RawMessage("foo"), f.msg)
+		assert.True(t, errors.Is(f.err, queue.ErrDecode))
+	case <-time.After(5 * time.Second):
+		t.Fatal("fail func is not called")
+	}
+
+	q.Shutdown()
+	q.Wait()
+}
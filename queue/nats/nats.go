@@ -0,0 +1,643 @@
+package nats
+
+import (
+	"errors"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/natansdj/go_scrape/config"
+	"github.com/natansdj/go_scrape/go_scrape"
+	"github.com/natansdj/go_scrape/logx"
+	"github.com/natansdj/go_scrape/queue"
+
+	"github.com/nats-io/nats.go"
+)
+
+var (
+	_ queue.Worker     = (*Worker)(nil)
+	_ queue.LaneWorker = (*Worker)(nil)
+	_ queue.Delayer    = (*Worker)(nil)
+)
+
+// Option for queue system
+type Option func(*Worker)
+
+var errShutdown = errors.New("nats worker is shut down")
+
+const (
+	// fetchWait is how long a single pull request waits for a message
+	fetchWait = time.Second
+	// runAtHeader is the due time of a delayed message
+	runAtHeader = "Go-Scrape-Run-At"
+	// subjectHeader is the lane subject of a delayed message
+	subjectHeader = "Go-Scrape-Subject"
+)
+
+// Worker for NATS JetStream, the messages are published to a stream and
+// pulled by a durable consumer into an internal channel read by every Run.
+// A message is acked when the run func succeeds, nak'ed until it reaches
+// maxDeliver and then handed to the fail func.
+//
+// Every priority has its own subject and durable consumer, the normal ones
+// are the subject and the durable themselves and the others get the
+// priority as suffix. Run pick the lanes by weight.
+//
+// The delayed messages are published to the delayed subject, its consumer
+// nak them until they are due and then publish them to their lane.
+type Worker struct {
+	addr        string
+	stream      string
+	subject     string
+	durable     string
+	maxInFlight int
+	maxDeliver  int
+	ackWait     time.Duration
+	nakDelay    time.Duration
+	weights     map[queue.Priority]int
+
+	conn    *nats.Conn
+	js      nats.JetStreamContext
+	subs    []*nats.Subscription
+	delayed *nats.Subscription
+	lanes   []chan *nats.Msg
+	picker  *queue.Picker
+
+	runFunc    func(queue.QueuedMessage) error
+	failFunc   func(queue.QueuedMessage, error)
+	decodeFunc func([]byte) (queue.QueuedMessage, error)
+
+	connectOnce sync.Once
+	connectErr  error
+	startOnce   sync.Once
+	startErr    error
+	stopOnce    sync.Once
+	stop        chan struct{}
+	fetching    sync.WaitGroup
+	stopped     int32
+	busy        []int64
+}
+
+// WithAddr setup the nats server url
+func WithAddr(addr string) Option {
+	return func(w *Worker) {
+		if addr != "" {
+			w.addr = addr
+		}
+	}
+}
+
+// WithStream setup the jetstream stream name
+func WithStream(stream string) Option {
+	return func(w *Worker) {
+		if stream != "" {
+			w.stream = stream
+		}
+	}
+}
+
+// WithSubject setup the subject the jobs are published to
+func WithSubject(subject string) Option {
+	return func(w *Worker) {
+		if subject != "" {
+			w.subject = subject
+		}
+	}
+}
+
+// WithDurable setup the durable consumer name
+func WithDurable(durable string) Option {
+	return func(w *Worker) {
+		if durable != "" {
+			w.durable = durable
+		}
+	}
+}
+
+// WithMaxInFlight setup the number of messages handled at the same time
+func WithMaxInFlight(num int) Option {
+	return func(w *Worker) {
+		if num > 0 {
+			w.maxInFlight = num
+		}
+	}
+}
+
+// WithMaxDeliver setup how many times a message is delivered before it is
+// handed to the fail func
+func WithMaxDeliver(num int) Option {
+	return func(w *Worker) {
+		if num > 0 {
+			w.maxDeliver = num
+		}
+	}
+}
+
+// WithAckWait setup how long the server waits for the ack before the
+// message is redelivered
+func WithAckWait(d time.Duration) Option {
+	return func(w *Worker) {
+		if d > 0 {
+			w.ackWait = d
+		}
+	}
+}
+
+// WithNakDelay setup the delay before a failed message is redelivered
+func WithNakDelay(d time.Duration) Option {
+	return func(w *Worker) {
+		if d >= 0 {
+			w.nakDelay = d
+		}
+	}
+}
+
+// WithWeights setup how many messages of each lane are dispatched in a
+// round
+func WithWeights(weights map[queue.Priority]int) Option {
+	return func(w *Worker) {
+		for priority, weight := range weights {
+			if weight > 0 {
+				w.weights[priority] = weight
+			}
+		}
+	}
+}
+
+// WithRunFunc setup the run func of queue
+func WithRunFunc(fn func(queue.QueuedMessage) error) Option {
+	return func(w *Worker) {
+		w.runFunc = fn
+	}
+}
+
+// WithFailFunc setup the func called with the error of run func once the
+// message reached the max deliver
+func WithFailFunc(fn func(queue.QueuedMessage, error)) Option {
+	return func(w *Worker) {
+		w.failFunc = fn
+	}
+}
+
+// WithDecodeFunc setup the func which turn the message data back into
+// the queued message
+func WithDecodeFunc(fn func([]byte) (queue.QueuedMessage, error)) Option {
+	return func(w *Worker) {
+		w.decodeFunc = fn
+	}
+}
+
+// NewWorker for struc
+func NewWorker(opts ...Option) *Worker {
+	w := &Worker{
+		addr:        nats.DefaultURL,
+		stream:      "go_scrape",
+		subject:     "go_scrape.jobs",
+		durable:     "go_scrape",
+		maxInFlight: runtime.NumCPU(),
+		maxDeliver:  3,
+		ackWait:     5 * time.Minute,
+		nakDelay:    30 * time.Second,
+		weights:     map[queue.Priority]int{},
+		stop:        make(chan struct{}),
+		runFunc:     go_scrape.Run,
+		failFunc:    go_scrape.DeadLetter,
+		decodeFunc: func(b []byte) (queue.QueuedMessage, error) {
+			return go_scrape.Decode(config.ConfYaml{}, b)
+		},
+	}
+	for priority, weight := range queue.DefaultWeights {
+		w.weights[priority] = weight
+	}
+
+	// Loop through each option
+	for _, opt := range opts {
+		// Call the option giving the instantiated
+		opt(w)
+	}
+
+	w.picker = queue.NewPicker(w.weights)
+	w.busy = make([]int64, len(queue.Priorities))
+	for range queue.Priorities {
+		w.lanes = append(w.lanes, make(chan *nats.Msg, w.maxInFlight))
+	}
+
+	return w
+}
+
+// laneSubject returns the subject of the priority
+func (s *Worker) laneSubject(priority queue.Priority) string {
+	if priority == queue.PriorityNormal {
+		return s.subject
+	}
+	return s.subject + "." + string(priority)
+}
+
+// laneDurable returns the durable consumer of the priority
+func (s *Worker) laneDurable(priority queue.Priority) string {
+	if priority == queue.PriorityNormal {
+		return s.durable
+	}
+	return s.durable + "_" + string(priority)
+}
+
+// delayedSubject returns the subject of the delayed messages
+func (s *Worker) delayedSubject() string {
+	return s.subject + ".delayed"
+}
+
+// delayedDurable returns the durable consumer of the delayed messages
+func (s *Worker) delayedDurable() string {
+	return s.durable + "_delayed"
+}
+
+// connect to the server and create the stream and the durable consumers
+// when they don't exist yet.
+func (s *Worker) connect() error {
+	s.connectOnce.Do(func() {
+		var err error
+		defer func() {
+			s.connectErr = err
+		}()
+
+		if s.conn, err = nats.Connect(s.addr, nats.Name("go_scrape")); err != nil {
+			return
+		}
+		if s.js, err = s.conn.JetStream(); err != nil {
+			return
+		}
+
+		subjects := make([]string, 0, len(queue.Priorities)+1)
+		for _, priority := range queue.Priorities {
+			subjects = append(subjects, s.laneSubject(priority))
+		}
+		subjects = append(subjects, s.delayedSubject())
+
+		var info *nats.StreamInfo
+		if info, err = s.js.StreamInfo(s.stream); errors.Is(err, nats.ErrStreamNotFound) {
+			_, err = s.js.AddStream(&nats.StreamConfig{
+				Name:      s.stream,
+				Subjects:  subjects,
+				Retention: nats.WorkQueuePolicy,
+			})
+		} else if err == nil && !hasSubjects(info.Config.Subjects, subjects) {
+			// the stream was created before the priority lanes
+			streamCfg := info.Config
+			streamCfg.Subjects = subjects
+			_, err = s.js.UpdateStream(&streamCfg)
+		}
+		if err != nil {
+			return
+		}
+
+		// the consumers are created here instead of by the subscription,
+		// so they aren't deleted when the worker is shut down
+		for _, priority := range queue.Priorities {
+			durable := s.laneDurable(priority)
+			if _, err = s.js.ConsumerInfo(s.stream, durable); errors.Is(err, nats.ErrConsumerNotFound) {
+				_, err = s.js.AddConsumer(s.stream, &nats.ConsumerConfig{
+					Durable:       durable,
+					AckPolicy:     nats.AckExplicitPolicy,
+					AckWait:       s.ackWait,
+					MaxDeliver:    s.maxDeliver,
+					MaxAckPending: s.maxInFlight,
+					FilterSubject: s.laneSubject(priority),
+				})
+			}
+			if err != nil {
+				return
+			}
+		}
+
+		// the delayed messages are redelivered until they are due
+		if _, err = s.js.ConsumerInfo(s.stream, s.delayedDurable()); errors.Is(err, nats.ErrConsumerNotFound) {
+			_, err = s.js.AddConsumer(s.stream, &nats.ConsumerConfig{
+				Durable:       s.delayedDurable(),
+				AckPolicy:     nats.AckExplicitPolicy,
+				AckWait:       s.ackWait,
+				FilterSubject: s.delayedSubject(),
+			})
+		}
+	})
+
+	return s.connectErr
+}
+
+// hasSubjects report whether every subject is in the list
+func hasSubjects(list, subjects []string) bool {
+	for _, subject := range subjects {
+		found := false
+		for _, v := range list {
+			if v == subject {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// BeforeRun subscribe the durable consumers once for all workers
+func (s *Worker) BeforeRun() error {
+	s.startOnce.Do(func() {
+		if s.startErr = s.connect(); s.startErr != nil {
+			return
+		}
+
+		for _, priority := range queue.Priorities {
+			durable := s.laneDurable(priority)
+			sub, err := s.js.PullSubscribe(s.laneSubject(priority), durable, nats.Bind(s.stream, durable))
+			if err != nil {
+				s.startErr = err
+				return
+			}
+			s.subs = append(s.subs, sub)
+		}
+
+		s.delayed, s.startErr = s.js.PullSubscribe(s.delayedSubject(), s.delayedDurable(), nats.Bind(s.stream, s.delayedDurable()))
+		if s.startErr != nil {
+			return
+		}
+		s.fetching.Add(1)
+		go s.fetchDelayed()
+
+		for i := range s.subs {
+			s.fetching.Add(1)
+			go s.fetch(i)
+		}
+	})
+	return s.startErr
+}
+
+// AfterRun run script after start worker
+func (s *Worker) AfterRun() error {
+	return nil
+}
+
+// fetch pull the messages of a lane one by one into its channel until the
+// worker is shut down.
+func (s *Worker) fetch(i int) {
+	defer s.fetching.Done()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		default:
+		}
+
+		msgs, err := s.subs[i].Fetch(1, nats.MaxWait(fetchWait))
+		if err != nil {
+			if !errors.Is(err, nats.ErrTimeout) && atomic.LoadInt32(&s.stopped) == 0 {
+				logx.LogError.Errorf("can't fetch nats message: %v", err)
+				select {
+				case <-s.stop:
+					return
+				case <-time.After(fetchWait):
+				}
+			}
+			continue
+		}
+
+		for _, msg := range msgs {
+			select {
+			case s.lanes[i] <- msg:
+			case <-s.stop:
+				_ = msg.Nak()
+				return
+			}
+		}
+	}
+}
+
+// fetchDelayed pull the delayed messages and publish the due ones to their
+// lane until the worker is shut down.
+func (s *Worker) fetchDelayed() {
+	defer s.fetching.Done()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		default:
+		}
+
+		msgs, err := s.delayed.Fetch(100, nats.MaxWait(fetchWait))
+		if err != nil {
+			if !errors.Is(err, nats.ErrTimeout) && atomic.LoadInt32(&s.stopped) == 0 {
+				logx.LogError.Errorf("can't fetch delayed nats message: %v", err)
+				select {
+				case <-s.stop:
+					return
+				case <-time.After(fetchWait):
+				}
+			}
+			continue
+		}
+
+		for _, msg := range msgs {
+			s.promote(msg)
+		}
+	}
+}
+
+// promote publish the delayed message to its lane once it's due, or nak
+// it until then.
+func (s *Worker) promote(msg *nats.Msg) {
+	at, err := time.Parse(time.RFC3339Nano, msg.Header.Get(runAtHeader))
+	if err != nil {
+		logx.LogError.Errorf("can't parse the due time of delayed nats message: %v", err)
+		_ = msg.Term()
+		return
+	}
+
+	if wait := time.Until(at); wait > 0 {
+		_ = msg.NakWithDelay(wait)
+		return
+	}
+
+	if _, err := s.js.Publish(msg.Header.Get(subjectHeader), msg.Data); err != nil {
+		logx.LogError.Errorf("can't publish due nats message: %v", err)
+		_ = msg.NakWithDelay(fetchWait)
+		return
+	}
+
+	if err := msg.Ack(); err != nil {
+		logx.LogError.Errorf("can't ack delayed nats message: %v", err)
+	}
+}
+
+// Run handle the messages until the queue is shut down
+func (s *Worker) Run(quit chan struct{}) error {
+	for {
+		i, msg, ok := s.next(quit)
+		if !ok {
+			return nil
+		}
+		s.handle(i, msg)
+	}
+}
+
+// next returns the message of the lane picked by weight, it blocks until a
+// lane has one and returns false when the queue is shut down.
+func (s *Worker) next(quit chan struct{}) (int, *nats.Msg, bool) {
+	if i := s.picker.Pick(func(i int) bool { return len(s.lanes[i]) > 0 }); i >= 0 {
+		select {
+		case msg := <-s.lanes[i]:
+			return i, msg, true
+		default:
+			// taken by another worker
+		}
+	}
+
+	select {
+	case <-quit:
+		return 0, nil, false
+	case msg := <-s.lanes[0]:
+		return 0, msg, true
+	case msg := <-s.lanes[1]:
+		return 1, msg, true
+	case msg := <-s.lanes[2]:
+		return 2, msg, true
+	}
+}
+
+func (s *Worker) handle(i int, msg *nats.Msg) {
+	atomic.AddInt64(&s.busy[i], 1)
+	defer atomic.AddInt64(&s.busy[i], -1)
+
+	job, err := s.decodeFunc(msg.Data)
+	if err != nil {
+		logx.LogError.Errorf("can't decode nats message on %s: %v", msg.Subject, err)
+		s.failFunc(queue.RawMessage(msg.Data), queue.DecodeError(err))
+		_ = msg.Term()
+		return
+	}
+
+	delivered := 1
+	if meta, err := msg.Metadata(); err == nil {
+		delivered = int(meta.NumDelivered)
+	}
+
+	// the payload is the one first published, carry on the attempt count
+	if v, ok := job.(*go_scrape.ScrapeJob); ok && v.Attempt < delivered-1 {
+		v.Attempt = delivered - 1
+	}
+
+	if err := s.runFunc(job); err != nil {
+		if delivered < s.maxDeliver {
+			logx.LogError.Errorf("nats message delivery %d/%d failed, redeliver in %s: %v", delivered, s.maxDeliver, s.nakDelay, err)
+			_ = msg.NakWithDelay(s.nakDelay)
+			return
+		}
+
+		s.failFunc(job, err)
+		_ = msg.Term()
+		return
+	}
+
+	if err := msg.Ack(); err != nil {
+		logx.LogError.Errorf("can't ack nats message on %s: %v", msg.Subject, err)
+	}
+}
+
+// Shutdown stop pulling messages and close the connection. The messages
+// not handled yet are nak'ed, the ones still running are redelivered by
+// the server after the ack wait.
+func (s *Worker) Shutdown() error {
+	s.stopOnce.Do(func() {
+		atomic.StoreInt32(&s.stopped, 1)
+		close(s.stop)
+		s.fetching.Wait()
+
+		for _, lane := range s.lanes {
+		pending:
+			for {
+				select {
+				case msg := <-lane:
+					_ = msg.Nak()
+				default:
+					break pending
+				}
+			}
+		}
+
+		if s.conn != nil {
+			if err := s.conn.Drain(); err != nil {
+				s.conn.Close()
+			}
+		}
+	})
+	return nil
+}
+
+// Capacity is the max in flight messages of a lane
+func (s *Worker) Capacity() int {
+	return s.maxInFlight
+}
+
+// Usage is the number of pulled messages not finished yet
+func (s *Worker) Usage() int {
+	var count int
+	for i := range s.lanes {
+		count += s.laneUsage(i)
+	}
+	return count
+}
+
+// Lanes returns the in flight messages of every priority
+func (s *Worker) Lanes() []queue.Lane {
+	lanes := make([]queue.Lane, len(queue.Priorities))
+	for i, priority := range queue.Priorities {
+		lanes[i] = queue.Lane{
+			Priority: priority,
+			Weight:   s.picker.Weight(priority),
+			Capacity: s.maxInFlight,
+			Usage:    s.laneUsage(i),
+		}
+	}
+	return lanes
+}
+
+func (s *Worker) laneUsage(i int) int {
+	return len(s.lanes[i]) + int(atomic.LoadInt64(&s.busy[i]))
+}
+
+// Queue publish the message to the subject of its priority and wait the
+// server ack
+func (s *Worker) Queue(job queue.QueuedMessage) error {
+	if atomic.LoadInt32(&s.stopped) == 1 {
+		return errShutdown
+	}
+
+	if err := s.connect(); err != nil {
+		return err
+	}
+
+	_, err := s.js.Publish(s.laneSubject(queue.PriorityOf(job)), job.Bytes())
+	return err
+}
+
+// QueueAt publish the message to the delayed subject, it's published to
+// the subject of its priority once at is reached.
+func (s *Worker) QueueAt(job queue.QueuedMessage, at time.Time) error {
+	if atomic.LoadInt32(&s.stopped) == 1 {
+		return errShutdown
+	}
+
+	if err := s.connect(); err != nil {
+		return err
+	}
+
+	msg := nats.NewMsg(s.delayedSubject())
+	msg.Header.Set(runAtHeader, at.UTC().Format(time.RFC3339Nano))
+	msg.Header.Set(subjectHeader, s.laneSubject(queue.PriorityOf(job)))
+	msg.Data = job.Bytes()
+
+	_, err := s.js.PublishMsg(msg)
+	return err
+}
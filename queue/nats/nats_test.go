@@ -0,0 +1,213 @@
+package nats
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/natansdj/go_scrape/queue"
+
+	"github.com/nats-io/nats-server/v2/server"
+	"github.com/stretchr/testify/assert"
+)
+
+type mockMessage struct {
+	Msg string `json:"msg"`
+}
+
+func (m mockMessage) Bytes() []byte {
+	return []byte(`{"msg":"` + m.Msg + `"}`)
+}
+
+type priorityMessage struct {
+	mockMessage
+	priority queue.Priority
+}
+
+func (m priorityMessage) QueuePriority() queue.Priority {
+	return m.priority
+}
+
+func decodeMock(b []byte) (queue.QueuedMessage, error) {
+	return mockMessage{Msg: string(b)}, nil
+}
+
+// startServer run an in-process nats server with jetstream on a random port
+func startServer(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "nats")
+	assert.NoError(t, err)
+
+	s, err := server.NewServer(&server.Options{
+		Host:      "127.0.0.1",
+		Port:      -1,
+		JetStream: true,
+		StoreDir:  dir,
+		NoLog:     true,
+		NoSigs:    true,
+	})
+	assert.NoError(t, err)
+
+	go s.Start()
+	if !s.ReadyForConnections(5 * time.Second) {
+		t.Fatal("nats server is not ready")
+	}
+
+	return s.ClientURL(), func() {
+		s.Shutdown()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestNATSWorker(t *testing.T) {
+	addr, stop := startServer(t)
+	defer stop()
+
+	received := make(chan string, 2)
+	w := NewWorker(
+		WithAddr(addr),
+		WithMaxInFlight(2),
+		WithDecodeFunc(decodeMock),
+		WithRunFunc(func(msg queue.QueuedMessage) error {
+			received <- msg.(mockMessage).Msg
+			return nil
+		}),
+	)
+	assert.Equal(t, 2, w.Capacity())
+
+	q := queue.NewQueue(w, 2)
+	assert.NoError(t, q.Queue(mockMessage{Msg: "foo"}))
+	assert.NoError(t, q.Queue(mockMessage{Msg: "bar"}))
+	q.Start()
+
+	for i := 0; i < 2; i++ {
+		select {
+		case msg := <-received:
+			assert.Contains(t, []string{`{"msg":"foo"}`, `{"msg":"bar"}`}, msg)
+		case <-time.After(5 * time.Second):
+			t.Fatal("message is not received")
+		}
+	}
+
+	q.Shutdown()
+	q.Wait()
+	assert.Equal(t, 0, w.Usage())
+	assert.Equal(t, errShutdown, w.Queue(mockMessage{Msg: "baz"}))
+	// shutdown twice is safe
+	assert.NoError(t, w.Shutdown())
+}
+
+func TestNATSWorkerRedeliver(t *testing.T) {
+	addr, stop := startServer(t)
+	defer stop()
+
+	runs := make(chan struct{}, 3)
+	failed := make(chan error, 1)
+	w := NewWorker(
+		WithAddr(addr),
+		WithDurable("redeliver"),
+		WithMaxDeliver(3),
+		WithNakDelay(0),
+		WithDecodeFunc(decodeMock),
+		WithRunFunc(func(msg queue.QueuedMessage) error {
+			runs <- struct{}{}
+			return errors.New("upstream down")
+		}),
+		WithFailFunc(func(msg queue.QueuedMessage, err error) {
+			failed <- err
+		}),
+	)
+
+	q := queue.NewQueue(w, 1)
+	q.Start()
+	assert.NoError(t, q.Queue(mockMessage{Msg: "foo"}))
+
+	select {
+	case err := <-failed:
+		assert.EqualError(t, err, "upstream down")
+	case <-time.After(10 * time.Second):
+		t.Fatal("fail func is not called")
+	}
+	assert.Len(t, runs, 3)
+
+	q.Shutdown()
+	q.Wait()
+}
+
+func TestNATSWorkerPriority(t *testing.T) {
+	addr, stop := startServer(t)
+	defer stop()
+
+	received := make(chan string, 3)
+	w := NewWorker(
+		WithAddr(addr),
+		WithDecodeFunc(decodeMock),
+		WithRunFunc(func(msg queue.QueuedMessage) error {
+			received <- msg.(mockMessage).Msg
+			return nil
+		}),
+	)
+	assert.Len(t, w.Lanes(), 3)
+
+	q := queue.NewQueue(w, 1)
+	for _, priority := range queue.Priorities {
+		assert.NoError(t, q.Queue(priorityMessage{
+			mockMessage: mockMessage{Msg: string(priority)},
+			priority:    priority,
+		}))
+	}
+	q.Start()
+
+	// every lane consumer is subscribed
+	msgs := []string{}
+	for i := 0; i < 3; i++ {
+		select {
+		case msg := <-received:
+			msgs = append(msgs, msg)
+		case <-time.After(5 * time.Second):
+			t.Fatal("message is not received")
+		}
+	}
+	assert.ElementsMatch(t, []string{`{"msg":"high"}`, `{"msg":"normal"}`, `{"msg":"low"}`}, msgs)
+
+	q.Shutdown()
+	q.Wait()
+}
+
+func TestNATSWorkerQueueAt(t *testing.T) {
+	addr, stop := startServer(t)
+	defer stop()
+
+	received := make(chan time.Time, 1)
+	w := NewWorker(
+		WithAddr(addr),
+		WithDecodeFunc(decodeMock),
+		WithRunFunc(func(msg queue.QueuedMessage) error {
+			received <- time.Now()
+			return nil
+		}),
+	)
+
+	q := queue.NewQueue(w, 1)
+	at := time.Now().Add(300 * time.Millisecond)
+	assert.NoError(t, q.QueueAt(mockMessage{Msg: "foo"}, at))
+	q.Start()
+
+	select {
+	case now := <-received:
+		assert.False(t, now.Before(at))
+	case <-time.After(5 * time.Second):
+		t.Fatal("message is not received")
+	}
+
+	q.Shutdown()
+	q.Wait()
+	assert.Equal(t, errShutdown, w.QueueAt(mockMessage{Msg: "foo"}, at))
+}
+
+func TestNATSWorkerConnectError(t *testing.T) {
+	w := NewWorker(WithAddr("nats://127.0.0.1:1"))
+	assert.Error(t, w.BeforeRun())
+	assert.Error(t, w.Queue(mockMessage{Msg: "foo"}))
+}
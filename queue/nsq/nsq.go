@@ -0,0 +1,336 @@
+package nsq
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/natansdj/go_scrape/config"
+	"github.com/natansdj/go_scrape/go_scrape"
+	"github.com/natansdj/go_scrape/logx"
+	"github.com/natansdj/go_scrape/queue"
+
+	"github.com/nsqio/go-nsq"
+)
+
+var (
+	_ queue.Worker     = (*Worker)(nil)
+	_ queue.LaneWorker = (*Worker)(nil)
+	_ queue.Delayer    = (*Worker)(nil)
+)
+
+// Option for queue system
+type Option func(*Worker)
+
+var errShutdown = errors.New("nsq worker is shut down")
+
+// Worker for NSQ, every priority has its own topic, the normal one is the
+// topic itself and the others get the priority as suffix. A consumer per
+// topic feed the messages into the channel of its lane and Run pick the
+// lanes by weight.
+type Worker struct {
+	addr        string
+	topic       string
+	channel     string
+	maxInFlight int
+	maxDelay    time.Duration
+	weights     map[queue.Priority]int
+
+	consumers []*nsq.Consumer
+	producer  *nsq.Producer
+	lanes     []chan *nsq.Message
+	picker    *queue.Picker
+
+	runFunc    func(queue.QueuedMessage) error
+	failFunc   func(queue.QueuedMessage, error)
+	decodeFunc func([]byte) (queue.QueuedMessage, error)
+
+	startOnce sync.Once
+	startErr  error
+	stopOnce  sync.Once
+	stopped   int32
+	busy      []int64
+}
+
+// WithAddr setup the nsqd address
+func WithAddr(addr string) Option {
+	return func(w *Worker) {
+		w.addr = addr
+	}
+}
+
+// WithTopic setup the topic
+func WithTopic(topic string) Option {
+	return func(w *Worker) {
+		w.topic = topic
+	}
+}
+
+// WithChannel setup the channel
+func WithChannel(channel string) Option {
+	return func(w *Worker) {
+		w.channel = channel
+	}
+}
+
+// WithMaxDelay setup the max delay of QueueAt, the --max-req-timeout of
+// nsqd
+func WithMaxDelay(d time.Duration) Option {
+	return func(w *Worker) {
+		if d > 0 {
+			w.maxDelay = d
+		}
+	}
+}
+
+// WithMaxInFlight setup the number of messages handled at the same time
+func WithMaxInFlight(num int) Option {
+	return func(w *Worker) {
+		if num > 0 {
+			w.maxInFlight = num
+		}
+	}
+}
+
+// WithWeights setup how many messages of each lane are dispatched in a
+// round
+func WithWeights(weights map[queue.Priority]int) Option {
+	return func(w *Worker) {
+		for priority, weight := range weights {
+			if weight > 0 {
+				w.weights[priority] = weight
+			}
+		}
+	}
+}
+
+// WithRunFunc setup the run func of queue
+func WithRunFunc(fn func(queue.QueuedMessage) error) Option {
+	return func(w *Worker) {
+		w.runFunc = fn
+	}
+}
+
+// WithFailFunc setup the func called with the error of run func
+func WithFailFunc(fn func(queue.QueuedMessage, error)) Option {
+	return func(w *Worker) {
+		w.failFunc = fn
+	}
+}
+
+// WithDecodeFunc setup the func which turn the message body back into
+// the queued message
+func WithDecodeFunc(fn func([]byte) (queue.QueuedMessage, error)) Option {
+	return func(w *Worker) {
+		w.decodeFunc = fn
+	}
+}
+
+// NewWorker for struc
+func NewWorker(opts ...Option) (*Worker, error) {
+	w := &Worker{
+		addr:        "127.0.0.1:4150",
+		topic:       "go_scrape",
+		channel:     "ch",
+		maxInFlight: runtime.NumCPU(),
+		maxDelay:    time.Hour,
+		weights:     map[queue.Priority]int{},
+		runFunc:     go_scrape.Run,
+		failFunc:    go_scrape.DeadLetter,
+		decodeFunc: func(b []byte) (queue.QueuedMessage, error) {
+			return go_scrape.Decode(config.ConfYaml{}, b)
+		},
+	}
+	for priority, weight := range queue.DefaultWeights {
+		w.weights[priority] = weight
+	}
+
+	// Loop through each option
+	for _, opt := range opts {
+		// Call the option giving the instantiated
+		opt(w)
+	}
+
+	w.picker = queue.NewPicker(w.weights)
+	w.busy = make([]int64, len(queue.Priorities))
+
+	cfg := nsq.NewConfig()
+	cfg.MaxInFlight = w.maxInFlight
+
+	for _, priority := range queue.Priorities {
+		lane := make(chan *nsq.Message, w.maxInFlight)
+		consumer, err := nsq.NewConsumer(w.laneTopic(priority), w.channel, cfg)
+		if err != nil {
+			return nil, err
+		}
+		consumer.AddHandler(nsq.HandlerFunc(func(msg *nsq.Message) error {
+			msg.DisableAutoResponse()
+			lane <- msg
+			return nil
+		}))
+
+		w.lanes = append(w.lanes, lane)
+		w.consumers = append(w.consumers, consumer)
+	}
+
+	var err error
+	if w.producer, err = nsq.NewProducer(w.addr, cfg); err != nil {
+		return nil, err
+	}
+
+	return w, nil
+}
+
+// laneTopic returns the topic of the priority
+func (s *Worker) laneTopic(priority queue.Priority) string {
+	if priority == queue.PriorityNormal {
+		return s.topic
+	}
+	return s.topic + "_" + string(priority)
+}
+
+// BeforeRun connect the consumer once for all workers
+func (s *Worker) BeforeRun() error {
+	s.startOnce.Do(func() {
+		for _, consumer := range s.consumers {
+			if s.startErr = consumer.ConnectToNSQD(s.addr); s.startErr != nil {
+				return
+			}
+		}
+	})
+	return s.startErr
+}
+
+// AfterRun run script after start worker
+func (s *Worker) AfterRun() error {
+	return nil
+}
+
+// Run handle the messages until the queue is shut down
+func (s *Worker) Run(quit chan struct{}) error {
+	for {
+		i, msg, ok := s.next(quit)
+		if !ok {
+			return nil
+		}
+		s.handle(i, msg)
+	}
+}
+
+// next returns the message of the lane picked by weight, it blocks until a
+// lane has one and returns false when the queue is shut down.
+func (s *Worker) next(quit chan struct{}) (int, *nsq.Message, bool) {
+	if i := s.picker.Pick(func(i int) bool { return len(s.lanes[i]) > 0 }); i >= 0 {
+		select {
+		case msg := <-s.lanes[i]:
+			return i, msg, true
+		default:
+			// taken by another worker
+		}
+	}
+
+	select {
+	case <-quit:
+		return 0, nil, false
+	case msg := <-s.lanes[0]:
+		return 0, msg, true
+	case msg := <-s.lanes[1]:
+		return 1, msg, true
+	case msg := <-s.lanes[2]:
+		return 2, msg, true
+	}
+}
+
+func (s *Worker) handle(i int, msg *nsq.Message) {
+	atomic.AddInt64(&s.busy[i], 1)
+	defer atomic.AddInt64(&s.busy[i], -1)
+	defer msg.Finish()
+
+	job, err := s.decodeFunc(msg.Body)
+	if err != nil {
+		logx.LogError.Errorf("can't decode nsq message %s: %v", msg.ID, err)
+		s.failFunc(queue.RawMessage(msg.Body), queue.DecodeError(err))
+		return
+	}
+
+	if err := s.runFunc(job); err != nil {
+		s.failFunc(job, err)
+	}
+}
+
+// Shutdown stop the consumer and the producer, unfinished messages are
+// requeued by nsqd.
+func (s *Worker) Shutdown() error {
+	s.stopOnce.Do(func() {
+		atomic.StoreInt32(&s.stopped, 1)
+		for _, consumer := range s.consumers {
+			consumer.Stop()
+		}
+		for _, consumer := range s.consumers {
+			<-consumer.StopChan
+		}
+		s.producer.Stop()
+	})
+	return nil
+}
+
+// Capacity is the max in flight messages of a lane
+func (s *Worker) Capacity() int {
+	return s.maxInFlight
+}
+
+// Usage is the number of received messages not finished yet
+func (s *Worker) Usage() int {
+	var count int
+	for i := range s.lanes {
+		count += s.laneUsage(i)
+	}
+	return count
+}
+
+// Lanes returns the in flight messages of every priority
+func (s *Worker) Lanes() []queue.Lane {
+	lanes := make([]queue.Lane, len(queue.Priorities))
+	for i, priority := range queue.Priorities {
+		lanes[i] = queue.Lane{
+			Priority: priority,
+			Weight:   s.picker.Weight(priority),
+			Capacity: s.maxInFlight,
+			Usage:    s.laneUsage(i),
+		}
+	}
+	return lanes
+}
+
+func (s *Worker) laneUsage(i int) int {
+	return len(s.lanes[i]) + int(atomic.LoadInt64(&s.busy[i]))
+}
+
+// Queue publish the message to the topic of its priority
+func (s *Worker) Queue(job queue.QueuedMessage) error {
+	if atomic.LoadInt32(&s.stopped) == 1 {
+		return errShutdown
+	}
+
+	return s.producer.Publish(s.laneTopic(queue.PriorityOf(job)), job.Bytes())
+}
+
+// QueueAt publish the message deferred until at, the delay over the
+// --max-req-timeout of nsqd is rejected with queue.ErrDelayTooLong.
+func (s *Worker) QueueAt(job queue.QueuedMessage, at time.Time) error {
+	if atomic.LoadInt32(&s.stopped) == 1 {
+		return errShutdown
+	}
+
+	delay := time.Until(at)
+	if delay <= 0 {
+		return s.Queue(job)
+	}
+	if delay > s.maxDelay {
+		return fmt.Errorf("%w: %s over %s", queue.ErrDelayTooLong, delay.Round(time.Second), s.maxDelay)
+	}
+	return s.producer.DeferredPublish(s.laneTopic(queue.PriorityOf(job)), delay, job.Bytes())
+}
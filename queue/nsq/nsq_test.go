@@ -0,0 +1,219 @@
+package nsq
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/natansdj/go_scrape/queue"
+
+	"github.com/nsqio/nsq/nsqd"
+	"github.com/stretchr/testify/assert"
+)
+
+type mockMessage struct {
+	Msg string `json:"msg"`
+}
+
+func (m mockMessage) Bytes() []byte {
+	return []byte(`{"msg":"` + m.Msg + `"}`)
+}
+
+type priorityMessage struct {
+	mockMessage
+	priority queue.Priority
+}
+
+func (m priorityMessage) QueuePriority() queue.Priority {
+	return m.priority
+}
+
+func decodeMock(b []byte) (queue.QueuedMessage, error) {
+	return mockMessage{Msg: string(b)}, nil
+}
+
+// startNSQD run an in-process nsqd on random ports
+func startNSQD(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "nsqd")
+	assert.NoError(t, err)
+
+	opts := nsqd.NewOptions()
+	opts.TCPAddress = "127.0.0.1:0"
+	opts.HTTPAddress = "127.0.0.1:0"
+	opts.HTTPSAddress = "127.0.0.1:0"
+	opts.DataPath = dir
+	opts.LogLevel = nsqd.LOG_ERROR
+	// pick up the new channels quickly for the deferred messages
+	opts.QueueScanRefreshInterval = 100 * time.Millisecond
+
+	n, err := nsqd.New(opts)
+	assert.NoError(t, err)
+	go func() {
+		_ = n.Main()
+	}()
+
+	return n.RealTCPAddr().String(), func() {
+		n.Exit()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestNSQWorker(t *testing.T) {
+	addr, stop := startNSQD(t)
+	defer stop()
+
+	received := make(chan string, 2)
+	w, err := NewWorker(
+		WithAddr(addr),
+		WithTopic("test"),
+		WithMaxInFlight(2),
+		WithDecodeFunc(decodeMock),
+		WithRunFunc(func(msg queue.QueuedMessage) error {
+			received <- msg.(mockMessage).Msg
+			return nil
+		}),
+	)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, w.Capacity())
+
+	q := queue.NewQueue(w, 2)
+	assert.NoError(t, q.Queue(mockMessage{Msg: "foo"}))
+	assert.NoError(t, q.Queue(mockMessage{Msg: "bar"}))
+	q.Start()
+
+	for i := 0; i < 2; i++ {
+		select {
+		case msg := <-received:
+			assert.Contains(t, []string{`{"msg":"foo"}`, `{"msg":"bar"}`}, msg)
+		case <-time.After(5 * time.Second):
+			t.Fatal("message is not received")
+		}
+	}
+
+	q.Shutdown()
+	q.Wait()
+	assert.Equal(t, 0, w.Usage())
+	assert.Equal(t, errShutdown, w.Queue(mockMessage{Msg: "baz"}))
+	// shutdown twice is safe
+	assert.NoError(t, w.Shutdown())
+}
+
+func TestNSQWorkerFailFunc(t *testing.T) {
+	addr, stop := startNSQD(t)
+	defer stop()
+
+	failed := make(chan error, 1)
+	w, err := NewWorker(
+		WithAddr(addr),
+		WithTopic("fail"),
+		WithDecodeFunc(decodeMock),
+		WithRunFunc(func(msg queue.QueuedMessage) error {
+			return errors.New("upstream down")
+		}),
+		WithFailFunc(func(msg queue.QueuedMessage, err error) {
+			failed <- err
+		}),
+	)
+	assert.NoError(t, err)
+
+	q := queue.NewQueue(w, 1)
+	q.Start()
+	assert.NoError(t, q.Queue(mockMessage{Msg: "foo"}))
+
+	select {
+	case err := <-failed:
+		assert.EqualError(t, err, "upstream down")
+	case <-time.After(5 * time.Second):
+		t.Fatal("fail func is not called")
+	}
+
+	q.Shutdown()
+	q.Wait()
+}
+
+func TestNSQWorkerPriority(t *testing.T) {
+	addr, stop := startNSQD(t)
+	defer stop()
+
+	received := make(chan string, 3)
+	w, err := NewWorker(
+		WithAddr(addr),
+		WithTopic("lanes"),
+		WithDecodeFunc(decodeMock),
+		WithRunFunc(func(msg queue.QueuedMessage) error {
+			received <- msg.(mockMessage).Msg
+			return nil
+		}),
+	)
+	assert.NoError(t, err)
+	assert.Len(t, w.Lanes(), 3)
+
+	q := queue.NewQueue(w, 1)
+	q.Start()
+	for _, priority := range queue.Priorities {
+		assert.NoError(t, q.Queue(priorityMessage{
+			mockMessage: mockMessage{Msg: string(priority)},
+			priority:    priority,
+		}))
+	}
+
+	// every lane topic is consumed
+	msgs := []string{}
+	for i := 0; i < 3; i++ {
+		select {
+		case msg := <-received:
+			msgs = append(msgs, msg)
+		case <-time.After(5 * time.Second):
+			t.Fatal("message is not received")
+		}
+	}
+	assert.ElementsMatch(t, []string{`{"msg":"high"}`, `{"msg":"normal"}`, `{"msg":"low"}`}, msgs)
+
+	q.Shutdown()
+	q.Wait()
+}
+
+func TestNSQWorkerQueueAt(t *testing.T) {
+	addr, stop := startNSQD(t)
+	defer stop()
+
+	received := make(chan time.Time, 1)
+	w, err := NewWorker(
+		WithAddr(addr),
+		WithTopic("delayed"),
+		WithDecodeFunc(decodeMock),
+		WithRunFunc(func(msg queue.QueuedMessage) error {
+			received <- time.Now()
+			return nil
+		}),
+	)
+	assert.NoError(t, err)
+
+	q := queue.NewQueue(w, 1)
+	q.Start()
+	at := time.Now().Add(300 * time.Millisecond)
+	assert.NoError(t, q.QueueAt(mockMessage{Msg: "foo"}, at))
+
+	// nsqd rejects the delay over its max
+	err = q.QueueAt(mockMessage{Msg: "bar"}, time.Now().Add(2*time.Hour))
+	assert.True(t, errors.Is(err, queue.ErrDelayTooLong))
+
+	select {
+	case now := <-received:
+		assert.False(t, now.Before(at.Add(-50*time.Millisecond)))
+	case <-time.After(5 * time.Second):
+		t.Fatal("message is not received")
+	}
+
+	q.Shutdown()
+	q.Wait()
+	assert.Equal(t, errShutdown, w.QueueAt(mockMessage{Msg: "foo"}, at))
+}
+
+func TestNSQWorkerConnectError(t *testing.T) {
+	w, err := NewWorker(WithAddr("127.0.0.1:1"))
+	assert.NoError(t, err)
+	assert.Error(t, w.BeforeRun())
+}
@@ -0,0 +1,124 @@
+package queue
+
+import "sync"
+
+// Priority is the lane of a queued message
+type Priority string
+
+const (
+	// PriorityHigh is dispatched first, e.g. a manual refresh
+	PriorityHigh Priority = "high"
+	// PriorityNormal is the default lane
+	PriorityNormal Priority = "normal"
+	// PriorityLow is dispatched last, e.g. bulk backfills
+	PriorityLow Priority = "low"
+)
+
+// Priorities lists all lanes, highest first
+var Priorities = []Priority{PriorityHigh, PriorityNormal, PriorityLow}
+
+// DefaultWeights is how many messages of each lane are dispatched in a
+// round when every lane has messages
+var DefaultWeights = map[Priority]int{
+	PriorityHigh:   6,
+	PriorityNormal: 3,
+	PriorityLow:    1,
+}
+
+// IsValid report whether the priority is known
+func (p Priority) IsValid() bool {
+	return p.Index() >= 0
+}
+
+// Index returns the position of the lane in Priorities, -1 when unknown
+func (p Priority) Index() int {
+	for i, priority := range Priorities {
+		if p == priority {
+			return i
+		}
+	}
+	return -1
+}
+
+// Prioritized is a queued message with a priority, the others are normal
+type Prioritized interface {
+	QueuePriority() Priority
+}
+
+// PriorityOf returns the lane of the message
+func PriorityOf(msg QueuedMessage) Priority {
+	if v, ok := msg.(Prioritized); ok && v.QueuePriority().IsValid() {
+		return v.QueuePriority()
+	}
+	return PriorityNormal
+}
+
+// Lane is the capacity and the usage of a priority
+type Lane struct {
+	Priority Priority `json:"priority"`
+	Weight   int      `json:"weight"`
+	Capacity int      `json:"capacity"`
+	Usage    int      `json:"usage"`
+}
+
+// LaneWorker is a worker which reports the usage of every lane
+type LaneWorker interface {
+	Lanes() []Lane
+}
+
+// Picker choose the next lane by smooth weighted round robin, so every
+// lane with messages gets its share and the high one isn't waiting behind
+// a long run of low ones.
+type Picker struct {
+	mu      sync.Mutex
+	weights []int
+	current []int
+}
+
+// NewPicker returns a Picker of the weights indexed like Priorities, lanes
+// without weight get 1.
+func NewPicker(weights map[Priority]int) *Picker {
+	p := &Picker{
+		weights: make([]int, len(Priorities)),
+		current: make([]int, len(Priorities)),
+	}
+	for i, priority := range Priorities {
+		p.weights[i] = 1
+		if w := weights[priority]; w > 0 {
+			p.weights[i] = w
+		}
+	}
+	return p
+}
+
+// Weight returns the weight of the lane
+func (p *Picker) Weight(priority Priority) int {
+	if i := priority.Index(); i >= 0 {
+		return p.weights[i]
+	}
+	return 0
+}
+
+// Pick returns the index of the next lane among the ready ones, -1 when
+// none is ready.
+func (p *Picker) Pick(ready func(i int) bool) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	best, total := -1, 0
+	for i, w := range p.weights {
+		if !ready(i) {
+			continue
+		}
+		p.current[i] += w
+		total += w
+		if best < 0 || p.current[i] > p.current[best] {
+			best = i
+		}
+	}
+	if best >= 0 {
+		p.current[best] -= total
+	}
+
+	return best
+}
@@ -1,7 +1,10 @@
 package queue
 
 import (
+	"errors"
 	"runtime"
+	"sync"
+	"time"
 
 	"github.com/natansdj/go_scrape/logx"
 )
@@ -9,26 +12,98 @@ import (
 type (
 	// A Queue is a message queue.
 	Queue struct {
-		workerCount  int
 		routineGroup *routineGroup
-		quit         chan struct{}
+		stopOnce     sync.Once
 		worker       Worker
+		supervisor   *Supervisor
+
+		// cancelFunc stop the running jobs once the release times out,
+		// they're handed to the release func after cancelWait
+		cancelFunc func() []QueuedMessage
+		cancelWait time.Duration
+
+		// mu guards the pool, every running worker has its own quit
+		// channel so the pool can shrink one worker at a time
+		mu          sync.Mutex
+		workerCount int
+		started     bool
+		stopped     bool
+		quits       []chan struct{}
+		nextNum     int
 	}
+
+	// Report is the outcome of a queue release
+	Report struct {
+		// Pending jobs when the release started, running ones included
+		Pending int `json:"pending"`
+		// Finished jobs during the release, counted by the supervisor
+		// wrapping the run func
+		Finished int `json:"finished"`
+		// Failed jobs during the release, the quarantined ones included
+		Failed int `json:"failed"`
+		// Abandoned jobs not finished, the persistent workers keep the
+		// ones not started for the next start
+		Abandoned int `json:"abandoned"`
+		// Drained abandoned jobs handed to the drain func
+		Drained int `json:"drained"`
+		// Cancelled running jobs handed to the drain func once the
+		// timeout passed
+		Cancelled int `json:"cancelled"`
+	}
+)
+
+var (
+	// ErrMaxCapacity is returned by Queue when the worker is full
+	ErrMaxCapacity = errors.New("max capacity reached")
+	// ErrDelayNotSupported is returned by QueueAt when the worker can't delay
+	ErrDelayNotSupported = errors.New("queue worker can't delay jobs")
+	// ErrDelayTooLong is returned by QueueAt when the delay is over the max
+	// of the worker
+	ErrDelayTooLong = errors.New("delay is over the max of the queue worker")
+	// ErrInvalidWorkerNum is returned by Resize when the number is below one
+	ErrInvalidWorkerNum = errors.New("worker number must be at least one")
+	// ErrQueueShutdown is returned by Resize after the shutdown
+	ErrQueueShutdown = errors.New("queue is shut down")
 )
 
+// Option for queue
+type Option func(*Queue)
+
+// WithSupervisor setup the supervisor restarting the crashed workers
+func WithSupervisor(s *Supervisor) Option {
+	return func(q *Queue) {
+		q.supervisor = s
+	}
+}
+
+// WithCancelFunc setup the func cancelling the running jobs when the
+// release times out, it returns the jobs handed to the release func once
+// they stopped or wait passed.
+func WithCancelFunc(fn func() []QueuedMessage, wait time.Duration) Option {
+	return func(q *Queue) {
+		q.cancelFunc = fn
+		q.cancelWait = wait
+	}
+}
+
 // NewQueue returns a Queue.
-func NewQueue(w Worker, workerNum int) *Queue {
+func NewQueue(w Worker, workerNum int, opts ...Option) *Queue {
 	q := &Queue{
 		workerCount:  runtime.NumCPU(),
 		routineGroup: newRoutineGroup(),
-		quit:         make(chan struct{}),
 		worker:       w,
+		supervisor:   NewSupervisor(),
 	}
 
 	if workerNum > 0 {
 		q.workerCount = workerNum
 	}
 
+	for _, opt := range opts {
+		opt(q)
+	}
+	q.supervisor.queue = q
+
 	return q
 }
 
@@ -42,15 +117,91 @@ func (q *Queue) Usage() int {
 	return q.worker.Usage()
 }
 
+// Lanes returns the capacity and the usage of every priority, workers
+// without lanes have a single normal one.
+func (q *Queue) Lanes() []Lane {
+	if w, ok := q.worker.(LaneWorker); ok {
+		return w.Lanes()
+	}
+
+	return []Lane{{
+		Priority: PriorityNormal,
+		Weight:   1,
+		Capacity: q.Capacity(),
+		Usage:    q.Usage(),
+	}}
+}
+
+// Workers returns the number of workers of the pool
+func (q *Queue) Workers() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.workerCount
+}
+
+// Resize grow or shrink the pool to n workers. The retired workers finish
+// their running job before they stop.
+func (q *Queue) Resize(n int) error {
+	if n < 1 {
+		return ErrInvalidWorkerNum
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.stopped {
+		return ErrQueueShutdown
+	}
+
+	if n != q.workerCount {
+		logx.LogAccess.Infof("resize the worker pool from %d to %d", q.workerCount, n)
+	}
+	q.workerCount = n
+	if !q.started {
+		return nil
+	}
+
+	for len(q.quits) < n {
+		q.startWorker()
+	}
+	for len(q.quits) > n {
+		last := len(q.quits) - 1
+		close(q.quits[last])
+		q.quits = q.quits[:last]
+	}
+
+	return nil
+}
+
 // Start to enable all worker
 func (q *Queue) Start() {
-	q.startWorker()
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.started || q.stopped {
+		return
+	}
+	q.started = true
+	for i := 0; i < q.workerCount; i++ {
+		q.startWorker()
+	}
 }
 
 // Shutdown stops all queues.
 func (q *Queue) Shutdown() {
-	q.worker.Shutdown()
-	close(q.quit)
+	q.stopOnce.Do(func() {
+		if err := q.worker.Shutdown(); err != nil {
+			logx.LogError.Error("worker shutdown error: ", err)
+		}
+
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		q.stopped = true
+		for _, quit := range q.quits {
+			close(quit)
+		}
+		q.quits = nil
+	})
 }
 
 // Wait all process
@@ -58,36 +209,157 @@ func (q *Queue) Wait() {
 	q.routineGroup.Wait()
 }
 
+// Release shutdown the queue and wait the jobs up to timeout, zero waits
+// until the workers are done. Once the timeout passed, the running jobs are
+// cancelled by the cancel func of the queue and handed to fn. Then the jobs
+// not started yet, the delayed ones included, are handed to fn if the
+// worker is a Drainer. The jobs are counted when the run func is wrapped by
+// the supervisor of the queue.
+func (q *Queue) Release(timeout time.Duration, fn func(QueuedMessage)) Report {
+	r := Report{Pending: q.Usage()}
+	finished, failed := q.supervisor.counts()
+
+	done := make(chan struct{})
+	go func() {
+		q.Shutdown()
+		q.Wait()
+		close(done)
+	}()
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	// the delayed jobs are drained even when the workers are done
+	select {
+	case <-done:
+	case <-expired:
+		for _, msg := range q.cancel(done) {
+			fn(msg)
+			r.Cancelled++
+		}
+	}
+	if d, ok := q.worker.(Drainer); ok {
+		for _, msg := range d.Drain() {
+			fn(msg)
+			r.Drained++
+		}
+	}
+
+	r.Abandoned = q.Usage() + r.Drained + r.Cancelled
+	nowFinished, nowFailed := q.supervisor.counts()
+	r.Finished = int(nowFinished - finished)
+	r.Failed = int(nowFailed - failed)
+
+	return r
+}
+
+// cancel the running jobs and wait until the workers are done or the
+// cancel wait passed
+func (q *Queue) cancel(done chan struct{}) []QueuedMessage {
+	if q.cancelFunc == nil {
+		return nil
+	}
+
+	msgs := q.cancelFunc()
+	timer := time.NewTimer(q.cancelWait)
+	defer timer.Stop()
+	select {
+	case <-done:
+	case <-timer.C:
+	}
+
+	return msgs
+}
+
 // Queue to queue all job
 func (q *Queue) Queue(job QueuedMessage) error {
 	return q.worker.Queue(job)
 }
 
-func (q *Queue) work(num int) {
+// QueueAt queue the job once at is reached, a past time queues it right
+// away.
+func (q *Queue) QueueAt(job QueuedMessage, at time.Time) error {
+	if !at.After(time.Now()) {
+		return q.Queue(job)
+	}
+
+	w, ok := q.worker.(Delayer)
+	if !ok {
+		return ErrDelayNotSupported
+	}
+	return w.QueueAt(job, at)
+}
+
+// QueueAfter queue the job once the delay is elapsed
+func (q *Queue) QueueAfter(job QueuedMessage, delay time.Duration) error {
+	return q.QueueAt(job, time.Now().Add(delay))
+}
+
+// work run the worker until quit, the crashed worker or the one which
+// can't start is restarted with the backoff of the supervisor
+func (q *Queue) work(num int, quit chan struct{}) {
+	for failures := 0; ; failures++ {
+		started := time.Now()
+		if q.run(num, quit) {
+			return
+		}
+
+		// the worker which ran for a while is not in a crash loop
+		if time.Since(started) > q.supervisor.maxBackoff {
+			failures = 0
+		}
+
+		wait := q.supervisor.restartDelay(failures)
+		logx.LogError.Errorf("restart the worker num %d in %s", num, wait)
+		select {
+		case <-quit:
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// run the worker once, it reports whether the worker stopped without
+// failure
+func (q *Queue) run(num int, quit chan struct{}) (ok bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			q.supervisor.crashed(num, NewPanicError(r))
+			ok = false
+		}
+	}()
+
 	if err := q.worker.BeforeRun(); err != nil {
-		logx.LogError.Fatal(err)
+		logx.LogError.Errorf("worker num %d can't start: %v", num, err)
+		return false
 	}
-	q.routineGroup.Run(func() {
-		// to handle panic cases from inside the worker
-		// in such case, we start a new goroutine
-		defer func() {
-			if err := recover(); err != nil {
-				logx.LogError.Error(err)
-				go q.work(num)
-			}
-		}()
-
-		logx.LogAccess.Info("started the worker num ", num)
-		q.worker.Run(q.quit)
-		logx.LogAccess.Info("closed the worker num ", num)
-	})
+
+	logx.LogAccess.Info("started the worker num ", num)
+	if err := q.worker.Run(quit); err != nil {
+		logx.LogError.Errorf("worker num %d stopped: %v", num, err)
+	}
+	logx.LogAccess.Info("closed the worker num ", num)
+
 	if err := q.worker.AfterRun(); err != nil {
-		logx.LogError.Fatal(err)
+		logx.LogError.Errorf("worker num %d can't stop: %v", num, err)
 	}
+
+	return true
 }
 
+// startWorker add a worker to the routine group before it runs, so Wait
+// doesn't return before it's started. The caller holds the lock.
 func (q *Queue) startWorker() {
-	for i := 0; i < q.workerCount; i++ {
-		go q.work(i)
-	}
+	num := q.nextNum
+	quit := make(chan struct{})
+	q.nextNum++
+	q.quits = append(q.quits, quit)
+
+	q.routineGroup.Run(func() {
+		q.work(num, quit)
+	})
 }
@@ -0,0 +1,663 @@
+package redisstream
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/natansdj/go_scrape/config"
+	"github.com/natansdj/go_scrape/go_scrape"
+	"github.com/natansdj/go_scrape/logx"
+	"github.com/natansdj/go_scrape/queue"
+
+	"github.com/go-redis/redis/v7"
+)
+
+var (
+	_ queue.Worker     = (*Worker)(nil)
+	_ queue.LaneWorker = (*Worker)(nil)
+	_ queue.Delayer    = (*Worker)(nil)
+)
+
+// Option for queue system
+type Option func(*Worker)
+
+var (
+	errShutdown    = errors.New("redis stream worker is shut down")
+	errMaxCapacity = queue.ErrMaxCapacity
+	errMaxDeliver  = errors.New("max deliver reached")
+)
+
+const (
+	// payloadField is the stream entry field holding the job
+	payloadField = "payload"
+	// fetchWait is how long a single XREADGROUP blocks, the due delayed
+	// entries are moved to their stream in between
+	fetchWait = time.Second
+	// promoteCount is the max number of due entries moved at once
+	promoteCount = 100
+)
+
+// promoteScript move the delayed member to the stream at once, the member
+// is removed after the XADD so the job is kept when the XADD fails. It
+// returns 0 when another instance already moved it.
+var promoteScript = redis.NewScript(`
+if not redis.call("ZSCORE", KEYS[1], ARGV[1]) then
+	return 0
+end
+redis.call("XADD", KEYS[2], "*", ARGV[2], ARGV[3])
+redis.call("ZREM", KEYS[1], ARGV[1])
+return 1
+`)
+
+// delivery is a stream entry with the number of times it was delivered
+type delivery struct {
+	lane  int
+	msg   redis.XMessage
+	count int64
+}
+
+// Worker for Redis Streams, the jobs are added to a stream and read by a
+// consumer group into an internal channel read by every Run. An entry is
+// acked and deleted when the run func succeeds, a failed entry is left
+// pending and claimed again once it's idle for claimIdle, until it reaches
+// maxDeliver and is handed to the fail func.
+//
+// Every priority has its own stream, the normal one is the stream itself
+// and the others get the priority as suffix. Run pick the lanes by weight.
+//
+// The delayed entries are kept in a sorted set per lane scored by their due
+// time, the fetch loop moves the due ones to the stream of their lane.
+type Worker struct {
+	opts         redis.Options
+	stream       string
+	group        string
+	consumer     string
+	maxLen       int64
+	laneCapacity map[queue.Priority]int64
+	weights      map[queue.Priority]int
+	maxDeliver   int64
+	claimIdle    time.Duration
+
+	client *redis.Client
+	lanes  []chan delivery
+	picker *queue.Picker
+
+	runFunc    func(queue.QueuedMessage) error
+	failFunc   func(queue.QueuedMessage, error)
+	decodeFunc func([]byte) (queue.QueuedMessage, error)
+
+	startOnce sync.Once
+	startErr  error
+	stopOnce  sync.Once
+	stop      chan struct{}
+	fetching  sync.WaitGroup
+	stopped   int32
+	seq       uint64
+}
+
+// WithAddr setup the redis address
+func WithAddr(addr string) Option {
+	return func(w *Worker) {
+		if addr != "" {
+			w.opts.Addr = addr
+		}
+	}
+}
+
+// WithPassword setup the redis password
+func WithPassword(password string) Option {
+	return func(w *Worker) {
+		w.opts.Password = password
+	}
+}
+
+// WithDB setup the redis database
+func WithDB(db int) Option {
+	return func(w *Worker) {
+		w.opts.DB = db
+	}
+}
+
+// WithStream setup the stream key
+func WithStream(stream string) Option {
+	return func(w *Worker) {
+		if stream != "" {
+			w.stream = stream
+		}
+	}
+}
+
+// WithGroup setup the consumer group shared by every instance
+func WithGroup(group string) Option {
+	return func(w *Worker) {
+		if group != "" {
+			w.group = group
+		}
+	}
+}
+
+// WithConsumer setup the consumer name of this instance
+func WithConsumer(consumer string) Option {
+	return func(w *Worker) {
+		if consumer != "" {
+			w.consumer = consumer
+		}
+	}
+}
+
+// WithMaxLen setup the max number of entries in all streams
+func WithMaxLen(num int64) Option {
+	return func(w *Worker) {
+		if num > 0 {
+			w.maxLen = num
+		}
+	}
+}
+
+// WithLaneCapacity setup the max number of entries in the stream of a
+// lane, zero means the lane is only bounded by the max length
+func WithLaneCapacity(priority queue.Priority, num int64) Option {
+	return func(w *Worker) {
+		w.laneCapacity[priority] = num
+	}
+}
+
+// WithWeights setup how many entries of each lane are dispatched in a round
+func WithWeights(weights map[queue.Priority]int) Option {
+	return func(w *Worker) {
+		for priority, weight := range weights {
+			if weight > 0 {
+				w.weights[priority] = weight
+			}
+		}
+	}
+}
+
+// WithMaxDeliver setup how many times an entry is delivered before it is
+// handed to the fail func
+func WithMaxDeliver(num int64) Option {
+	return func(w *Worker) {
+		if num > 0 {
+			w.maxDeliver = num
+		}
+	}
+}
+
+// WithClaimIdle setup how long an entry stays pending before another
+// consumer claims it
+func WithClaimIdle(d time.Duration) Option {
+	return func(w *Worker) {
+		if d > 0 {
+			w.claimIdle = d
+		}
+	}
+}
+
+// WithRunFunc setup the run func of queue
+func WithRunFunc(fn func(queue.QueuedMessage) error) Option {
+	return func(w *Worker) {
+		w.runFunc = fn
+	}
+}
+
+// WithFailFunc setup the func called with the error of run func once the
+// entry reached the max deliver
+func WithFailFunc(fn func(queue.QueuedMessage, error)) Option {
+	return func(w *Worker) {
+		w.failFunc = fn
+	}
+}
+
+// WithDecodeFunc setup the func which turn the entry payload back into
+// the queued message
+func WithDecodeFunc(fn func([]byte) (queue.QueuedMessage, error)) Option {
+	return func(w *Worker) {
+		w.decodeFunc = fn
+	}
+}
+
+// NewWorker for struc
+func NewWorker(opts ...Option) *Worker {
+	consumer, _ := os.Hostname()
+	if consumer == "" {
+		consumer = "go_scrape"
+	}
+
+	w := &Worker{
+		opts:         redis.Options{Addr: "localhost:6379"},
+		stream:       "go_scrape-queue",
+		group:        "go_scrape",
+		consumer:     consumer,
+		maxLen:       8192,
+		laneCapacity: map[queue.Priority]int64{},
+		weights:      map[queue.Priority]int{},
+		maxDeliver:   3,
+		claimIdle:    5 * time.Minute,
+		stop:         make(chan struct{}),
+		runFunc:      go_scrape.Run,
+		failFunc:     go_scrape.DeadLetter,
+		decodeFunc: func(b []byte) (queue.QueuedMessage, error) {
+			return go_scrape.Decode(config.ConfYaml{}, b)
+		},
+	}
+	for priority, weight := range queue.DefaultWeights {
+		w.weights[priority] = weight
+	}
+
+	// Loop through each option
+	for _, opt := range opts {
+		// Call the option giving the instantiated
+		opt(w)
+	}
+
+	w.client = redis.NewClient(&w.opts)
+	w.picker = queue.NewPicker(w.weights)
+	for range queue.Priorities {
+		w.lanes = append(w.lanes, make(chan delivery, 1))
+	}
+
+	return w
+}
+
+// laneStream returns the stream of the priority
+func (s *Worker) laneStream(priority queue.Priority) string {
+	if priority == queue.PriorityNormal {
+		return s.stream
+	}
+	return s.stream + "-" + string(priority)
+}
+
+// laneDelayed returns the sorted set of the delayed entries of the priority
+func (s *Worker) laneDelayed(priority queue.Priority) string {
+	return s.laneStream(priority) + "-delayed"
+}
+
+// laneCap returns the max length of the lane, bounded by the max length
+func (s *Worker) laneCap(priority queue.Priority) int64 {
+	if num := s.laneCapacity[priority]; num > 0 && num < s.maxLen {
+		return num
+	}
+	return s.maxLen
+}
+
+// BeforeRun create the consumer groups and start reading once for all
+// workers
+func (s *Worker) BeforeRun() error {
+	s.startOnce.Do(func() {
+		for _, priority := range queue.Priorities {
+			err := s.client.XGroupCreateMkStream(s.laneStream(priority), s.group, "0").Err()
+			if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+				s.startErr = err
+				return
+			}
+		}
+
+		s.fetching.Add(1)
+		go s.fetch()
+	})
+	return s.startErr
+}
+
+// AfterRun run script after start worker
+func (s *Worker) AfterRun() error {
+	return nil
+}
+
+// fetch claim the stuck entries and read the new ones into the internal
+// channel until the worker is shut down.
+func (s *Worker) fetch() {
+	defer s.fetching.Done()
+
+	// the streams of every lane followed by their ">" ids
+	streams := make([]string, 0, len(queue.Priorities)*2)
+	for _, priority := range queue.Priorities {
+		streams = append(streams, s.laneStream(priority))
+	}
+	for range queue.Priorities {
+		streams = append(streams, ">")
+	}
+
+	var lastClaim time.Time
+	for {
+		select {
+		case <-s.stop:
+			return
+		default:
+		}
+
+		s.promote()
+
+		if time.Since(lastClaim) >= s.claimIdle/2 {
+			lastClaim = time.Now()
+			for i, priority := range queue.Priorities {
+				if !s.claim(i, s.laneStream(priority)) {
+					return
+				}
+			}
+		}
+
+		result, err := s.client.XReadGroup(&redis.XReadGroupArgs{
+			Group:    s.group,
+			Consumer: s.consumer,
+			Streams:  streams,
+			Count:    1,
+			Block:    fetchWait,
+		}).Result()
+		if err != nil {
+			if err != redis.Nil && atomic.LoadInt32(&s.stopped) == 0 {
+				logx.LogError.Errorf("can't read redis stream %s: %v", s.stream, err)
+				select {
+				case <-s.stop:
+					return
+				case <-time.After(fetchWait):
+				}
+			}
+			continue
+		}
+
+		for _, stream := range result {
+			lane := s.laneOf(stream.Stream)
+			for _, msg := range stream.Messages {
+				if !s.dispatch(delivery{lane: lane, msg: msg, count: 1}) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// promote move the due delayed entries to the stream of their lane, the
+// entry belongs to the instance which removed it from the sorted set.
+func (s *Worker) promote() {
+	max := strconv.FormatInt(time.Now().UnixNano()/int64(time.Millisecond), 10)
+	for _, priority := range queue.Priorities {
+		key := s.laneDelayed(priority)
+		members, err := s.client.ZRangeByScore(key, &redis.ZRangeBy{
+			Min:   "-inf",
+			Max:   max,
+			Count: promoteCount,
+		}).Result()
+		if err != nil {
+			if atomic.LoadInt32(&s.stopped) == 0 {
+				logx.LogError.Errorf("can't read delayed entries of redis stream %s: %v", s.laneStream(priority), err)
+			}
+			continue
+		}
+
+		for _, member := range members {
+			// the member is the unique sequence followed by the payload
+			payload := member[strings.Index(member, ":")+1:]
+			err := promoteScript.Run(s.client, []string{key, s.laneStream(priority)}, member, payloadField, payload).Err()
+			if err != nil && err != redis.Nil {
+				logx.LogError.Errorf("can't add due entry to redis stream %s: %v", s.laneStream(priority), err)
+			}
+		}
+	}
+}
+
+// laneOf returns the lane index of the stream
+func (s *Worker) laneOf(stream string) int {
+	for i, priority := range queue.Priorities {
+		if s.laneStream(priority) == stream {
+			return i
+		}
+	}
+	return queue.PriorityNormal.Index()
+}
+
+// claim take over the entries of the lane stream pending for longer than
+// claimIdle, it returns false when the worker is shut down.
+func (s *Worker) claim(lane int, stream string) bool {
+	pending, err := s.client.XPendingExt(&redis.XPendingExtArgs{
+		Stream: stream,
+		Group:  s.group,
+		Start:  "-",
+		End:    "+",
+		Count:  100,
+	}).Result()
+	if err != nil {
+		if err != redis.Nil {
+			logx.LogError.Errorf("can't list pending entries of redis stream %s: %v", stream, err)
+		}
+		return true
+	}
+
+	for _, p := range pending {
+		if p.Idle < s.claimIdle {
+			continue
+		}
+
+		msgs, err := s.client.XClaim(&redis.XClaimArgs{
+			Stream:   stream,
+			Group:    s.group,
+			Consumer: s.consumer,
+			MinIdle:  s.claimIdle,
+			Messages: []string{p.ID},
+		}).Result()
+		if err != nil {
+			logx.LogError.Errorf("can't claim entry %s of redis stream %s: %v", p.ID, stream, err)
+			continue
+		}
+
+		for _, msg := range msgs {
+			if !s.dispatch(delivery{lane: lane, msg: msg, count: p.RetryCount + 1}) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+func (s *Worker) dispatch(d delivery) bool {
+	select {
+	case s.lanes[d.lane] <- d:
+		return true
+	case <-s.stop:
+		return false
+	}
+}
+
+// Run handle the entries until the queue is shut down
+func (s *Worker) Run(quit chan struct{}) error {
+	for {
+		d, ok := s.next(quit)
+		if !ok {
+			return nil
+		}
+		s.handle(d)
+	}
+}
+
+// next returns the entry of the lane picked by weight, it blocks until a
+// lane has one and returns false when the queue is shut down.
+func (s *Worker) next(quit chan struct{}) (delivery, bool) {
+	if i := s.picker.Pick(func(i int) bool { return len(s.lanes[i]) > 0 }); i >= 0 {
+		select {
+		case d := <-s.lanes[i]:
+			return d, true
+		default:
+			// taken by another worker
+		}
+	}
+
+	select {
+	case <-quit:
+		return delivery{}, false
+	case d := <-s.lanes[0]:
+		return d, true
+	case d := <-s.lanes[1]:
+		return d, true
+	case d := <-s.lanes[2]:
+		return d, true
+	}
+}
+
+func (s *Worker) handle(d delivery) {
+	stream := s.laneStream(queue.Priorities[d.lane])
+
+	payload, _ := d.msg.Values[payloadField].(string)
+	job, err := s.decodeFunc([]byte(payload))
+	if err != nil {
+		logx.LogError.Errorf("can't decode entry %s of redis stream %s: %v", d.msg.ID, stream, err)
+		s.failFunc(queue.RawMessage(payload), queue.DecodeError(err))
+		s.ack(stream, d.msg.ID)
+		return
+	}
+
+	// the job crashed the workers on every delivery
+	if d.count > s.maxDeliver {
+		s.failFunc(job, fmt.Errorf("%w: %d", errMaxDeliver, d.count-1))
+		s.ack(stream, d.msg.ID)
+		return
+	}
+
+	// the payload is the one first added, carry on the attempt count
+	if v, ok := job.(*go_scrape.ScrapeJob); ok && int64(v.Attempt) < d.count-1 {
+		v.Attempt = int(d.count - 1)
+	}
+
+	if err := s.runFunc(job); err != nil {
+		if d.count < s.maxDeliver {
+			logx.LogError.Errorf("redis stream entry delivery %d/%d failed, claimed again after %s: %v", d.count, s.maxDeliver, s.claimIdle, err)
+			return
+		}
+
+		s.failFunc(job, err)
+	}
+
+	s.ack(stream, d.msg.ID)
+}
+
+// ack remove the entry from the pending list and the stream
+func (s *Worker) ack(stream, id string) {
+	if err := s.client.XAck(stream, s.group, id).Err(); err != nil {
+		logx.LogError.Errorf("can't ack entry %s of redis stream %s: %v", id, stream, err)
+		return
+	}
+	if err := s.client.XDel(stream, id).Err(); err != nil {
+		logx.LogError.Errorf("can't delete entry %s of redis stream %s: %v", id, stream, err)
+	}
+}
+
+// Shutdown stop reading and close the connection. The entries read but
+// not finished stay pending and are claimed again after claimIdle.
+func (s *Worker) Shutdown() error {
+	s.stopOnce.Do(func() {
+		atomic.StoreInt32(&s.stopped, 1)
+		close(s.stop)
+		s.fetching.Wait()
+		if err := s.client.Close(); err != nil {
+			logx.LogError.Errorf("can't close redis stream connection: %v", err)
+		}
+	})
+	return nil
+}
+
+// Capacity is the max length of all streams
+func (s *Worker) Capacity() int {
+	return int(s.maxLen)
+}
+
+// Usage is the length of all streams, entries waiting to be read and the
+// pending ones not acked yet, and the delayed entries.
+func (s *Worker) Usage() int {
+	var count int
+	for _, lane := range s.Lanes() {
+		count += lane.Usage
+	}
+	return count
+}
+
+// Lanes returns the length of the stream and the delayed entries of every
+// priority
+func (s *Worker) Lanes() []queue.Lane {
+	lanes := make([]queue.Lane, len(queue.Priorities))
+	for i, priority := range queue.Priorities {
+		lanes[i] = queue.Lane{
+			Priority: priority,
+			Weight:   s.picker.Weight(priority),
+			Capacity: int(s.laneCap(priority)),
+		}
+		if atomic.LoadInt32(&s.stopped) == 1 {
+			continue
+		}
+		if count, err := s.laneLen(priority); err == nil {
+			lanes[i].Usage = int(count)
+		}
+	}
+	return lanes
+}
+
+// laneLen returns the entries of the stream and the delayed ones of the
+// priority
+func (s *Worker) laneLen(priority queue.Priority) (int64, error) {
+	count, err := s.client.XLen(s.laneStream(priority)).Result()
+	if err != nil {
+		return 0, err
+	}
+	delayed, err := s.client.ZCard(s.laneDelayed(priority)).Result()
+	if err != nil {
+		return 0, err
+	}
+	return count + delayed, nil
+}
+
+// reserve check that both the streams and the lane of the priority have
+// room
+func (s *Worker) reserve(priority queue.Priority) error {
+	if atomic.LoadInt32(&s.stopped) == 1 {
+		return errShutdown
+	}
+
+	var total, count int64
+	for _, p := range queue.Priorities {
+		n, err := s.laneLen(p)
+		if err != nil {
+			return err
+		}
+		total += n
+		if p == priority {
+			count = n
+		}
+	}
+	if total >= s.maxLen || count >= s.laneCap(priority) {
+		return errMaxCapacity
+	}
+	return nil
+}
+
+// Queue add the message to the stream of its priority
+func (s *Worker) Queue(job queue.QueuedMessage) error {
+	priority := queue.PriorityOf(job)
+	if err := s.reserve(priority); err != nil {
+		return err
+	}
+
+	return s.client.XAdd(&redis.XAddArgs{
+		Stream: s.laneStream(priority),
+		Values: map[string]interface{}{payloadField: job.Bytes()},
+	}).Err()
+}
+
+// QueueAt add the message to the delayed entries of its priority, it's
+// added to the stream within a second after at.
+func (s *Worker) QueueAt(job queue.QueuedMessage, at time.Time) error {
+	priority := queue.PriorityOf(job)
+	if err := s.reserve(priority); err != nil {
+		return err
+	}
+
+	member := fmt.Sprintf("%d-%d:%s", time.Now().UnixNano(), atomic.AddUint64(&s.seq, 1), job.Bytes())
+	return s.client.ZAdd(s.laneDelayed(priority), &redis.Z{
+		// rounded up, so the entry isn't moved before at
+		Score:  float64((at.UnixNano() + int64(time.Millisecond) - 1) / int64(time.Millisecond)),
+		Member: member,
+	}).Err()
+}
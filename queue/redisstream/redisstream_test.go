@@ -0,0 +1,233 @@
+package redisstream
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/natansdj/go_scrape/queue"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+type mockMessage struct {
+	Msg string `json:"msg"`
+}
+
+func (m mockMessage) Bytes() []byte {
+	return []byte(`{"msg":"` + m.Msg + `"}`)
+}
+
+type priorityMessage struct {
+	mockMessage
+	priority queue.Priority
+}
+
+func (m priorityMessage) QueuePriority() queue.Priority {
+	return m.priority
+}
+
+func decodeMock(b []byte) (queue.QueuedMessage, error) {
+	return mockMessage{Msg: string(b)}, nil
+}
+
+func TestRedisStreamWorker(t *testing.T) {
+	mr, err := miniredis.Run()
+	assert.NoError(t, err)
+	defer mr.Close()
+
+	received := make(chan string, 2)
+	w := NewWorker(
+		WithAddr(mr.Addr()),
+		WithMaxLen(2),
+		WithDecodeFunc(decodeMock),
+		WithRunFunc(func(msg queue.QueuedMessage) error {
+			received <- msg.(mockMessage).Msg
+			return nil
+		}),
+	)
+	assert.Equal(t, 2, w.Capacity())
+
+	q := queue.NewQueue(w, 2)
+	assert.NoError(t, q.Queue(mockMessage{Msg: "foo"}))
+	assert.NoError(t, q.Queue(mockMessage{Msg: "bar"}))
+	assert.Equal(t, 2, w.Usage())
+	assert.Equal(t, errMaxCapacity, q.Queue(mockMessage{Msg: "baz"}))
+	q.Start()
+
+	for i := 0; i < 2; i++ {
+		select {
+		case msg := <-received:
+			assert.Contains(t, []string{`{"msg":"foo"}`, `{"msg":"bar"}`}, msg)
+		case <-time.After(5 * time.Second):
+			t.Fatal("message is not received")
+		}
+	}
+
+	assert.Eventually(t, func() bool {
+		return w.Usage() == 0
+	}, time.Second, 10*time.Millisecond)
+
+	q.Shutdown()
+	q.Wait()
+	assert.Equal(t, errShutdown, w.Queue(mockMessage{Msg: "baz"}))
+	// shutdown twice is safe
+	assert.NoError(t, w.Shutdown())
+}
+
+func TestRedisStreamWorkerClaim(t *testing.T) {
+	mr, err := miniredis.Run()
+	assert.NoError(t, err)
+	defer mr.Close()
+
+	runs := make(chan struct{}, 3)
+	failed := make(chan error, 1)
+	w := NewWorker(
+		WithAddr(mr.Addr()),
+		WithMaxDeliver(3),
+		WithClaimIdle(50*time.Millisecond),
+		WithDecodeFunc(decodeMock),
+		WithRunFunc(func(msg queue.QueuedMessage) error {
+			runs <- struct{}{}
+			return errors.New("upstream down")
+		}),
+		WithFailFunc(func(msg queue.QueuedMessage, err error) {
+			failed <- err
+		}),
+	)
+
+	q := queue.NewQueue(w, 1)
+	q.Start()
+	assert.NoError(t, q.Queue(mockMessage{Msg: "foo"}))
+
+	select {
+	case err := <-failed:
+		assert.EqualError(t, err, "upstream down")
+	case <-time.After(10 * time.Second):
+		t.Fatal("fail func is not called")
+	}
+	assert.Len(t, runs, 3)
+	assert.Eventually(t, func() bool {
+		return w.Usage() == 0
+	}, time.Second, 10*time.Millisecond)
+
+	q.Shutdown()
+	q.Wait()
+}
+
+func TestRedisStreamWorkerPriority(t *testing.T) {
+	mr, err := miniredis.Run()
+	assert.NoError(t, err)
+	defer mr.Close()
+
+	received := make(chan string, 3)
+	w := NewWorker(
+		WithAddr(mr.Addr()),
+		WithLaneCapacity(queue.PriorityLow, 1),
+		WithDecodeFunc(decodeMock),
+		WithRunFunc(func(msg queue.QueuedMessage) error {
+			received <- msg.(mockMessage).Msg
+			return nil
+		}),
+	)
+
+	q := queue.NewQueue(w, 1)
+	for _, priority := range queue.Priorities {
+		assert.NoError(t, q.Queue(priorityMessage{
+			mockMessage: mockMessage{Msg: string(priority)},
+			priority:    priority,
+		}))
+	}
+	assert.Equal(t, errMaxCapacity, q.Queue(priorityMessage{priority: queue.PriorityLow}))
+	assert.True(t, mr.Exists("go_scrape-queue-high"))
+
+	lanes := w.Lanes()
+	assert.Len(t, lanes, 3)
+	assert.Equal(t, 1, lanes[2].Capacity)
+	assert.Equal(t, 1, lanes[2].Usage)
+	q.Start()
+
+	msgs := []string{}
+	for i := 0; i < 3; i++ {
+		select {
+		case msg := <-received:
+			msgs = append(msgs, msg)
+		case <-time.After(5 * time.Second):
+			t.Fatal("message is not received")
+		}
+	}
+	assert.ElementsMatch(t, []string{`{"msg":"high"}`, `{"msg":"normal"}`, `{"msg":"low"}`}, msgs)
+
+	q.Shutdown()
+	q.Wait()
+}
+
+func TestRedisStreamWorkerQueueAt(t *testing.T) {
+	mr, err := miniredis.Run()
+	assert.NoError(t, err)
+	defer mr.Close()
+
+	received := make(chan string, 1)
+	w := NewWorker(
+		WithAddr(mr.Addr()),
+		WithMaxLen(1),
+		WithDecodeFunc(decodeMock),
+		WithRunFunc(func(msg queue.QueuedMessage) error {
+			received <- msg.(mockMessage).Msg
+			return nil
+		}),
+	)
+
+	q := queue.NewQueue(w, 1)
+	at := time.Now().Add(300 * time.Millisecond)
+	assert.NoError(t, q.QueueAt(mockMessage{Msg: "foo"}, at))
+	// the delayed entries count in the capacity
+	assert.Equal(t, 1, w.Usage())
+	assert.Equal(t, errMaxCapacity, q.QueueAt(mockMessage{Msg: "bar"}, at))
+	q.Start()
+
+	select {
+	case msg := <-received:
+		assert.Equal(t, `{"msg":"foo"}`, msg)
+		assert.False(t, time.Now().Before(at))
+	case <-time.After(5 * time.Second):
+		t.Fatal("message is not received")
+	}
+
+	q.Shutdown()
+	q.Wait()
+	assert.Equal(t, errShutdown, w.QueueAt(mockMessage{Msg: "foo"}, at))
+}
+
+func TestRedisStreamWorkerPromoteFailure(t *testing.T) {
+	mr, err := miniredis.Run()
+	assert.NoError(t, err)
+	defer mr.Close()
+
+	w := NewWorker(WithAddr(mr.Addr()), WithDecodeFunc(decodeMock))
+	assert.NoError(t, w.QueueAt(mockMessage{Msg: "foo"}, time.Now().Add(10*time.Millisecond)))
+	time.Sleep(20 * time.Millisecond)
+
+	// the failed XADD keeps the delayed entry
+	delayed := w.laneDelayed(queue.PriorityNormal)
+	stream := w.laneStream(queue.PriorityNormal)
+	assert.NoError(t, mr.Set(stream, "broken"))
+	w.promote()
+	members, err := mr.ZMembers(delayed)
+	assert.NoError(t, err)
+	assert.Len(t, members, 1)
+
+	mr.Del(stream)
+	w.promote()
+	assert.False(t, mr.Exists(delayed))
+	entries, err := mr.Stream(stream)
+	assert.NoError(t, err)
+	assert.Len(t, entries, 1)
+}
+
+func TestRedisStreamWorkerConnectError(t *testing.T) {
+	w := NewWorker(WithAddr("127.0.0.1:1"))
+	assert.Error(t, w.BeforeRun())
+	assert.Error(t, w.Queue(mockMessage{Msg: "foo"}))
+}
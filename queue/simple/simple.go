@@ -1,8 +1,12 @@
 package simple
 
 import (
+	"container/heap"
 	"errors"
 	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/natansdj/go_scrape/go_scrape"
 	"github.com/natansdj/go_scrape/queue"
@@ -13,12 +17,63 @@ var _ queue.Worker = (*Worker)(nil)
 // Option for queue system
 type Option func(*Worker)
 
-var errMaxCapacity = errors.New("max capacity reached")
+var (
+	errMaxCapacity = queue.ErrMaxCapacity
+	errShutdown    = errors.New("simple worker is shut down")
+)
+
+var (
+	_ queue.Drainer    = (*Worker)(nil)
+	_ queue.LaneWorker = (*Worker)(nil)
+	_ queue.Delayer    = (*Worker)(nil)
+)
+
+// delayedJob is a job held until it's due
+type delayedJob struct {
+	at   time.Time
+	lane int
+	job  queue.QueuedMessage
+}
+
+// delayHeap orders the delayed jobs by due time
+type delayHeap []*delayedJob
 
-// Worker for simple queue using channel
+func (h delayHeap) Len() int            { return len(h) }
+func (h delayHeap) Less(i, j int) bool  { return h[i].at.Before(h[j].at) }
+func (h delayHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *delayHeap) Push(x interface{}) { *h = append(*h, x.(*delayedJob)) }
+
+func (h *delayHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	*h = old[:n-1]
+	return item
+}
+
+// Worker for simple queue with one lane per priority, the lanes are
+// dispatched by weighted round robin. The delayed jobs are held in a heap
+// and moved to their lane by a single timer once they are due, they count
+// in the capacity of their lane.
 type Worker struct {
-	queueNotification chan queue.QueuedMessage
-	runFunc           func(queue.QueuedMessage) error
+	capacity     int
+	laneCapacity map[queue.Priority]int
+	weights      map[queue.Priority]int
+	picker       *queue.Picker
+	runFunc      func(queue.QueuedMessage) error
+	failFunc     func(queue.QueuedMessage, error)
+
+	// mu guards the lanes and stopped, so no job is sent to the closed
+	// ready channel
+	mu      sync.Mutex
+	lanes   [][]queue.QueuedMessage
+	ready   chan struct{}
+	stopped bool
+	busy    []int64
+	delayed delayHeap
+	waiting []int
+	timer   *time.Timer
 }
 
 // BeforeRun run script before start worker
@@ -31,44 +86,264 @@ func (s *Worker) AfterRun() error {
 	return nil
 }
 
-// Run start the worker
-func (s *Worker) Run(_ chan struct{}) error {
-	for notification := range s.queueNotification {
-		s.runFunc(notification)
+// Run start the worker, every token of the ready channel is a queued job.
+// The worker retired by quit returns right away, after the shutdown it
+// keeps running the queued jobs until there is none.
+func (s *Worker) Run(quit chan struct{}) error {
+	for {
+		select {
+		case <-quit:
+			if !s.isStopped() {
+				return nil
+			}
+			quit = nil
+		case _, ok := <-s.ready:
+			if !ok {
+				return nil
+			}
+			if i, notification := s.next(); notification != nil {
+				s.handle(i, notification)
+			}
+		}
+	}
+}
+
+func (s *Worker) isStopped() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stopped
+}
+
+// next take the job of the lane picked by weight
+func (s *Worker) next() (int, queue.QueuedMessage) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := s.picker.Pick(func(i int) bool {
+		return len(s.lanes[i]) > 0
+	})
+	if i < 0 {
+		return i, nil
+	}
+
+	msg := s.lanes[i][0]
+	s.lanes[i][0] = nil
+	s.lanes[i] = s.lanes[i][1:]
+
+	return i, msg
+}
+
+func (s *Worker) handle(i int, notification queue.QueuedMessage) {
+	atomic.AddInt64(&s.busy[i], 1)
+	defer atomic.AddInt64(&s.busy[i], -1)
+
+	if err := s.runFunc(notification); err != nil {
+		s.failFunc(notification, err)
 	}
-	return nil
 }
 
-// Shutdown worker
+// Shutdown worker, the queued jobs are still run
 func (s *Worker) Shutdown() error {
-	close(s.queueNotification)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.stopped {
+		s.stopped = true
+		close(s.ready)
+		if s.timer != nil {
+			s.timer.Stop()
+		}
+	}
 	return nil
 }
 
-// Capacity for channel
+// Drain returns the queued jobs not started yet, highest priority first,
+// then the delayed ones by due time, and remove them from the worker
+func (s *Worker) Drain() []queue.QueuedMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var msgs []queue.QueuedMessage
+	for i := range s.lanes {
+		msgs = append(msgs, s.lanes[i]...)
+		s.lanes[i] = nil
+	}
+	for s.delayed.Len() > 0 {
+		d := heap.Pop(&s.delayed).(*delayedJob)
+		s.waiting[d.lane]--
+		msgs = append(msgs, d.job)
+	}
+	return msgs
+}
+
+// Capacity for all lanes
 func (s *Worker) Capacity() int {
-	return cap(s.queueNotification)
+	return s.capacity
 }
 
-// Usage for count of channel usage
+// Usage for count of queued, delayed and running jobs
 func (s *Worker) Usage() int {
-	return len(s.queueNotification)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.queued() + s.running()
+}
+
+// Lanes returns the capacity and the usage of every priority
+func (s *Worker) Lanes() []queue.Lane {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	lanes := make([]queue.Lane, len(queue.Priorities))
+	for i, priority := range queue.Priorities {
+		lanes[i] = queue.Lane{
+			Priority: priority,
+			Weight:   s.picker.Weight(priority),
+			Capacity: s.laneCap(priority),
+			Usage:    s.laneQueued(i) + int(atomic.LoadInt64(&s.busy[i])),
+		}
+	}
+	return lanes
+}
+
+func (s *Worker) queued() int {
+	var count int
+	for i := range s.lanes {
+		count += s.laneQueued(i)
+	}
+	return count
+}
+
+// laneQueued returns the queued and delayed jobs of the lane
+func (s *Worker) laneQueued(i int) int {
+	return len(s.lanes[i]) + s.waiting[i]
 }
 
-// Queue send notification to queue
+func (s *Worker) running() int {
+	var count int
+	for i := range s.busy {
+		count += int(atomic.LoadInt64(&s.busy[i]))
+	}
+	return count
+}
+
+// laneCap returns the capacity of the lane, bounded by the worker capacity
+func (s *Worker) laneCap(priority queue.Priority) int {
+	if num := s.laneCapacity[priority]; num > 0 && num < s.capacity {
+		return num
+	}
+	return s.capacity
+}
+
+// Queue send notification to the lane of its priority
 func (s *Worker) Queue(job queue.QueuedMessage) error {
-	select {
-	case s.queueNotification <- job:
-		return nil
-	default:
-		return errMaxCapacity
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.stopped {
+		return errShutdown
+	}
+
+	i, err := s.reserve(job)
+	if err != nil {
+		return err
+	}
+
+	s.lanes[i] = append(s.lanes[i], job)
+	s.ready <- struct{}{}
+
+	return nil
+}
+
+// QueueAt hold the job until at then send it to the lane of its priority
+func (s *Worker) QueueAt(job queue.QueuedMessage, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.stopped {
+		return errShutdown
+	}
+
+	i, err := s.reserve(job)
+	if err != nil {
+		return err
+	}
+
+	heap.Push(&s.delayed, &delayedJob{at: at, lane: i, job: job})
+	s.waiting[i]++
+	s.schedule()
+
+	return nil
+}
+
+// reserve returns the lane of the job when both the worker and the lane
+// have room, the lock must be held.
+func (s *Worker) reserve(job queue.QueuedMessage) (int, error) {
+	priority := queue.PriorityOf(job)
+	i := priority.Index()
+	if s.queued() >= s.capacity || s.laneQueued(i) >= s.laneCap(priority) {
+		return i, errMaxCapacity
+	}
+	return i, nil
+}
+
+// schedule set the timer to the first due job, the lock must be held.
+func (s *Worker) schedule() {
+	if s.delayed.Len() == 0 {
+		return
 	}
+
+	wait := time.Until(s.delayed[0].at)
+	if s.timer == nil {
+		s.timer = time.AfterFunc(wait, s.promote)
+		return
+	}
+	s.timer.Stop()
+	s.timer.Reset(wait)
+}
+
+// promote move the due jobs to their lane
+func (s *Worker) promote() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.stopped {
+		return
+	}
+
+	now := time.Now()
+	for s.delayed.Len() > 0 && !s.delayed[0].at.After(now) {
+		d := heap.Pop(&s.delayed).(*delayedJob)
+		s.waiting[d.lane]--
+		s.lanes[d.lane] = append(s.lanes[d.lane], d.job)
+		s.ready <- struct{}{}
+	}
+	s.schedule()
 }
 
 // WithQueueNum setup the capcity of queue
 func WithQueueNum(num int) Option {
 	return func(w *Worker) {
-		w.queueNotification = make(chan queue.QueuedMessage, num)
+		w.capacity = num
+	}
+}
+
+// WithLaneCapacity setup the capacity of a lane, zero means the lane is
+// only bounded by the queue capacity
+func WithLaneCapacity(priority queue.Priority, num int) Option {
+	return func(w *Worker) {
+		w.laneCapacity[priority] = num
+	}
+}
+
+// WithWeights setup how many jobs of each lane are dispatched in a round
+func WithWeights(weights map[queue.Priority]int) Option {
+	return func(w *Worker) {
+		for priority, weight := range weights {
+			if weight > 0 {
+				w.weights[priority] = weight
+			}
+		}
 	}
 }
 
@@ -79,14 +354,24 @@ func WithRunFunc(fn func(queue.QueuedMessage) error) Option {
 	}
 }
 
+// WithFailFunc setup the func called with the error of run func
+func WithFailFunc(fn func(queue.QueuedMessage, error)) Option {
+	return func(w *Worker) {
+		w.failFunc = fn
+	}
+}
+
 // NewWorker for struc
 func NewWorker(opts ...Option) *Worker {
 	w := &Worker{
-		queueNotification: make(chan queue.QueuedMessage, runtime.NumCPU()<<1),
-		runFunc: func(msg queue.QueuedMessage) error {
-			go_scrape.SendNotification(msg)
-			return nil
-		},
+		capacity:     runtime.NumCPU() << 1,
+		laneCapacity: map[queue.Priority]int{},
+		weights:      map[queue.Priority]int{},
+		runFunc:      go_scrape.Run,
+		failFunc:     go_scrape.DeadLetter,
+	}
+	for priority, weight := range queue.DefaultWeights {
+		w.weights[priority] = weight
 	}
 
 	// Loop through each option
@@ -95,5 +380,11 @@ func NewWorker(opts ...Option) *Worker {
 		opt(w)
 	}
 
+	w.picker = queue.NewPicker(w.weights)
+	w.lanes = make([][]queue.QueuedMessage, len(queue.Priorities))
+	w.busy = make([]int64, len(queue.Priorities))
+	w.waiting = make([]int, len(queue.Priorities))
+	w.ready = make(chan struct{}, w.capacity)
+
 	return w
 }
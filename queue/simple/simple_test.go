@@ -1,7 +1,9 @@
 package simple
 
 import (
+	"errors"
 	"runtime"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -72,3 +74,462 @@ func TestCustomFuncAndWait(t *testing.T) {
 	q.Wait()
 	// you will see the execute time > 1000ms
 }
+
+func TestFailFunc(t *testing.T) {
+	failed := make(chan error, 1)
+	w := NewWorker(
+		WithRunFunc(func(msg queue.QueuedMessage) error {
+			return errors.New("upstream down")
+		}),
+		WithFailFunc(func(msg queue.QueuedMessage, err error) {
+			failed <- err
+		}),
+	)
+	q := queue.NewQueue(w, 1)
+	q.Start()
+	assert.NoError(t, q.Queue(mockMessage{msg: "foo"}))
+
+	select {
+	case err := <-failed:
+		assert.EqualError(t, err, "upstream down")
+	case <-time.After(time.Second):
+		t.Fatal("fail func is not called")
+	}
+
+	q.Shutdown()
+	q.Wait()
+}
+
+func TestShutdownTwice(t *testing.T) {
+	w := NewWorker()
+	q := queue.NewQueue(w, 1)
+	q.Start()
+
+	q.Shutdown()
+	q.Shutdown()
+	q.Wait()
+	assert.Equal(t, errShutdown, w.Queue(mockMessage{msg: "foo"}))
+}
+
+func TestReleaseFinished(t *testing.T) {
+	sup := queue.NewSupervisor()
+	w := NewWorker(
+		WithRunFunc(sup.Wrap(func(msg queue.QueuedMessage) error {
+			time.Sleep(50 * time.Millisecond)
+			if string(msg.Bytes()) == "baz" {
+				return errors.New("baz")
+			}
+			return nil
+		})),
+		WithFailFunc(func(queue.QueuedMessage, error) {}),
+		WithQueueNum(4),
+	)
+	q := queue.NewQueue(w, 1, queue.WithSupervisor(sup))
+	assert.NoError(t, q.Queue(mockMessage{msg: "foo"}))
+	assert.NoError(t, q.Queue(mockMessage{msg: "bar"}))
+	assert.NoError(t, q.Queue(mockMessage{msg: "baz"}))
+	q.Start()
+
+	report := q.Release(time.Second, func(msg queue.QueuedMessage) {
+		t.Fatal("no job is drained")
+	})
+	assert.Equal(t, queue.Report{Pending: 3, Finished: 2, Failed: 1}, report)
+	assert.Equal(t, 0, w.Usage())
+}
+
+func TestReleaseTimeout(t *testing.T) {
+	block := make(chan struct{})
+	defer close(block)
+
+	w := NewWorker(
+		WithQueueNum(4),
+		WithRunFunc(func(msg queue.QueuedMessage) error {
+			<-block
+			return nil
+		}),
+	)
+	q := queue.NewQueue(w, 1)
+	q.Start()
+	assert.NoError(t, q.Queue(mockMessage{msg: "foo"}))
+	assert.NoError(t, q.Queue(mockMessage{msg: "bar"}))
+	assert.NoError(t, q.Queue(mockMessage{msg: "baz"}))
+	assert.Eventually(t, func() bool {
+		return w.Lanes()[1].Usage == 3 && w.running() == 1
+	}, time.Second, 10*time.Millisecond)
+
+	var drained []string
+	report := q.Release(100*time.Millisecond, func(msg queue.QueuedMessage) {
+		drained = append(drained, string(msg.Bytes()))
+	})
+	assert.Equal(t, []string{"bar", "baz"}, drained)
+	assert.Equal(t, queue.Report{Pending: 3, Finished: 0, Abandoned: 3, Drained: 2}, report)
+}
+
+func TestReleaseCancel(t *testing.T) {
+	cancel := make(chan struct{})
+
+	w := NewWorker(
+		WithQueueNum(4),
+		WithRunFunc(func(msg queue.QueuedMessage) error {
+			<-cancel
+			return nil
+		}),
+	)
+	q := queue.NewQueue(w, 1, queue.WithCancelFunc(func() []queue.QueuedMessage {
+		close(cancel)
+		return []queue.QueuedMessage{mockMessage{msg: "foo"}}
+	}, time.Second))
+	q.Start()
+	assert.NoError(t, q.Queue(mockMessage{msg: "foo"}))
+	assert.Eventually(t, func() bool {
+		return w.running() == 1
+	}, time.Second, 10*time.Millisecond)
+
+	// the running job is cancelled then handed over
+	var drained []string
+	report := q.Release(100*time.Millisecond, func(msg queue.QueuedMessage) {
+		drained = append(drained, string(msg.Bytes()))
+	})
+	assert.Equal(t, []string{"foo"}, drained)
+	assert.Equal(t, queue.Report{Pending: 1, Abandoned: 1, Cancelled: 1}, report)
+	assert.Equal(t, 0, w.Usage())
+}
+
+type priorityMessage struct {
+	msg      string
+	priority queue.Priority
+}
+
+func (m priorityMessage) Bytes() []byte {
+	return []byte(m.msg)
+}
+
+func (m priorityMessage) QueuePriority() queue.Priority {
+	return m.priority
+}
+
+func TestWeightedDispatch(t *testing.T) {
+	done := make(chan queue.Priority, 20)
+	w := NewWorker(
+		WithQueueNum(20),
+		WithRunFunc(func(msg queue.QueuedMessage) error {
+			done <- queue.PriorityOf(msg)
+			return nil
+		}),
+	)
+
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, w.Queue(priorityMessage{msg: "low", priority: queue.PriorityLow}))
+	}
+	for i := 0; i < 10; i++ {
+		assert.NoError(t, w.Queue(priorityMessage{msg: "high", priority: queue.PriorityHigh}))
+	}
+
+	lanes := w.Lanes()
+	assert.Equal(t, queue.Lane{Priority: queue.PriorityHigh, Weight: 6, Capacity: 20, Usage: 10}, lanes[0])
+	assert.Equal(t, queue.Lane{Priority: queue.PriorityNormal, Weight: 3, Capacity: 20, Usage: 0}, lanes[1])
+	assert.Equal(t, queue.Lane{Priority: queue.PriorityLow, Weight: 1, Capacity: 20, Usage: 10}, lanes[2])
+
+	q := queue.NewQueue(w, 1)
+	q.Start()
+
+	counts := map[queue.Priority]int{}
+	for i := 0; i < 7; i++ {
+		select {
+		case priority := <-done:
+			counts[priority]++
+		case <-time.After(time.Second):
+			t.Fatal("message is not received")
+		}
+	}
+	// the low lane gets its share while the high one isn't empty
+	assert.Equal(t, map[queue.Priority]int{queue.PriorityHigh: 6, queue.PriorityLow: 1}, counts)
+
+	q.Shutdown()
+	q.Wait()
+	assert.Equal(t, 0, w.Usage())
+}
+
+func TestLaneCapacity(t *testing.T) {
+	w := NewWorker(
+		WithQueueNum(3),
+		WithLaneCapacity(queue.PriorityLow, 1),
+	)
+
+	assert.NoError(t, w.Queue(priorityMessage{priority: queue.PriorityLow}))
+	assert.Equal(t, errMaxCapacity, w.Queue(priorityMessage{priority: queue.PriorityLow}))
+	assert.NoError(t, w.Queue(priorityMessage{priority: queue.PriorityHigh}))
+	assert.NoError(t, w.Queue(mockMessage{msg: "foo"}))
+	assert.Equal(t, errMaxCapacity, w.Queue(priorityMessage{priority: queue.PriorityHigh}))
+
+	assert.Equal(t, 1, w.Lanes()[2].Capacity)
+	assert.Equal(t, 3, w.Usage())
+}
+
+func TestQueueAt(t *testing.T) {
+	received := make(chan string, 3)
+	w := NewWorker(
+		WithQueueNum(3),
+		WithRunFunc(func(msg queue.QueuedMessage) error {
+			received <- string(msg.Bytes())
+			return nil
+		}),
+	)
+	q := queue.NewQueue(w, 1)
+	q.Start()
+
+	assert.NoError(t, q.QueueAfter(mockMessage{msg: "later"}, 200*time.Millisecond))
+	assert.NoError(t, q.QueueAt(mockMessage{msg: "soon"}, time.Now().Add(100*time.Millisecond)))
+	// a past time is queued right away
+	assert.NoError(t, q.QueueAt(mockMessage{msg: "now"}, time.Now().Add(-time.Second)))
+	// the delayed jobs count in the capacity
+	assert.Equal(t, errMaxCapacity, q.QueueAfter(mockMessage{msg: "full"}, time.Second))
+
+	for _, expected := range []string{"now", "soon", "later"} {
+		select {
+		case msg := <-received:
+			assert.Equal(t, expected, msg)
+		case <-time.After(time.Second):
+			t.Fatal("message is not received")
+		}
+	}
+
+	q.Shutdown()
+	q.Wait()
+	assert.Equal(t, errShutdown, w.QueueAt(mockMessage{msg: "foo"}, time.Now()))
+}
+
+func TestReleaseDelayed(t *testing.T) {
+	w := NewWorker()
+	q := queue.NewQueue(w, 1)
+	q.Start()
+	assert.NoError(t, q.QueueAfter(mockMessage{msg: "foo"}, time.Hour))
+	assert.Equal(t, 1, w.Usage())
+	assert.Equal(t, 1, w.Lanes()[1].Usage)
+
+	var drained []string
+	report := q.Release(time.Second, func(msg queue.QueuedMessage) {
+		drained = append(drained, string(msg.Bytes()))
+	})
+	assert.Equal(t, []string{"foo"}, drained)
+	assert.Equal(t, queue.Report{Pending: 1, Finished: 0, Abandoned: 1, Drained: 1}, report)
+}
+
+func TestResize(t *testing.T) {
+	block := make(chan struct{})
+	w := NewWorker(
+		WithQueueNum(8),
+		WithRunFunc(func(msg queue.QueuedMessage) error {
+			<-block
+			return nil
+		}),
+	)
+	q := queue.NewQueue(w, 1)
+	assert.Equal(t, queue.ErrInvalidWorkerNum, q.Resize(0))
+	assert.NoError(t, q.Resize(2))
+	q.Start()
+	assert.Equal(t, 2, q.Workers())
+
+	for i := 0; i < 4; i++ {
+		assert.NoError(t, q.Queue(mockMessage{msg: "foo"}))
+	}
+	assert.Eventually(t, func() bool {
+		return w.running() == 2
+	}, time.Second, 10*time.Millisecond)
+
+	// the new workers pick up the queued jobs
+	assert.NoError(t, q.Resize(4))
+	assert.Eventually(t, func() bool {
+		return w.running() == 4
+	}, time.Second, 10*time.Millisecond)
+
+	// the retired workers finish their job
+	assert.NoError(t, q.Resize(1))
+	assert.Equal(t, 1, q.Workers())
+	close(block)
+	assert.Eventually(t, func() bool {
+		return w.Usage() == 0
+	}, time.Second, 10*time.Millisecond)
+
+	q.Shutdown()
+	q.Wait()
+	assert.Equal(t, queue.ErrQueueShutdown, q.Resize(2))
+}
+
+func TestAutoscale(t *testing.T) {
+	block := make(chan struct{})
+	defer close(block)
+
+	w := NewWorker(
+		WithQueueNum(8),
+		WithRunFunc(func(msg queue.QueuedMessage) error {
+			<-block
+			return nil
+		}),
+	)
+	q := queue.NewQueue(w, 1)
+	latency := time.Duration(0)
+	a := queue.NewAutoscaler(
+		q,
+		queue.WithScaleBounds(1, 3),
+		queue.WithMaxLatency(time.Second),
+		queue.WithLatencyFunc(func() time.Duration { return latency }),
+	)
+
+	// idle pool stays at the min
+	assert.Equal(t, 1, a.Scale())
+
+	for i := 0; i < 5; i++ {
+		assert.NoError(t, q.Queue(mockMessage{msg: "foo"}))
+	}
+	// a worker per queued job up to the max
+	assert.Equal(t, 3, a.Scale())
+
+	// the slow upstream shrinks the pool
+	latency = 2 * time.Second
+	assert.Equal(t, 2, a.Scale())
+	assert.Equal(t, 1, a.Scale())
+	assert.Equal(t, 1, a.Scale())
+}
+
+func TestSupervisorQuarantine(t *testing.T) {
+	var panics int
+	var quarantined error
+	sup := queue.NewSupervisor(
+		queue.WithMaxPanics(2),
+		queue.WithRestartBackoff(time.Millisecond, time.Millisecond),
+		queue.WithPanicFunc(func(msg queue.QueuedMessage, err *queue.PanicError) {
+			assert.Equal(t, "boom", err.Value)
+			assert.Contains(t, err.Stack, "TestSupervisorQuarantine")
+			panics++
+		}),
+		queue.WithQuarantineFunc(func(msg queue.QueuedMessage, err error) {
+			quarantined = err
+		}),
+	)
+	var runs int
+	run := sup.Wrap(func(msg queue.QueuedMessage) error {
+		runs++
+		if string(msg.Bytes()) == "boom" {
+			panic("boom")
+		}
+		return nil
+	})
+
+	assert.NoError(t, run(mockMessage{msg: "foo"}))
+	assert.Equal(t, 1, runs)
+
+	// the job without panic count is quarantined on its first panic
+	assert.NoError(t, run(mockMessage{msg: "boom"}))
+	assert.True(t, errors.Is(quarantined, queue.ErrQuarantined))
+	assert.Equal(t, 1, panics)
+	assert.Equal(t, 2, runs)
+}
+
+// panicMessage keeps the count of its panics
+type panicMessage struct {
+	msg    string
+	panics int
+}
+
+func (m panicMessage) Bytes() []byte {
+	return []byte(m.msg)
+}
+
+func (m panicMessage) Panicked() (queue.QueuedMessage, int) {
+	m.panics++
+	return m, m.panics
+}
+
+func TestSupervisorQuarantineWorker(t *testing.T) {
+	var runs, panics int32
+	quarantined := make(chan error, 1)
+	sup := queue.NewSupervisor(
+		queue.WithMaxPanics(3),
+		queue.WithRestartBackoff(time.Millisecond, time.Millisecond),
+		queue.WithPanicFunc(func(msg queue.QueuedMessage, err *queue.PanicError) {
+			atomic.AddInt32(&panics, 1)
+		}),
+		queue.WithQuarantineFunc(func(msg queue.QueuedMessage, err error) {
+			quarantined <- err
+		}),
+	)
+	w := NewWorker(
+		WithRunFunc(sup.Wrap(func(msg queue.QueuedMessage) error {
+			atomic.AddInt32(&runs, 1)
+			panic("boom")
+		})),
+		WithFailFunc(func(msg queue.QueuedMessage, err error) {
+			t.Errorf("panicked job failed before its quarantine: %v", err)
+		}),
+	)
+	q := queue.NewQueue(w, 1, queue.WithSupervisor(sup))
+	q.Start()
+	// the job is queued again until the third panic quarantines it
+	assert.NoError(t, q.Queue(panicMessage{msg: "boom"}))
+
+	select {
+	case err := <-quarantined:
+		assert.True(t, errors.Is(err, queue.ErrQuarantined))
+	case <-time.After(time.Second):
+		t.Fatal("job is not quarantined")
+	}
+	assert.Equal(t, int32(3), atomic.LoadInt32(&runs))
+	assert.Equal(t, int32(3), atomic.LoadInt32(&panics))
+
+	q.Shutdown()
+	q.Wait()
+}
+
+// flakyWorker can't start once then crashes once
+type flakyWorker struct {
+	*Worker
+	starts  int32
+	crashes int32
+}
+
+func (w *flakyWorker) BeforeRun() error {
+	if atomic.AddInt32(&w.starts, 1) == 1 {
+		return errors.New("not ready")
+	}
+	return nil
+}
+
+func (w *flakyWorker) Run(quit chan struct{}) error {
+	if atomic.AddInt32(&w.crashes, 1) == 1 {
+		panic("crash")
+	}
+	return w.Worker.Run(quit)
+}
+
+func TestSupervisorRestart(t *testing.T) {
+	var panics int32
+	done := make(chan struct{}, 1)
+	w := &flakyWorker{Worker: NewWorker(
+		WithRunFunc(func(msg queue.QueuedMessage) error {
+			done <- struct{}{}
+			return nil
+		}),
+	)}
+	sup := queue.NewSupervisor(
+		queue.WithRestartBackoff(time.Millisecond, 10*time.Millisecond),
+		queue.WithPanicFunc(func(msg queue.QueuedMessage, err *queue.PanicError) {
+			assert.Nil(t, msg)
+			atomic.AddInt32(&panics, 1)
+		}),
+	)
+	q := queue.NewQueue(w, 1, queue.WithSupervisor(sup))
+	q.Start()
+	assert.NoError(t, q.Queue(mockMessage{msg: "foo"}))
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("worker is not restarted")
+	}
+	assert.Equal(t, int32(3), atomic.LoadInt32(&w.starts))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&panics))
+
+	q.Shutdown()
+	q.Wait()
+}
@@ -0,0 +1,201 @@
+package queue
+
+import (
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sync/atomic"
+	"time"
+
+	"github.com/natansdj/go_scrape/logx"
+)
+
+// ErrQuarantined is the error of a job which panicked too many times
+var ErrQuarantined = errors.New("job is quarantined after repeated panics")
+
+// PanicError is the error of a job or a worker which panicked
+type PanicError struct {
+	Value interface{}
+	Stack string
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("panic: %v", e.Value)
+}
+
+// NewPanicError returns the error of the recovered value with the stack of
+// the panicking goroutine, it must be called by the deferred func.
+func NewPanicError(v interface{}) *PanicError {
+	return &PanicError{
+		Value: v,
+		Stack: string(debug.Stack()),
+	}
+}
+
+// PanicCounter is a message which keeps the count of its panics, so it can
+// be queued again after a panic until it's quarantined. The other messages
+// are quarantined on their first panic.
+type PanicCounter interface {
+	// Panicked returns the copy of the message queued again and the
+	// number of panics, this one included.
+	Panicked() (QueuedMessage, int)
+}
+
+// SupervisorOption for the supervisor
+type SupervisorOption func(*Supervisor)
+
+// Supervisor recover the panics of the jobs and of the workers. A
+// panicking job is run again until it panicked max panics times, then it's
+// quarantined. A crashed worker is restarted with an exponential backoff,
+// which is also the delay before a panicking job runs again.
+type Supervisor struct {
+	maxPanics      int
+	backoff        time.Duration
+	maxBackoff     time.Duration
+	panicFunc      func(QueuedMessage, *PanicError)
+	quarantineFunc func(QueuedMessage, error)
+
+	// queue of the panicking jobs, set by NewQueue
+	queue *Queue
+
+	// jobs run through Wrap, by outcome
+	finished int64
+	failed   int64
+}
+
+// WithMaxPanics setup the number of panics before a job is quarantined
+func WithMaxPanics(n int) SupervisorOption {
+	return func(s *Supervisor) {
+		if n > 0 {
+			s.maxPanics = n
+		}
+	}
+}
+
+// WithRestartBackoff setup the first and the max delay before a crashed
+// worker is restarted or a panicking job runs again
+func WithRestartBackoff(backoff, max time.Duration) SupervisorOption {
+	return func(s *Supervisor) {
+		if backoff > 0 {
+			s.backoff = backoff
+		}
+		if max > 0 {
+			s.maxBackoff = max
+		}
+	}
+}
+
+// WithPanicFunc setup the func called on every panic, the job is nil when
+// the worker crashed
+func WithPanicFunc(fn func(QueuedMessage, *PanicError)) SupervisorOption {
+	return func(s *Supervisor) {
+		s.panicFunc = fn
+	}
+}
+
+// WithQuarantineFunc setup the func called with the quarantined job
+func WithQuarantineFunc(fn func(QueuedMessage, error)) SupervisorOption {
+	return func(s *Supervisor) {
+		s.quarantineFunc = fn
+	}
+}
+
+// NewSupervisor returns a Supervisor, default is 3 panics per job and a
+// restart backoff from 100ms up to 30s.
+func NewSupervisor(opts ...SupervisorOption) *Supervisor {
+	s := &Supervisor{
+		maxPanics:      3,
+		backoff:        100 * time.Millisecond,
+		maxBackoff:     30 * time.Second,
+		panicFunc:      func(QueuedMessage, *PanicError) {},
+		quarantineFunc: func(QueuedMessage, error) {},
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s
+}
+
+// Wrap returns the run func which recovers the panic of the job and queues
+// it again through the worker after the backoff until it's quarantined. The
+// panicking job returns no error, so the workers don't attempt it again,
+// whatever their engine.
+func (s *Supervisor) Wrap(fn func(QueuedMessage) error) func(QueuedMessage) error {
+	return func(msg QueuedMessage) error {
+		err := s.run(fn, msg)
+
+		var panicErr *PanicError
+		if !errors.As(err, &panicErr) {
+			if err != nil {
+				atomic.AddInt64(&s.failed, 1)
+			} else {
+				atomic.AddInt64(&s.finished, 1)
+			}
+			return err
+		}
+
+		s.panicked(msg, panicErr)
+		return nil
+	}
+}
+
+// run the job, its panic is returned as a *PanicError
+func (s *Supervisor) run(fn func(QueuedMessage) error, msg QueuedMessage) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = NewPanicError(r)
+		}
+	}()
+
+	return fn(msg)
+}
+
+// panicked queue the panicking job again after the backoff, it's
+// quarantined after max panics or when it can't be queued again.
+func (s *Supervisor) panicked(msg QueuedMessage, panicErr *PanicError) {
+	panics := 1
+	var next QueuedMessage
+	if counter, ok := msg.(PanicCounter); ok {
+		next, panics = counter.Panicked()
+	}
+
+	logx.LogError.Errorf("job panicked %d/%d: %v\n%s", panics, s.maxPanics, panicErr.Value, panicErr.Stack)
+	s.panicFunc(msg, panicErr)
+
+	if next != nil && s.queue != nil && panics < s.maxPanics {
+		err := s.queue.QueueAfter(next, s.restartDelay(panics-1))
+		if err == nil {
+			return
+		}
+		logx.LogError.Error("queue the panicking job again: ", err)
+	}
+
+	s.quarantineFunc(msg, fmt.Errorf("%w: %d times, last %v", ErrQuarantined, panics, panicErr))
+	atomic.AddInt64(&s.failed, 1)
+}
+
+// counts returns the number of finished and failed jobs run through Wrap
+func (s *Supervisor) counts() (finished, failed int64) {
+	return atomic.LoadInt64(&s.finished), atomic.LoadInt64(&s.failed)
+}
+
+// crashed record the panic of a worker
+func (s *Supervisor) crashed(num int, err *PanicError) {
+	logx.LogError.Errorf("worker num %d crashed: %v\n%s", num, err.Value, err.Stack)
+	s.panicFunc(nil, err)
+}
+
+// restartDelay returns the delay before the restart after the failures in
+// a row
+func (s *Supervisor) restartDelay(failures int) time.Duration {
+	wait := s.backoff
+	for i := 0; i < failures && wait < s.maxBackoff; i++ {
+		wait *= 2
+	}
+	if wait > s.maxBackoff {
+		return s.maxBackoff
+	}
+	return wait
+}
@@ -1,5 +1,14 @@
 package queue
 
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrDecode is the error of a message which can't be decoded
+var ErrDecode = errors.New("can't decode message")
+
 // Worker interface
 type Worker interface {
 	BeforeRun() error
@@ -16,3 +25,29 @@ type Worker interface {
 type QueuedMessage interface {
 	Bytes() []byte
 }
+
+// RawMessage is the payload of a message which can't be decoded, it's
+// handed to the fail func of the worker so the payload is not lost.
+type RawMessage []byte
+
+// Bytes returns the payload
+func (m RawMessage) Bytes() []byte {
+	return m
+}
+
+// DecodeError returns the error of the payload which can't be decoded
+func DecodeError(err error) error {
+	return fmt.Errorf("%w: %v", ErrDecode, err)
+}
+
+// Drainer is a worker which loses the jobs not started on shutdown, Drain
+// takes them out of the worker so they can be kept elsewhere.
+type Drainer interface {
+	Drain() []QueuedMessage
+}
+
+// Delayer is a worker which holds the jobs until they are due, so the
+// callers don't need a sleeping goroutine per job.
+type Delayer interface {
+	QueueAt(job QueuedMessage, at time.Time) error
+}
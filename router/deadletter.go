@@ -0,0 +1,97 @@
+package router
+
+import (
+	"net/http"
+
+	"github.com/natansdj/go_scrape/config"
+	"github.com/natansdj/go_scrape/go_scrape"
+	"github.com/natansdj/go_scrape/logx"
+	"github.com/natansdj/go_scrape/queue"
+	"github.com/natansdj/go_scrape/status"
+	"github.com/natansdj/go_scrape/storage"
+
+	"github.com/gin-gonic/gin"
+)
+
+func deadLetterError(c *gin.Context, err error) {
+	if err == storage.ErrDeadLetterNotFound {
+		abortWithError(c, http.StatusNotFound, err.Error())
+		return
+	}
+
+	logx.LogError.Error("dead letter storage error: " + err.Error())
+	abortWithError(c, http.StatusInternalServerError, err.Error())
+}
+
+func listDeadLetterHandler(c *gin.Context) {
+	limit, ok := queryLimit(c)
+	if !ok {
+		return
+	}
+
+	dls, err := status.StatStorage.ListDeadLetters(limit)
+	if err != nil {
+		deadLetterError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"dead_letters": dls,
+	})
+}
+
+func getDeadLetterHandler(c *gin.Context) {
+	dl, err := status.StatStorage.GetDeadLetter(c.Param("id"))
+	if err != nil {
+		deadLetterError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, dl)
+}
+
+func requeueDeadLetterHandler(cfg config.ConfYaml, q *queue.Queue) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		dl, err := status.StatStorage.GetDeadLetter(c.Param("id"))
+		if err != nil {
+			deadLetterError(c, err)
+			return
+		}
+
+		if err := go_scrape.Requeue(cfg, q, dl); err != nil {
+			logx.LogError.Errorf("can't requeue dead letter %s: %v", dl.ID, err)
+			abortWithError(c, http.StatusServiceUnavailable, err.Error())
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"success": "ok",
+			"id":      dl.ID,
+			"job_id":  dl.JobID,
+		})
+	}
+}
+
+func deleteDeadLetterHandler(c *gin.Context) {
+	if err := status.StatStorage.DeleteDeadLetter(c.Param("id")); err != nil {
+		deadLetterError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": "ok",
+	})
+}
+
+func purgeDeadLetterHandler(c *gin.Context) {
+	count, err := status.StatStorage.PurgeDeadLetters()
+	if err != nil {
+		deadLetterError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"success": "ok",
+		"counts":  count,
+	})
+}
@@ -0,0 +1,70 @@
+package router
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/natansdj/go_scrape/go_scrape"
+	"github.com/natansdj/go_scrape/logx"
+	"github.com/natansdj/go_scrape/status"
+	"github.com/natansdj/go_scrape/storage"
+
+	"github.com/gin-gonic/gin"
+)
+
+func jobError(c *gin.Context, err error) {
+	if err == storage.ErrJobNotFound {
+		abortWithError(c, http.StatusNotFound, err.Error())
+		return
+	}
+
+	logx.LogError.Error("job storage error: " + err.Error())
+	abortWithError(c, http.StatusInternalServerError, err.Error())
+}
+
+func listJobHandler(c *gin.Context) {
+	limit, ok := queryLimit(c)
+	if !ok {
+		return
+	}
+
+	state := storage.JobState(c.Query("state"))
+	if state != "" && !state.IsValid() {
+		abortWithError(c, http.StatusBadRequest, "unknown job state: "+string(state))
+		return
+	}
+
+	jobs, err := status.StatStorage.ListJobs(state, limit)
+	if err != nil {
+		jobError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"jobs": jobs,
+	})
+}
+
+func getJobHandler(c *gin.Context) {
+	job, err := status.StatStorage.GetJob(c.Param("id"))
+	if err != nil {
+		jobError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, job)
+}
+
+func cancelJobHandler(c *gin.Context) {
+	job, err := go_scrape.CancelJob(c.Param("id"))
+	if errors.Is(err, go_scrape.ErrJobNotCancellable) {
+		abortWithError(c, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil {
+		jobError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, job)
+}
@@ -3,21 +3,23 @@ package router
 import (
 	"context"
 	"crypto/tls"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/natansdj/go_scrape/metric"
 	"github.com/natansdj/go_scrape/status"
+	"github.com/natansdj/go_scrape/storage"
 	"net/http"
-	"net/url"
 	"os"
+	"strconv"
 	"sync"
+	"time"
 
 	"github.com/natansdj/go_scrape/config"
 	"github.com/natansdj/go_scrape/core"
 	"github.com/natansdj/go_scrape/go_scrape"
 	"github.com/natansdj/go_scrape/logx"
 	"github.com/natansdj/go_scrape/queue"
+	"github.com/natansdj/go_scrape/scrape"
 
 	api "github.com/appleboy/gin-status-api"
 	"github.com/gin-contrib/logger"
@@ -114,7 +116,16 @@ func pushHandler(cfg config.ConfYaml, q *queue.Queue) gin.HandlerFunc {
 			}
 		}()
 
-		counts, logs := handleNotification(ctx, cfg, form, q)
+		counts, logs, err := handleNotification(ctx, cfg, form, q)
+		if errors.Is(err, queue.ErrMaxCapacity) {
+			c.JSON(http.StatusServiceUnavailable, gin.H{
+				"code":    http.StatusServiceUnavailable,
+				"message": err.Error(),
+				"counts":  counts,
+				"logs":    logs,
+			})
+			return
+		}
 
 		c.JSON(http.StatusOK, gin.H{
 			"success": "ok",
@@ -124,6 +135,111 @@ func pushHandler(cfg config.ConfYaml, q *queue.Queue) gin.HandlerFunc {
 	}
 }
 
+func scrapeHandler(cfg config.ConfYaml, q *queue.Queue) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var form go_scrape.RequestScrape
+		var msg string
+
+		if err := c.ShouldBindWith(&form, binding.JSON); err != nil {
+			msg = "Missing jobs field."
+			logx.LogAccess.Debug(err)
+			abortWithError(c, http.StatusBadRequest, msg)
+			return
+		}
+
+		if len(form.Jobs) == 0 {
+			msg = "Jobs field is empty."
+			logx.LogAccess.Debug(msg)
+			abortWithError(c, http.StatusBadRequest, msg)
+			return
+		}
+
+		if int64(len(form.Jobs)) > cfg.Core.MaxNotification && cfg.Core.MaxNotification > 0 {
+			msg = fmt.Sprintf("Number of jobs(%d) over limit(%d)", len(form.Jobs), cfg.Core.MaxNotification)
+			logx.LogAccess.Debug(msg)
+			abortWithError(c, http.StatusBadRequest, msg)
+			return
+		}
+
+		for _, job := range form.Jobs {
+			if job.Priority != "" && !job.Priority.IsValid() {
+				msg = fmt.Sprintf("Unknown priority %q, must be one of %v", job.Priority, queue.Priorities)
+				logx.LogAccess.Debug(msg)
+				abortWithError(c, http.StatusBadRequest, msg)
+				return
+			}
+			if job.Delay < 0 {
+				msg = fmt.Sprintf("Delay(%d) can't be negative", job.Delay)
+				logx.LogAccess.Debug(msg)
+				abortWithError(c, http.StatusBadRequest, msg)
+				return
+			}
+			if job.Source != "" {
+				if _, _, err := scrape.ResolveSource(cfg, job.Source); err != nil {
+					msg = fmt.Sprintf("Unknown source %q, must be one of %v", job.Source, scrape.Sources())
+					logx.LogAccess.Debug(msg)
+					abortWithError(c, http.StatusBadRequest, msg)
+					return
+				}
+			}
+			if err := validateJob(cfg, job); err != nil {
+				msg = err.Error()
+				logx.LogAccess.Debug(msg)
+				abortWithError(c, http.StatusBadRequest, msg)
+				return
+			}
+		}
+
+		if key := c.GetHeader("Idempotency-Key"); key != "" {
+			for i := range form.Jobs {
+				switch {
+				case form.Jobs[i].IdempotencyKey != "":
+				case len(form.Jobs) == 1:
+					form.Jobs[i].IdempotencyKey = key
+				default:
+					form.Jobs[i].IdempotencyKey = fmt.Sprintf("%s#%d", key, i)
+				}
+			}
+		}
+
+		counts, jobs, logs, err := handleScrape(c.Request.Context(), cfg, form, q)
+		if errors.Is(err, queue.ErrMaxCapacity) {
+			c.JSON(http.StatusServiceUnavailable, gin.H{
+				"code":    http.StatusServiceUnavailable,
+				"message": err.Error(),
+				"counts":  counts,
+				"jobs":    jobs,
+				"logs":    logs,
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"success": "ok",
+			"counts":  counts,
+			"jobs":    jobs,
+			"logs":    logs,
+		})
+	}
+}
+
+// validateJob check the preset of the job with its params before it's
+// queued, as the run would.
+func validateJob(cfg config.ConfYaml, job go_scrape.ScrapeJob) error {
+	preset, err := scrape.Resolve(cfg, job.Preset)
+	if err != nil {
+		return err
+	}
+
+	if job.Params != nil {
+		if preset, err = job.Params.Apply(preset); err != nil {
+			return err
+		}
+	}
+
+	return scrape.Validate(preset)
+}
+
 func configHandler(cfg config.ConfYaml) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		c.YAML(http.StatusCreated, cfg)
@@ -135,19 +251,38 @@ func metricsHandler(c *gin.Context) {
 	promhttp.Handler().ServeHTTP(c.Writer, c.Request)
 }
 
-func appStatusHandler(q *queue.Queue) gin.HandlerFunc {
+func appStatusHandler(q *queue.Queue, clients *scrape.ClientFactory) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		result := status.App{}
 
 		result.Version = GetVersion()
 		result.QueueMax = q.Capacity()
 		result.QueueUsage = q.Usage()
+		result.QueueLanes = q.Lanes()
+		result.QueueWorkers = q.Workers()
 		result.TotalCount = status.StatStorage.GetTotalCount()
+		result.SuccessCount = status.StatStorage.GetSuccessCount()
+		result.FailureCount = status.StatStorage.GetFailureCount()
+		result.RetryCount = status.StatStorage.GetRetryCount()
+		result.PanicCount = status.StatStorage.GetPanicCount()
+		result.RateLimitWait = status.StatStorage.GetRateLimitWait()
+		result.CacheHitCount = status.StatStorage.GetCacheHit()
+		result.CacheMissCount = status.StatStorage.GetCacheMiss()
+		result.Breakers = breakerStates(clients)
 
 		c.JSON(http.StatusOK, result)
 	}
 }
 
+// breakerStates returns the state of the circuit breaker by source
+func breakerStates(clients *scrape.ClientFactory) map[string]string {
+	states := map[string]string{}
+	for name, state := range clients.Breakers() {
+		states[name] = string(state)
+	}
+	return states
+}
+
 func sysStatsHandler() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		c.JSON(http.StatusOK, status.Stats.Data())
@@ -163,7 +298,7 @@ func StatMiddleware() gin.HandlerFunc {
 	}
 }
 
-func autoTLSServer(cfg config.ConfYaml, q *queue.Queue) *http.Server {
+func autoTLSServer(cfg config.ConfYaml, q *queue.Queue, clients *scrape.ClientFactory) *http.Server {
 	m := autocert.Manager{
 		Prompt:     autocert.AcceptTOS,
 		HostPolicy: autocert.HostWhitelist(cfg.Core.AutoTLS.Host),
@@ -173,7 +308,7 @@ func autoTLSServer(cfg config.ConfYaml, q *queue.Queue) *http.Server {
 	return &http.Server{
 		Addr:      ":https",
 		TLSConfig: &tls.Config{GetCertificate: m.GetCertificate},
-		Handler:   routerEngine(cfg, q),
+		Handler:   routerEngine(cfg, q, clients),
 	}
 }
 
@@ -192,12 +327,15 @@ func markFailedNotification(cfg config.ConfYaml, notification *go_scrape.PushNot
 			Format:    cfg.Log.Format,
 		}))
 	}
+	go_scrape.DeadLetter(notification, errors.New(reason))
 	notification.WaitDone()
 }
 
-// HandleNotification add notification to queue list.
-func handleNotification(ctx context.Context, cfg config.ConfYaml, req go_scrape.RequestPush, q *queue.Queue) (int, []logx.LogPushEntry) {
+// HandleNotification add notification to queue list, it returns
+// queue.ErrMaxCapacity when a notification is rejected by the full queue.
+func handleNotification(ctx context.Context, cfg config.ConfYaml, req go_scrape.RequestPush, q *queue.Queue) (int, []logx.LogPushEntry, error) {
 	var count int
+	var full error
 	wg := sync.WaitGroup{}
 	newNotification := []*go_scrape.PushNotification{}
 
@@ -208,6 +346,7 @@ func handleNotification(ctx context.Context, cfg config.ConfYaml, req go_scrape.
 	for i := range req.Notifications {
 		notification := &req.Notifications[i]
 		notification.Cfg = cfg
+		notification.Ctx = ctx
 		newNotification = append(newNotification, notification)
 	}
 
@@ -220,7 +359,10 @@ func handleNotification(ctx context.Context, cfg config.ConfYaml, req go_scrape.
 		}
 
 		if err := q.Queue(notification); err != nil {
-			markFailedNotification(cfg, notification, "max capacity reached")
+			markFailedNotification(cfg, notification, err.Error())
+			if errors.Is(err, queue.ErrMaxCapacity) {
+				full = err
+			}
 		}
 
 		count += len(notification.Tokens)
@@ -236,10 +378,77 @@ func handleNotification(ctx context.Context, cfg config.ConfYaml, req go_scrape.
 
 	status.StatStorage.AddTotalCount(int64(count))
 
-	return count, returnedLog
+	return count, returnedLog, full
+}
+
+// markFailedScrape record the failure of a job which can't be queued
+func markFailedScrape(job *go_scrape.ScrapeJob, reason string) {
+	logx.LogError.Errorf("scrape job %s/%s: %s", job.Source, job.Preset, reason)
+	status.StatStorage.AddFailureCount(1)
+	go_scrape.DeadLetter(job, errors.New(reason))
+	job.AddLog(go_scrape.ScrapeLog{
+		JobID:   job.ID,
+		Source:  job.Source,
+		Preset:  job.Preset,
+		Attempt: job.Attempt,
+		Error:   reason,
+	})
+	job.WaitDone()
+}
+
+// handleScrape add scrape jobs to queue list, the jobs waited in sync mode
+// are cancelled when ctx is done. It returns queue.ErrMaxCapacity when a job
+// is rejected by the full queue.
+func handleScrape(ctx context.Context, cfg config.ConfYaml, req go_scrape.RequestScrape, q *queue.Queue) (int, []string, []go_scrape.ScrapeLog, error) {
+	var count int
+	var full error
+	wg := sync.WaitGroup{}
+	logs := &go_scrape.ScrapeLogs{}
+	ids := make([]string, 0, len(req.Jobs))
+
+	if cfg.Core.Sync && !core.IsLocalQueue(core.Queue(cfg.Queue.Engine)) {
+		cfg.Core.Sync = false
+	}
+
+	for i := range req.Jobs {
+		job := &req.Jobs[i]
+		job.Cfg = cfg
+		job.ID = ""
+		job.ScheduledAt = time.Now()
+
+		// the delayed jobs are not waited in sync mode
+		if cfg.Core.Sync && !job.DueAt().After(job.ScheduledAt) {
+			job.Wg = &wg
+			job.Log = logs
+			job.Ctx = ctx
+			job.AddWaitCount()
+		}
+
+		if err := go_scrape.EnqueueScrape(q, job); err != nil {
+			markFailedScrape(job, err.Error())
+			if errors.Is(err, queue.ErrMaxCapacity) {
+				full = err
+			}
+			continue
+		}
+
+		// the existing job is waited by its own request
+		if job.Duplicate {
+			job.WaitDone()
+		}
+
+		ids = append(ids, job.ID)
+		count++
+	}
+
+	if cfg.Core.Sync {
+		wg.Wait()
+	}
+
+	return count, ids, logs.Entries(), full
 }
 
-func routerEngine(cfg config.ConfYaml, q *queue.Queue) *gin.Engine {
+func routerEngine(cfg config.ConfYaml, q *queue.Queue, clients *scrape.ClientFactory) *gin.Engine {
 	zerolog.SetGlobalLevel(zerolog.InfoLevel)
 	if cfg.Core.Mode == "debug" {
 		zerolog.SetGlobalLevel(zerolog.DebugLevel)
@@ -261,6 +470,11 @@ func routerEngine(cfg config.ConfYaml, q *queue.Queue) *gin.Engine {
 		m := metric.NewMetrics(func() int {
 			return q.Usage()
 		})
+		m.GetQueueLanes = q.Lanes
+		m.GetWorkers = q.Workers
+		m.GetBreakers = func() map[string]string {
+			return breakerStates(clients)
+		}
 		prometheus.MustRegister(m)
 	})
 
@@ -282,10 +496,11 @@ func routerEngine(cfg config.ConfYaml, q *queue.Queue) *gin.Engine {
 	r.Use(StatMiddleware())
 
 	r.GET(cfg.API.StatGoURI, api.GinHandler)
-	r.GET(cfg.API.StatAppURI, appStatusHandler(q))
+	r.GET(cfg.API.StatAppURI, appStatusHandler(q, clients))
 	r.GET(cfg.API.ConfigURI, configHandler(cfg))
 	r.GET(cfg.API.SysStatURI, sysStatsHandler())
 	r.POST(cfg.API.PushURI, pushHandler(cfg, q))
+	r.POST(cfg.API.ScrapeURI, scrapeHandler(cfg, q))
 	r.GET(cfg.API.MetricURI, metricsHandler)
 	r.GET(cfg.API.HealthURI, heartbeatHandler)
 	r.HEAD(cfg.API.HealthURI, heartbeatHandler)
@@ -294,108 +509,107 @@ func routerEngine(cfg config.ConfYaml, q *queue.Queue) *gin.Engine {
 	r.GET("/version", versionHandler)
 	r.GET("/", rootHandler)
 
-	r.GET("/scrape/1", scrapeOneHandler(cfg))
+	r.GET("/api/workers", getWorkersHandler(q))
+	r.PUT("/api/workers", resizeWorkersHandler(q))
+	r.GET("/api/jobs", listJobHandler)
+	r.GET("/api/jobs/:id", getJobHandler)
+	r.DELETE("/api/jobs/:id", cancelJobHandler)
+	r.GET("/api/dead-letters", listDeadLetterHandler)
+	r.DELETE("/api/dead-letters", purgeDeadLetterHandler)
+	r.GET("/api/dead-letters/:id", getDeadLetterHandler)
+	r.DELETE("/api/dead-letters/:id", deleteDeadLetterHandler)
+	r.POST("/api/dead-letters/:id/requeue", requeueDeadLetterHandler(cfg, q))
+	r.GET("/api/snapshots", listSnapshotHandler)
+	r.GET("/api/snapshots/latest", latestSnapshotHandler)
+	r.GET("/api/snapshots/:id", getSnapshotHandler)
+	r.GET("/api/funds/:id/history", fundHistoryHandler)
+
+	r.GET("/scrape/:source/:preset", scrapeSourceHandler(cfg, clients))
+	r.POST("/scrape/:source/:preset", scrapeSourceHandler(cfg, clients))
 
 	return r
 }
 
-func scrapeOneHandler(cfg config.ConfYaml) gin.HandlerFunc {
+// scrapeSourceHandler scrape the source with the preset of the path, the
+// query or the body override the preset filters.
+func scrapeSourceHandler(cfg config.ConfYaml, clients *scrape.ClientFactory) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		sourceName := c.Param("source")
+		src, srcCfg, err := scrape.ResolveSource(cfg, sourceName)
+		if err != nil {
+			abortWithError(c, http.StatusNotFound, err.Error())
+			return
+		}
 
-		baseUri := cfg.Source.BaseURI
-
-		form := url.Values{}
-		form.Add("firstopen", "yes")
-		form.Add("aumlowervalue", "500")
-		form.Add("aumlowercheck", "yes")
-		form.Add("aumbetweenlowvalue", "500")
-		form.Add("aumbetweenhighvalue", "2000")
-		form.Add("aumbetweencheck", "yes")
-		form.Add("aumgreatervalue", "2000")
-		form.Add("aumgreatercheck", "yes")
-		form.Add("availibility", "available")
-		form.Add("fundtype", "mm,fi,balance,equity")
-		form.Add("hiloselect", "1yr")
-		form.Add("performancetype", "nav")
-		form.Add("fundnonsyariah", "yes")
-		form.Add("fundsyariah", "yes")
-		form.Add("etfnonsyariah", "yes")
-		form.Add("etfsyariah", "yes")
-
-		req, _ := RequestInit(cfg, "GET", "source_json_for_favorite.php", nil, form)
-		body, err := RequestDo(req)
+		client, err := clients.Client(sourceName)
 		if err != nil {
-			logx.LogError.Error(err.Error())
-			panic(err)
+			abortWithError(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		baseUri := srcCfg.BaseURI
+
+		var override scrape.Override
+		if err := c.ShouldBindQuery(&override); err != nil {
+			abortWithError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		if c.Request.Method == http.MethodPost && c.Request.ContentLength != 0 {
+			if err := c.ShouldBindWith(&override, binding.JSON); err != nil {
+				abortWithError(c, http.StatusBadRequest, err.Error())
+				return
+			}
 		}
 
-		j := NewJSONReader(body)
-		var i gin.H
-		dec := json.NewDecoder(j)
-		err = dec.Decode(&i)
+		presetName := c.Param("preset")
+
+		preset, err := scrape.Resolve(cfg, presetName)
+		if err != nil {
+			abortWithError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		if preset, err = override.Apply(preset); err != nil {
+			abortWithError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		if err = scrape.Validate(preset); err != nil {
+			abortWithError(c, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		policy := scrape.NewRetryPolicy(srcCfg)
+		policy.Limiter = clients.Limiter(sourceName)
+		policy.Breaker = clients.Breaker(sourceName)
+
+		result, err := scrape.FetchSource(c.Request.Context(), client, src, srcCfg, preset, policy)
+		var open *scrape.BreakerOpenError
+		if errors.As(err, &open) {
+			c.Header("Retry-After", strconv.Itoa(int(time.Until(open.RetryAt).Seconds())+1))
+			abortWithError(c, http.StatusServiceUnavailable, err.Error())
+			return
+		}
 		if err != nil {
 			logx.LogError.Error(err.Error())
-			panic(err.Error())
-		} else {
-			fmt.Println(fmt.Sprintf("\nType : %T", i["aaData"]))
-
-			//list of funds
-			if aaData, ok := i["aaData"].([]interface{}); ok {
-				fmt.Println(fmt.Sprintf("Len : %v", len(aaData)))
-				for k, aDtRaw := range aaData {
-					fmt.Println(fmt.Sprintf("%v, %T", aaData[k], aaData[k]))
-
-					//Process each fund
-					if aDtVal, ok2 := aDtRaw.([]interface{}); ok2 {
-						for l := range aDtVal {
-							switch l {
-							case 0: //id
-							case 1: //id
-							case 2: //name
-							case 3: //manager
-							case 4: //type
-							case 5: //last_nav
-							case 6: //1d
-							case 7: //3d
-							case 8: //1m
-							case 9: //3m
-							case 10: //6m
-							case 11: //9m
-							case 12: //ytd
-							case 13: //1yr
-							case 14: //3yr
-							case 15: //5yr
-							case 16: //hi-lo
-							case 17: //sharpe
-							case 18: //drawdown
-							case 19: //dd_periode
-							case 20: //
-							case 21: //
-							case 22: //hist_risk
-							case 23: //aum
-							case 24: //
-							case 25: //
-							case 26: //
-							case 27: //
-							case 28: //
-							case 29: //
-							case 30: //
-							case 31: //
-							}
-							fmt.Println(fmt.Sprintf("%v, %T", aDtVal[l], aDtVal[l]))
-						}
-					}
-					break
-				}
-			}
-			fmt.Println("")
+			abortWithError(c, http.StatusBadGateway, err.Error())
+			return
+		}
+
+		run := storage.NewSnapshot(src.Name(), presetName, result.Funds)
+		if err := status.SnapshotStorage.SaveRun(run); err != nil {
+			logx.LogError.Error("can't save snapshot: " + err.Error())
 		}
 
 		c.JSON(http.StatusOK, gin.H{
-			"source":  "https://www.indopremier.com/programer_script/source_json_for_favorite.php?firstopen=yes&aumlowervalue=500&aumlowercheck=yes&aumbetweenlowvalue=500&aumbetweenhighvalue=2000&aumbetweencheck=yes&aumgreatervalue=2000&aumgreatercheck=yes&availibility=available&fundtype=mm,fi,balance,equity,&hiloselect=1yr&performancetype=nav&fundnonsyariah=yes&fundsyariah=yes&etfnonsyariah=yes&etfsyariah=yes",
+			"run_id":  run.ID,
+			"source":  result.URL,
+			"preset":  preset,
 			"version": GetVersion(),
 			"baseUri": baseUri,
-			"result":  i,
+			"total":   result.Total,
+			"funds":   result.Funds,
+			"errors":  result.Errors,
 		})
 	}
 }
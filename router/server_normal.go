@@ -13,12 +13,13 @@ import (
 
 	"github.com/natansdj/go_scrape/config"
 	"github.com/natansdj/go_scrape/logx"
+	"github.com/natansdj/go_scrape/scrape"
 
 	"golang.org/x/sync/errgroup"
 )
 
 // RunHTTPServer provide run http or https protocol.
-func RunHTTPServer(ctx context.Context, cfg config.ConfYaml, q *queue.Queue, s ...*http.Server) (err error) {
+func RunHTTPServer(ctx context.Context, cfg config.ConfYaml, q *queue.Queue, clients *scrape.ClientFactory, s ...*http.Server) (err error) {
 	var server *http.Server
 
 	if !cfg.Core.Enabled {
@@ -29,7 +30,7 @@ func RunHTTPServer(ctx context.Context, cfg config.ConfYaml, q *queue.Queue, s .
 	if len(s) == 0 {
 		server = &http.Server{
 			Addr:    cfg.Core.Address + ":" + cfg.Core.Port,
-			Handler: routerEngine(cfg, q),
+			Handler: routerEngine(cfg, q, clients),
 		}
 	} else {
 		server = s[0]
@@ -37,7 +38,7 @@ func RunHTTPServer(ctx context.Context, cfg config.ConfYaml, q *queue.Queue, s .
 
 	logx.LogAccess.Info("HTTPD server is running on " + cfg.Core.Port + " port.")
 	if cfg.Core.AutoTLS.Enabled {
-		return startServer(ctx, autoTLSServer(cfg, q), cfg)
+		return startServer(ctx, autoTLSServer(cfg, q, clients), cfg)
 	} else if cfg.Core.SSL {
 		conf := &tls.Config{
 			MinVersion: tls.VersionTLS10,
@@ -0,0 +1,93 @@
+package router
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/natansdj/go_scrape/logx"
+	"github.com/natansdj/go_scrape/status"
+	"github.com/natansdj/go_scrape/storage"
+
+	"github.com/gin-gonic/gin"
+)
+
+// defaultListLimit is used when the request has no limit query
+const defaultListLimit = 50
+
+func queryLimit(c *gin.Context) (int, bool) {
+	limit := defaultListLimit
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			abortWithError(c, http.StatusBadRequest, "limit must be a positive number")
+			return 0, false
+		}
+		limit = n
+	}
+	return limit, true
+}
+
+func snapshotError(c *gin.Context, err error) {
+	if err == storage.ErrSnapshotNotFound {
+		abortWithError(c, http.StatusNotFound, err.Error())
+		return
+	}
+
+	logx.LogError.Error("snapshot storage error: " + err.Error())
+	abortWithError(c, http.StatusInternalServerError, err.Error())
+}
+
+func listSnapshotHandler(c *gin.Context) {
+	limit, ok := queryLimit(c)
+	if !ok {
+		return
+	}
+
+	runs, err := status.SnapshotStorage.ListRuns(limit)
+	if err != nil {
+		snapshotError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"runs": runs,
+	})
+}
+
+func latestSnapshotHandler(c *gin.Context) {
+	run, err := status.SnapshotStorage.Latest()
+	if err != nil {
+		snapshotError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, run)
+}
+
+func getSnapshotHandler(c *gin.Context) {
+	run, err := status.SnapshotStorage.GetRun(c.Param("id"))
+	if err != nil {
+		snapshotError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, run)
+}
+
+func fundHistoryHandler(c *gin.Context) {
+	limit, ok := queryLimit(c)
+	if !ok {
+		return
+	}
+
+	points, err := status.SnapshotStorage.FundHistory(c.Param("id"), limit)
+	if err != nil {
+		snapshotError(c, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"fund_id": c.Param("id"),
+		"history": points,
+	})
+}
@@ -0,0 +1,51 @@
+package router
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/natansdj/go_scrape/logx"
+	"github.com/natansdj/go_scrape/queue"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+// resizeRequest is the body of the worker pool resize
+type resizeRequest struct {
+	Workers int `json:"workers" binding:"required"`
+}
+
+func getWorkersHandler(q *queue.Queue) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{
+			"workers": q.Workers(),
+		})
+	}
+}
+
+// resizeWorkersHandler grow or shrink the worker pool, the autoscaler keeps
+// resizing it within its bounds afterwards.
+func resizeWorkersHandler(q *queue.Queue) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var form resizeRequest
+		if err := c.ShouldBindWith(&form, binding.JSON); err != nil {
+			logx.LogAccess.Debug(err)
+			abortWithError(c, http.StatusBadRequest, "Missing workers field.")
+			return
+		}
+
+		if err := q.Resize(form.Workers); err != nil {
+			if errors.Is(err, queue.ErrInvalidWorkerNum) {
+				abortWithError(c, http.StatusBadRequest, err.Error())
+				return
+			}
+			abortWithError(c, http.StatusServiceUnavailable, err.Error())
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"workers": q.Workers(),
+		})
+	}
+}
@@ -0,0 +1,151 @@
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+
+	"github.com/natansdj/go_scrape/config"
+	"github.com/natansdj/go_scrape/go_scrape"
+	"github.com/natansdj/go_scrape/logx"
+	"github.com/natansdj/go_scrape/queue"
+	"github.com/natansdj/go_scrape/scrape"
+
+	"github.com/robfig/cron/v3"
+)
+
+// DateFormat is the format of the holiday list
+const DateFormat = "2006-01-02"
+
+// Scheduler enqueue scrape jobs on cron schedule
+type Scheduler struct {
+	cron     *cron.Cron
+	cfg      config.ConfYaml
+	q        *queue.Queue
+	loc      *time.Location
+	jitter   time.Duration
+	holidays map[string]bool
+	ctx      context.Context
+}
+
+// New returns a Scheduler with one cron entry per job in schedule.jobs, a
+// job is named <source>/<preset> or <preset> of the default source.
+func New(cfg config.ConfYaml, q *queue.Queue) (*Scheduler, error) {
+	loc, err := time.LoadLocation(cfg.Schedule.Timezone)
+	if err != nil {
+		return nil, fmt.Errorf("invalid schedule timezone: %v", err)
+	}
+
+	s := &Scheduler{
+		cfg:      cfg,
+		q:        q,
+		loc:      loc,
+		jitter:   time.Duration(cfg.Schedule.Jitter) * time.Second,
+		holidays: map[string]bool{},
+		cron:     cron.New(cron.WithLocation(loc)),
+		ctx:      context.Background(),
+	}
+
+	for _, day := range cfg.Schedule.Holidays {
+		if _, err := time.ParseInLocation(DateFormat, day, loc); err != nil {
+			return nil, fmt.Errorf("invalid schedule holiday %q: %v", day, err)
+		}
+		s.holidays[day] = true
+	}
+
+	for name, spec := range cfg.Schedule.Jobs {
+		source, preset := parseJob(name)
+		if _, _, err := scrape.ResolveSource(cfg, source); err != nil {
+			return nil, fmt.Errorf("schedule job %s: unknown source %s, must be one of %v", name, source, scrape.Sources())
+		}
+		if _, ok := cfg.Scrape.Presets[preset]; !ok {
+			return nil, fmt.Errorf("schedule job %s: unknown preset", name)
+		}
+
+		name := name
+		if _, err := s.cron.AddFunc(spec, func() { s.trigger(name) }); err != nil {
+			return nil, fmt.Errorf("schedule job %s: invalid cron expression %q: %v", name, spec, err)
+		}
+	}
+
+	return s, nil
+}
+
+// Run start the cron until ctx is done, then wait the running triggers
+func (s *Scheduler) Run(ctx context.Context) error {
+	s.ctx = ctx
+	s.cron.Start()
+	logx.LogAccess.Infof("scheduler is running with %d jobs in %s", len(s.cron.Entries()), s.loc)
+
+	<-ctx.Done()
+
+	logx.LogAccess.Info("stop the scheduler")
+	<-s.cron.Stop().Done()
+
+	return nil
+}
+
+// IsHoliday report whether the day of t is in the holiday list
+func (s *Scheduler) IsHoliday(t time.Time) bool {
+	return s.holidays[t.In(s.loc).Format(DateFormat)]
+}
+
+func (s *Scheduler) trigger(name string) {
+	now := time.Now().In(s.loc)
+	if s.IsHoliday(now) {
+		logx.LogAccess.Infof("skip scheduled scrape %s on holiday %s", name, now.Format(DateFormat))
+		return
+	}
+
+	if delay := s.delay(); delay > 0 {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+	}
+
+	job := s.job(name)
+	if err := go_scrape.EnqueueScrape(s.q, job); err != nil {
+		logx.LogError.Errorf("can't enqueue scheduled scrape %s: %v", name, err)
+		go_scrape.DeadLetter(job, err)
+		return
+	}
+	if job.Duplicate {
+		logx.LogAccess.Infof("scheduled scrape %s is already queued as job %s", name, job.ID)
+	}
+}
+
+// Enqueue add a scrape job to the queue, name is <source>/<preset> or
+// <preset> of the default source.
+func (s *Scheduler) Enqueue(name string) error {
+	return go_scrape.EnqueueScrape(s.q, s.job(name))
+}
+
+func (s *Scheduler) job(name string) *go_scrape.ScrapeJob {
+	source, preset := parseJob(name)
+	return &go_scrape.ScrapeJob{
+		Cfg:         s.cfg,
+		Source:      source,
+		Preset:      preset,
+		ScheduledAt: time.Now().In(s.loc),
+	}
+}
+
+// parseJob split the job name into its source and its preset
+func parseJob(name string) (source, preset string) {
+	if i := strings.Index(name, "/"); i >= 0 {
+		return name[:i], name[i+1:]
+	}
+	return scrape.SourceName, name
+}
+
+// delay returns a random jitter in [0, jitter)
+func (s *Scheduler) delay() time.Duration {
+	if s.jitter <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(s.jitter)))
+}
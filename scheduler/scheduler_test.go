@@ -0,0 +1,161 @@
+package scheduler
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/natansdj/go_scrape/config"
+	"github.com/natansdj/go_scrape/go_scrape"
+	"github.com/natansdj/go_scrape/queue"
+	"github.com/natansdj/go_scrape/queue/simple"
+	"github.com/natansdj/go_scrape/scrape"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// mockSource is a source only known by the scheduler tests
+type mockSource struct{}
+
+func (mockSource) Name() string { return "mock" }
+
+func (mockSource) NewRequest(ctx context.Context, cfg config.SourceAPI, preset config.SectionPreset) (*http.Request, error) {
+	return http.NewRequestWithContext(ctx, http.MethodGet, cfg.BaseURI, nil)
+}
+
+func (mockSource) Parse(ctx context.Context, cfg config.SourceAPI, body []byte) (*scrape.Result, error) {
+	return &scrape.Result{}, nil
+}
+
+func init() {
+	scrape.Register(mockSource{})
+}
+
+func testConfig() config.ConfYaml {
+	cfg := config.ConfYaml{}
+	cfg.Scrape.DefaultPreset = "default"
+	cfg.Scrape.Presets = map[string]config.SectionPreset{
+		"default": config.DefaultPreset(),
+	}
+	cfg.Schedule.Timezone = "Asia/Jakarta"
+	cfg.Schedule.Jobs = map[string]string{
+		"default": "30 16 * * 1-5",
+	}
+	return cfg
+}
+
+func TestNewErrors(t *testing.T) {
+	q := queue.NewQueue(simple.NewWorker(), 1)
+
+	cfg := testConfig()
+	cfg.Schedule.Timezone = "Mars/Olympus"
+	_, err := New(cfg, q)
+	assert.Error(t, err)
+
+	cfg = testConfig()
+	cfg.Schedule.Jobs["default"] = "every day"
+	_, err = New(cfg, q)
+	assert.Error(t, err)
+
+	cfg = testConfig()
+	cfg.Schedule.Jobs["missing"] = "* * * * *"
+	_, err = New(cfg, q)
+	assert.Error(t, err)
+
+	// the source is registered but has no config
+	cfg = testConfig()
+	cfg.Schedule.Jobs["mock/default"] = "* * * * *"
+	_, err = New(cfg, q)
+	assert.Error(t, err)
+
+	cfg = testConfig()
+	cfg.Schedule.Jobs["bloomberg/default"] = "* * * * *"
+	_, err = New(cfg, q)
+	assert.Error(t, err)
+
+	cfg = testConfig()
+	cfg.Schedule.Holidays = []string{"25-12-2026"}
+	_, err = New(cfg, q)
+	assert.Error(t, err)
+}
+
+func TestIsHoliday(t *testing.T) {
+	cfg := testConfig()
+	cfg.Schedule.Holidays = []string{"2026-12-25"}
+
+	s, err := New(cfg, queue.NewQueue(simple.NewWorker(), 1))
+	assert.NoError(t, err)
+
+	loc, _ := time.LoadLocation("Asia/Jakarta")
+	assert.True(t, s.IsHoliday(time.Date(2026, 12, 25, 8, 0, 0, 0, loc)))
+	// 2026-12-24 20:00 UTC is already 25 December in Jakarta
+	assert.True(t, s.IsHoliday(time.Date(2026, 12, 24, 20, 0, 0, 0, time.UTC)))
+	assert.False(t, s.IsHoliday(time.Date(2026, 12, 26, 8, 0, 0, 0, loc)))
+}
+
+func TestJitter(t *testing.T) {
+	cfg := testConfig()
+	s, err := New(cfg, queue.NewQueue(simple.NewWorker(), 1))
+	assert.NoError(t, err)
+	assert.Equal(t, time.Duration(0), s.delay())
+
+	cfg.Schedule.Jitter = 2
+	s, err = New(cfg, queue.NewQueue(simple.NewWorker(), 1))
+	assert.NoError(t, err)
+	for i := 0; i < 100; i++ {
+		d := s.delay()
+		assert.True(t, d >= 0 && d < 2*time.Second)
+	}
+}
+
+func TestRunEnqueueJobs(t *testing.T) {
+	cfg := testConfig()
+	cfg.Schedule.Jobs["default"] = "@every 1s"
+
+	// the queue is not started, jobs stay in the channel
+	w := simple.NewWorker()
+	s, err := New(cfg, queue.NewQueue(w, 1))
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- s.Run(ctx)
+	}()
+
+	// @every is aligned on whole seconds, the first run is in (0, 1s]
+	time.Sleep(1100 * time.Millisecond)
+	cancel()
+	assert.NoError(t, <-done)
+	assert.GreaterOrEqual(t, w.Usage(), 1)
+}
+
+func TestEnqueueSource(t *testing.T) {
+	cfg := testConfig()
+	cfg.Sources = map[string]config.SourceAPI{"mock": {}}
+	cfg.Schedule.Jobs["mock/default"] = "30 16 * * 1-5"
+
+	jobs := make(chan *go_scrape.ScrapeJob, 2)
+	w := simple.NewWorker(simple.WithRunFunc(func(msg queue.QueuedMessage) error {
+		jobs <- msg.(*go_scrape.ScrapeJob)
+		return nil
+	}))
+	q := queue.NewQueue(w, 1)
+	s, err := New(cfg, q)
+	assert.NoError(t, err)
+	q.Start()
+	defer q.Release(time.Second, func(queue.QueuedMessage) {})
+
+	// the job without source belongs to the default source
+	for name, source := range map[string]string{"mock/default": "mock", "default": scrape.SourceName} {
+		assert.NoError(t, s.Enqueue(name))
+		select {
+		case job := <-jobs:
+			assert.Equal(t, source, job.Source)
+			assert.Equal(t, "default", job.Preset)
+		case <-time.After(time.Second):
+			t.Fatal("job is not queued")
+		}
+	}
+}
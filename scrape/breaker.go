@@ -0,0 +1,253 @@
+package scrape
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/natansdj/go_scrape/config"
+	"github.com/natansdj/go_scrape/logx"
+)
+
+// BreakerState is the state of a circuit breaker
+type BreakerState string
+
+const (
+	// BreakerClosed let every request through
+	BreakerClosed BreakerState = "closed"
+	// BreakerOpen short-circuit every request until the open timeout
+	BreakerOpen BreakerState = "open"
+	// BreakerHalfOpen let the probe requests through, their outcome closes
+	// or opens the breaker again
+	BreakerHalfOpen BreakerState = "half-open"
+)
+
+// ErrBreakerOpen is the error of the short-circuited requests
+var ErrBreakerOpen = errors.New("circuit breaker is open")
+
+// BreakerOpenError is returned when the breaker of the source
+// short-circuits the request, RetryAt is when a request may go through.
+type BreakerOpenError struct {
+	Source  string
+	RetryAt time.Time
+}
+
+func (e *BreakerOpenError) Error() string {
+	return fmt.Sprintf("circuit breaker of %s is open until %s", e.Source, e.RetryAt.Format(time.RFC3339))
+}
+
+// Is report the error as ErrBreakerOpen
+func (e *BreakerOpenError) Is(target error) bool {
+	return target == ErrBreakerOpen
+}
+
+// Breaker stop the requests of a source which keeps failing. It opens
+// after consecutive failures or once the error rate of the window is
+// reached, then lets probe requests through after the open timeout.
+type Breaker struct {
+	name        string
+	failures    int
+	errorRate   float64
+	minRequests int
+	window      time.Duration
+	openTimeout time.Duration
+	maxProbes   int
+
+	mu          sync.Mutex
+	state       BreakerState
+	openedAt    time.Time
+	consecutive int
+	windowStart time.Time
+	requests    int
+	failed      int
+	probes      int
+}
+
+// NewBreaker returns the Breaker of the source config, nil when neither
+// threshold is set. Default is 10 requests before the error rate applies
+// in a window of 60 seconds, an open timeout of 30 seconds and a single
+// probe.
+func NewBreaker(name string, cfg config.SectionBreaker) *Breaker {
+	if cfg.Failures <= 0 && cfg.ErrorRate <= 0 {
+		return nil
+	}
+
+	b := &Breaker{
+		name:        name,
+		failures:    cfg.Failures,
+		errorRate:   cfg.ErrorRate,
+		minRequests: cfg.MinRequests,
+		window:      time.Duration(cfg.Window) * time.Second,
+		openTimeout: time.Duration(cfg.OpenTimeout) * time.Second,
+		maxProbes:   cfg.HalfOpenRequests,
+		state:       BreakerClosed,
+		windowStart: time.Now(),
+	}
+	if b.minRequests <= 0 {
+		b.minRequests = 10
+	}
+	if b.window <= 0 {
+		b.window = time.Minute
+	}
+	if b.openTimeout <= 0 {
+		b.openTimeout = 30 * time.Second
+	}
+	if b.maxProbes <= 0 {
+		b.maxProbes = 1
+	}
+
+	return b
+}
+
+// State returns the state of the breaker, a nil Breaker is closed
+func (b *Breaker) State() BreakerState {
+	if b == nil {
+		return BreakerClosed
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.current(time.Now())
+}
+
+// Allow returns a *BreakerOpenError when the request is short-circuited,
+// otherwise the outcome of the request must be reported with Success,
+// Failure or Release.
+func (b *Breaker) Allow() error {
+	if b == nil {
+		return nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	switch b.current(now) {
+	case BreakerOpen:
+		return &BreakerOpenError{Source: b.name, RetryAt: b.openedAt.Add(b.openTimeout)}
+	case BreakerHalfOpen:
+		if b.probes >= b.maxProbes {
+			return &BreakerOpenError{Source: b.name, RetryAt: now.Add(b.openTimeout)}
+		}
+		b.probes++
+	}
+
+	return nil
+}
+
+// Success report the request which got a response from the source
+func (b *Breaker) Success() {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	switch b.current(now) {
+	case BreakerHalfOpen:
+		b.release()
+		b.close(now)
+	case BreakerClosed:
+		b.consecutive = 0
+		b.count(now, false)
+	}
+}
+
+// Failure report the request which failed because of the source
+func (b *Breaker) Failure() {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	switch b.current(now) {
+	case BreakerHalfOpen:
+		b.release()
+		b.trip(now)
+	case BreakerClosed:
+		b.consecutive++
+		b.count(now, true)
+		if b.failures > 0 && b.consecutive >= b.failures {
+			b.trip(now)
+		} else if b.errorRate > 0 && b.requests >= b.minRequests && float64(b.failed)/float64(b.requests) >= b.errorRate {
+			b.trip(now)
+		}
+	}
+}
+
+// Release report the request stopped without outcome, like a cancelled
+// one
+func (b *Breaker) Release() {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.release()
+}
+
+// current returns the state at now, the open breaker is half-open once
+// the open timeout is elapsed. The caller holds the lock.
+func (b *Breaker) current(now time.Time) BreakerState {
+	if b.state == BreakerOpen && !now.Before(b.openedAt.Add(b.openTimeout)) {
+		b.state = BreakerHalfOpen
+		b.probes = 0
+		logx.LogAccess.Infof("circuit breaker of %s is half-open", b.name)
+	}
+	return b.state
+}
+
+// count the request in the window, a new window starts once it's elapsed
+func (b *Breaker) count(now time.Time, failed bool) {
+	if now.Sub(b.windowStart) >= b.window {
+		b.windowStart = now
+		b.requests, b.failed = 0, 0
+	}
+
+	b.requests++
+	if failed {
+		b.failed++
+	}
+}
+
+func (b *Breaker) release() {
+	if b.probes > 0 {
+		b.probes--
+	}
+}
+
+func (b *Breaker) trip(now time.Time) {
+	logx.LogError.Errorf("circuit breaker of %s is open for %s", b.name, b.openTimeout)
+	b.state = BreakerOpen
+	b.openedAt = now
+	b.reset(now)
+}
+
+func (b *Breaker) close(now time.Time) {
+	logx.LogAccess.Infof("circuit breaker of %s is closed", b.name)
+	b.state = BreakerClosed
+	b.reset(now)
+}
+
+func (b *Breaker) reset(now time.Time) {
+	b.consecutive = 0
+	b.windowStart = now
+	b.requests, b.failed = 0, 0
+}
+
+// upstreamFailed report whether the request failed because of the source:
+// network errors, timeouts, 429 and 5xx responses.
+func upstreamFailed(err error) bool {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.Code == 429 || statusErr.Code >= 500
+	}
+	return err != nil
+}
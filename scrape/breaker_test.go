@@ -0,0 +1,109 @@
+package scrape
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/natansdj/go_scrape/config"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBreakerFailures(t *testing.T) {
+	assert.Nil(t, NewBreaker("test", config.SectionBreaker{}))
+
+	// the nil breaker is always closed
+	var nilBreaker *Breaker
+	assert.NoError(t, nilBreaker.Allow())
+	assert.Equal(t, BreakerClosed, nilBreaker.State())
+
+	b := NewBreaker("test", config.SectionBreaker{Failures: 2})
+	assert.NoError(t, b.Allow())
+	b.Failure()
+	assert.NoError(t, b.Allow())
+	b.Success()
+	b.Failure()
+	assert.Equal(t, BreakerClosed, b.State())
+	b.Failure()
+	assert.Equal(t, BreakerOpen, b.State())
+
+	err := b.Allow()
+	assert.True(t, errors.Is(err, ErrBreakerOpen))
+	var open *BreakerOpenError
+	assert.True(t, errors.As(err, &open))
+	assert.Equal(t, "test", open.Source)
+	assert.WithinDuration(t, time.Now().Add(30*time.Second), open.RetryAt, time.Second)
+}
+
+func TestBreakerErrorRate(t *testing.T) {
+	b := NewBreaker("test", config.SectionBreaker{ErrorRate: 0.5, MinRequests: 4})
+
+	b.Failure()
+	b.Failure()
+	b.Success()
+	assert.Equal(t, BreakerClosed, b.State())
+
+	// 3 failures of 4 requests
+	b.Failure()
+	assert.Equal(t, BreakerOpen, b.State())
+}
+
+func TestBreakerHalfOpen(t *testing.T) {
+	b := NewBreaker("test", config.SectionBreaker{Failures: 1})
+	b.openTimeout = 10 * time.Millisecond
+
+	b.Failure()
+	assert.Equal(t, BreakerOpen, b.State())
+
+	time.Sleep(20 * time.Millisecond)
+	assert.Equal(t, BreakerHalfOpen, b.State())
+
+	// a single probe at once
+	assert.NoError(t, b.Allow())
+	assert.True(t, errors.Is(b.Allow(), ErrBreakerOpen))
+
+	// the failed probe opens the breaker again
+	b.Failure()
+	assert.Equal(t, BreakerOpen, b.State())
+
+	time.Sleep(20 * time.Millisecond)
+	assert.NoError(t, b.Allow())
+	b.Release()
+	assert.NoError(t, b.Allow())
+	b.Success()
+	assert.Equal(t, BreakerClosed, b.State())
+}
+
+func TestRequestDoRetryBreaker(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	cfg := testConfig()
+	cfg.Source.BaseURI = srv.URL + "/"
+	client, err := NewClient("test", cfg.Source)
+	assert.NoError(t, err)
+
+	p := testPolicy()
+	p.Breaker = NewBreaker("test", config.SectionBreaker{Failures: 2})
+
+	req, err := RequestInit(cfg, http.MethodGet, FavoriteEndpoint, nil)
+	assert.NoError(t, err)
+
+	// the breaker opens before the last attempt
+	_, err = RequestDoRetry(client, req, p)
+	assert.True(t, errors.Is(err, ErrBreakerOpen))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+
+	// the 4xx response is not a failure of the source
+	assert.False(t, upstreamFailed(&StatusError{Code: http.StatusNotFound}))
+	assert.True(t, upstreamFailed(&StatusError{Code: http.StatusTooManyRequests}))
+	assert.True(t, upstreamFailed(errors.New("connection reset")))
+}
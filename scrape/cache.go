@@ -0,0 +1,181 @@
+package scrape
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/natansdj/go_scrape/config"
+	"github.com/natansdj/go_scrape/logx"
+	"github.com/natansdj/go_scrape/status"
+	"github.com/natansdj/go_scrape/storage"
+)
+
+// DefaultStaleTTL is how long a stale response is kept for conditional
+// requests when cache.stale_ttl is not set.
+const DefaultStaleTTL = time.Hour
+
+// Cache keep the upstream responses in the stat storage by method, url and
+// body. A fresh response is served without request, a stale one is
+// revalidated with If-None-Match or If-Modified-Since.
+type Cache struct {
+	ttl      time.Duration
+	staleTTL time.Duration
+}
+
+// NewCache returns the Cache of the source config, nil when the cache is
+// disabled.
+func NewCache(cfg config.SectionCache) *Cache {
+	if cfg.TTL <= 0 {
+		return nil
+	}
+
+	c := &Cache{
+		ttl:      time.Duration(cfg.TTL) * time.Second,
+		staleTTL: time.Duration(cfg.StaleTTL) * time.Second,
+	}
+	if cfg.StaleTTL == 0 {
+		c.staleTTL = DefaultStaleTTL
+	}
+	if c.staleTTL < 0 {
+		c.staleTTL = 0
+	}
+
+	return c
+}
+
+// CacheKey returns the cache key of the request, the body is read again
+// through GetBody so the request can still be sent.
+func CacheKey(req *http.Request) (string, error) {
+	h := sha256.New()
+	h.Write([]byte(req.Method))
+	h.Write([]byte{0})
+	h.Write([]byte(req.URL.String()))
+	h.Write([]byte{0})
+
+	if req.Body != nil && req.Body != http.NoBody {
+		if req.GetBody == nil {
+			return "", errors.New("request body can't be read again")
+		}
+		body, err := req.GetBody()
+		if err != nil {
+			return "", err
+		}
+		data, err := ioutil.ReadAll(body)
+		body.Close()
+		if err != nil {
+			return "", err
+		}
+		h.Write(data)
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// lookup returns the key of the request and its cached response, the key
+// is empty when the request can't be cached. A nil Cache returns nothing.
+func (c *Cache) lookup(req *http.Request) (string, *storage.Response) {
+	if c == nil || status.StatStorage == nil {
+		return "", nil
+	}
+
+	key, err := CacheKey(req)
+	if err != nil {
+		logx.LogError.Error("cache key: ", err)
+		return "", nil
+	}
+
+	resp, err := status.StatStorage.GetResponse(key)
+	if err != nil {
+		if !errors.Is(err, storage.ErrResponseNotFound) {
+			logx.LogError.Error("cache lookup: ", err)
+		}
+		return key, nil
+	}
+
+	return key, resp
+}
+
+// fresh report whether the response is served without request
+func (c *Cache) fresh(resp *storage.Response) bool {
+	return c != nil && resp != nil && time.Since(resp.StoredAt) < c.ttl
+}
+
+// conditional returns a copy of req asking for the cached response only
+// when it was modified, the request is unchanged without validators or when
+// it's not a GET.
+func (c *Cache) conditional(req *http.Request, resp *storage.Response) *http.Request {
+	if c == nil || resp == nil || req.Method != http.MethodGet {
+		return req
+	}
+	if resp.ETag == "" && resp.LastModified == "" {
+		return req
+	}
+
+	req = req.Clone(req.Context())
+	if resp.ETag != "" {
+		req.Header.Set("If-None-Match", resp.ETag)
+	}
+	if resp.LastModified != "" {
+		req.Header.Set("If-Modified-Since", resp.LastModified)
+	}
+
+	return req
+}
+
+// store keep the upstream response under the key
+func (c *Cache) store(key string, body []byte, header http.Header) {
+	if c == nil || key == "" || status.StatStorage == nil {
+		return
+	}
+
+	c.save(key, &storage.Response{
+		Body:         body,
+		ETag:         header.Get("ETag"),
+		LastModified: header.Get("Last-Modified"),
+	})
+}
+
+// refresh make the revalidated response fresh again, the validators of the
+// 304 response replace the cached ones.
+func (c *Cache) refresh(key string, resp *storage.Response, header http.Header) {
+	if c == nil || key == "" || status.StatStorage == nil {
+		return
+	}
+
+	if etag := header.Get("ETag"); etag != "" {
+		resp.ETag = etag
+	}
+	if modified := header.Get("Last-Modified"); modified != "" {
+		resp.LastModified = modified
+	}
+	c.save(key, resp)
+}
+
+func (c *Cache) save(key string, resp *storage.Response) {
+	resp.StoredAt = time.Now()
+	if err := status.StatStorage.SaveResponse(key, resp, c.ttl+c.staleTTL); err != nil {
+		logx.LogError.Error("cache save: ", err)
+	}
+}
+
+// countCache record the cache hit or miss when the stat storage is set
+func countCache(hit bool) {
+	switch {
+	case status.StatStorage == nil:
+	case hit:
+		status.StatStorage.AddCacheHit(1)
+	default:
+		status.StatStorage.AddCacheMiss(1)
+	}
+}
+
+// notModified report whether the upstream answered the conditional request
+// with 304 Not Modified.
+func notModified(err error) bool {
+	var statusErr *StatusError
+	return errors.As(err, &statusErr) && statusErr.Code == http.StatusNotModified
+}
@@ -0,0 +1,110 @@
+package scrape
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/natansdj/go_scrape/config"
+	"github.com/natansdj/go_scrape/status"
+	"github.com/natansdj/go_scrape/storage/memory"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewCache(t *testing.T) {
+	assert.Nil(t, NewCache(config.SectionCache{}))
+	assert.Equal(t, DefaultStaleTTL, NewCache(config.SectionCache{TTL: 60}).staleTTL)
+	assert.Zero(t, NewCache(config.SectionCache{TTL: 60, StaleTTL: -1}).staleTTL)
+}
+
+func TestCacheKey(t *testing.T) {
+	get, _ := http.NewRequest(http.MethodGet, "http://example.com/funds", nil)
+	first, _ := http.NewRequest(http.MethodPost, "http://example.com/funds", strings.NewReader("a=1"))
+	second, _ := http.NewRequest(http.MethodPost, "http://example.com/funds", strings.NewReader("a=2"))
+
+	keys := map[string]bool{}
+	for _, req := range []*http.Request{get, first, second} {
+		key, err := CacheKey(req)
+		assert.NoError(t, err)
+		keys[key] = true
+	}
+	assert.Len(t, keys, 3)
+
+	// the body is still sent
+	again, err := CacheKey(first)
+	assert.NoError(t, err)
+	assert.True(t, keys[again])
+}
+
+func TestRequestDoCache(t *testing.T) {
+	status.StatStorage = memory.New()
+
+	var calls int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		_, _ = w.Write([]byte("funds"))
+	}))
+	defer ts.Close()
+
+	policy := RetryPolicy{Cache: NewCache(config.SectionCache{TTL: 60})}
+	do := func() []byte {
+		req, _ := http.NewRequestWithContext(context.Background(), http.MethodGet, ts.URL, nil)
+		body, err := RequestDoRetry(ts.Client(), req, policy)
+		assert.NoError(t, err)
+		return body
+	}
+
+	assert.Equal(t, "funds", string(do()))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	assert.Equal(t, int64(1), status.StatStorage.GetCacheMiss())
+
+	// the fresh response is served without request
+	assert.Equal(t, "funds", string(do()))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	assert.Equal(t, int64(1), status.StatStorage.GetCacheHit())
+
+	// the stale response is revalidated
+	policy.Cache.ttl = time.Nanosecond
+	assert.Equal(t, "funds", string(do()))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+	assert.Equal(t, int64(2), status.StatStorage.GetCacheHit())
+	assert.Equal(t, int64(1), status.StatStorage.GetCacheMiss())
+}
+
+func TestRequestDoCacheWithoutStorage(t *testing.T) {
+	storage := status.StatStorage
+	hits, misses := storage.GetCacheHit(), storage.GetCacheMiss()
+	status.StatStorage = nil
+	defer func() {
+		status.StatStorage = storage
+	}()
+
+	var calls int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		_, _ = w.Write([]byte("funds"))
+	}))
+	defer ts.Close()
+
+	// the cache is skipped without stat storage, every request is sent
+	policy := RetryPolicy{Cache: NewCache(config.SectionCache{TTL: 60})}
+	for i := 0; i < 2; i++ {
+		req, _ := http.NewRequest(http.MethodGet, ts.URL, nil)
+		body, err := RequestDoRetry(ts.Client(), req, policy)
+		assert.NoError(t, err)
+		assert.Equal(t, "funds", string(body))
+	}
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+	assert.Equal(t, hits, storage.GetCacheHit())
+	assert.Equal(t, misses, storage.GetCacheMiss())
+}
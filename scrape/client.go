@@ -0,0 +1,320 @@
+package scrape
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/natansdj/go_scrape/config"
+	"github.com/natansdj/go_scrape/logx"
+)
+
+// ErrTLSVersion is returned when the tls min version is not supported
+var ErrTLSVersion = errors.New("unknown tls min version")
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// ClientFactory build an isolated http client, a rate limiter and a
+// circuit breaker per source, they are kept for the next requests of the
+// source.
+type ClientFactory struct {
+	cfg config.ConfYaml
+
+	mu       sync.Mutex
+	clients  map[string]*http.Client
+	limiters map[string]*Limiter
+	breakers map[string]*Breaker
+}
+
+// defaultClients is the factory shared by the callers without their own
+var defaultClients struct {
+	sync.Mutex
+	factory *ClientFactory
+}
+
+// DefaultClientFactory returns the factory shared by the callers without
+// their own, so they keep the connections, the rate limits and the breakers
+// of the sources. It's built from cfg on the first call unless it's set by
+// SetDefaultClientFactory.
+func DefaultClientFactory(cfg config.ConfYaml) *ClientFactory {
+	defaultClients.Lock()
+	defer defaultClients.Unlock()
+
+	if defaultClients.factory == nil {
+		defaultClients.factory = NewClientFactory(cfg)
+	}
+	return defaultClients.factory
+}
+
+// SetDefaultClientFactory replace the factory returned by
+// DefaultClientFactory
+func SetDefaultClientFactory(f *ClientFactory) {
+	defaultClients.Lock()
+	defer defaultClients.Unlock()
+
+	defaultClients.factory = f
+}
+
+// NewClientFactory returns a ClientFactory of the sources config, the
+// sources without proxy use core.http_proxy.
+func NewClientFactory(cfg config.ConfYaml) *ClientFactory {
+	return &ClientFactory{
+		cfg:      cfg,
+		clients:  map[string]*http.Client{},
+		limiters: map[string]*Limiter{},
+		breakers: map[string]*Breaker{},
+	}
+}
+
+// Client returns the http client of the source
+func (f *ClientFactory) Client(name string) (*http.Client, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.build(name); err != nil {
+		return nil, err
+	}
+	return f.clients[name], nil
+}
+
+// Limiter returns the rate limiter of the source, nil when the source is
+// not limited or has no config.
+func (f *ClientFactory) Limiter(name string) *Limiter {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.build(name); err != nil {
+		return nil
+	}
+	return f.limiters[name]
+}
+
+// Breaker returns the circuit breaker of the source, nil when the source
+// has no breaker or no config.
+func (f *ClientFactory) Breaker(name string) *Breaker {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := f.build(name); err != nil {
+		return nil
+	}
+	return f.breakers[name]
+}
+
+// Breakers returns the state of the breaker of every source built so far
+func (f *ClientFactory) Breakers() map[string]BreakerState {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	states := map[string]BreakerState{}
+	for name, b := range f.breakers {
+		if b != nil {
+			states[name] = b.State()
+		}
+	}
+	return states
+}
+
+// build the client, the limiter and the breaker of the source once
+func (f *ClientFactory) build(name string) error {
+	if _, ok := f.clients[name]; ok {
+		return nil
+	}
+
+	srcCfg, ok := f.cfg.SourceConfig(name)
+	if !ok {
+		return fmt.Errorf("%w: %s has no config", ErrUnknownSource, name)
+	}
+	if srcCfg.Proxy == "" {
+		srcCfg.Proxy = f.cfg.Core.HTTPProxy
+	}
+
+	c, err := NewClient(name, srcCfg)
+	if err != nil {
+		return fmt.Errorf("can't build the http client of %s: %w", name, err)
+	}
+	f.clients[name] = c
+	f.limiters[name] = NewLimiter(name, srcCfg.RateLimit)
+	f.breakers[name] = NewBreaker(name, srcCfg.Breaker)
+
+	return nil
+}
+
+// CloseIdleConnections close the idle connections of every client
+func (f *ClientFactory) CloseIdleConnections() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, c := range f.clients {
+		c.CloseIdleConnections()
+	}
+}
+
+// NewClient returns the http client of the source config with its own
+// transport and cookie jar.
+func NewClient(name string, cfg config.SourceAPI) (*http.Client, error) {
+	logx.LogAccess.Info("Init http.Client && http.Transport of " + name)
+
+	transport, err := NewTransport(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	// every attempt has the request timeout of the retry policy, the
+	// client timeout is only set when configured
+	c := &http.Client{
+		Transport: &instrumentedTransport{source: name, next: transport},
+		Timeout:   time.Duration(cfg.HttpTimeout) * time.Second,
+	}
+
+	if cfg.CookieJar {
+		if c.Jar, err = cookiejar.New(nil); err != nil {
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+// NewTransport returns the transport of the source config, unset values
+// keep the default.
+func NewTransport(cfg config.SourceAPI) (*http.Transport, error) {
+	ctxTimeout := cfg.CtxTimeout
+	if ctxTimeout == 0 {
+		ctxTimeout = 30
+	}
+	ctxKeepAlive := cfg.CtxKeepAlive
+	if ctxKeepAlive == 0 {
+		ctxKeepAlive = 100
+	}
+	maxIdleConnsPerHost := cfg.MaxIdleConnsPerHost
+	if maxIdleConnsPerHost == 0 {
+		maxIdleConnsPerHost = 100
+	}
+	maxIdleConns := cfg.MaxIdleConns
+	if maxIdleConns == 0 {
+		maxIdleConns = 100
+	}
+	idleConnTimeout := cfg.IdleConnTimeout
+	if idleConnTimeout == 0 {
+		idleConnTimeout = 90
+	}
+	tlsHandshakeTimeout := cfg.TLSHandshakeTimeout
+	if tlsHandshakeTimeout == 0 {
+		tlsHandshakeTimeout = 10
+	}
+	expectContinueTimeout := cfg.ExpectContinueTimeout
+	if expectContinueTimeout == 0 {
+		expectContinueTimeout = 3
+	}
+
+	proxy := http.ProxyFromEnvironment
+	if cfg.Proxy != "" {
+		proxyURL, err := url.ParseRequestURI(cfg.Proxy)
+		if err != nil {
+			return nil, err
+		}
+		proxy = http.ProxyURL(proxyURL)
+	}
+
+	tlsConfig, err := newTLSConfig(cfg.TLS)
+	if err != nil {
+		return nil, err
+	}
+
+	return &http.Transport{
+		Proxy: proxy,
+		DialContext: (&net.Dialer{
+			Timeout:   time.Duration(ctxTimeout) * time.Second,
+			KeepAlive: time.Duration(ctxKeepAlive) * time.Second,
+		}).DialContext,
+		TLSClientConfig:       tlsConfig,
+		ForceAttemptHTTP2:     true,
+		MaxConnsPerHost:       cfg.MaxConnsPerHost,
+		MaxIdleConnsPerHost:   maxIdleConnsPerHost,
+		MaxIdleConns:          maxIdleConns,
+		IdleConnTimeout:       time.Duration(idleConnTimeout) * time.Second,
+		TLSHandshakeTimeout:   time.Duration(tlsHandshakeTimeout) * time.Second,
+		ExpectContinueTimeout: time.Duration(expectContinueTimeout) * time.Second,
+	}, nil
+}
+
+// newTLSConfig returns the tls config with the custom CA added to the
+// system pool and the client certificate.
+func newTLSConfig(cfg config.SectionSourceTLS) (*tls.Config, error) {
+	c := &tls.Config{
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+	}
+
+	if cfg.MinVersion != "" {
+		v, ok := tlsVersions[cfg.MinVersion]
+		if !ok {
+			return nil, fmt.Errorf("%w: %s", ErrTLSVersion, cfg.MinVersion)
+		}
+		c.MinVersion = v
+	}
+
+	if cfg.CAPath != "" {
+		pem, err := ioutil.ReadFile(cfg.CAPath)
+		if err != nil {
+			return nil, err
+		}
+
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in %s", cfg.CAPath)
+		}
+		c.RootCAs = pool
+	}
+
+	if cfg.CertPath != "" || cfg.KeyPath != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertPath, cfg.KeyPath)
+		if err != nil {
+			return nil, err
+		}
+		c.Certificates = []tls.Certificate{cert}
+	}
+
+	return c, nil
+}
+
+// instrumentedTransport log every round trip of the source
+type instrumentedTransport struct {
+	source string
+	next   http.RoundTripper
+}
+
+func (t *instrumentedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	res, err := t.next.RoundTrip(req)
+	if err != nil {
+		logx.LogAccess.Debugf("%s %s %s failed after %s: %v", t.source, req.Method, req.URL, time.Since(start), err)
+		return nil, err
+	}
+
+	logx.LogAccess.Debugf("%s %s %s responded %d in %s", t.source, req.Method, req.URL, res.StatusCode, time.Since(start))
+	return res, nil
+}
+
+// CloseIdleConnections close the idle connections of the transport
+func (t *instrumentedTransport) CloseIdleConnections() {
+	if c, ok := t.next.(interface{ CloseIdleConnections() }); ok {
+		c.CloseIdleConnections()
+	}
+}
@@ -0,0 +1,88 @@
+package scrape
+
+import (
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/natansdj/go_scrape/config"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientFactory(t *testing.T) {
+	cfg := testConfig()
+	cfg.Core.HTTPProxy = "http://proxy.local:3128"
+	cfg.Sources = map[string]config.SourceAPI{
+		"lines": {Proxy: "http://lines.local:8080", CookieJar: true},
+	}
+	clients := NewClientFactory(cfg)
+
+	c, err := clients.Client(SourceName)
+	assert.NoError(t, err)
+	same, err := clients.Client(SourceName)
+	assert.NoError(t, err)
+	assert.True(t, c == same)
+	assert.Nil(t, c.Jar)
+
+	other, err := clients.Client("lines")
+	assert.NoError(t, err)
+	assert.False(t, c == other)
+	assert.NotNil(t, other.Jar)
+
+	// the source proxy wins over core.http_proxy
+	req, _ := http.NewRequest(http.MethodGet, "http://example.com", nil)
+	proxy, err := c.Transport.(*instrumentedTransport).next.(*http.Transport).Proxy(req)
+	assert.NoError(t, err)
+	assert.Equal(t, "proxy.local:3128", proxy.Host)
+	proxy, err = other.Transport.(*instrumentedTransport).next.(*http.Transport).Proxy(req)
+	assert.NoError(t, err)
+	assert.Equal(t, "lines.local:8080", proxy.Host)
+
+	_, err = clients.Client("missing")
+	assert.True(t, errors.Is(err, ErrUnknownSource))
+}
+
+func TestDefaultClientFactory(t *testing.T) {
+	defer SetDefaultClientFactory(nil)
+
+	// the callers without factory share the first one
+	SetDefaultClientFactory(nil)
+	first := DefaultClientFactory(testConfig())
+	assert.True(t, first == DefaultClientFactory(testConfig()))
+
+	clients := NewClientFactory(testConfig())
+	SetDefaultClientFactory(clients)
+	assert.True(t, clients == DefaultClientFactory(testConfig()))
+}
+
+func TestNewClientTLS(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`ok`))
+	}))
+	defer srv.Close()
+
+	_, err := NewClient("test", config.SourceAPI{TLS: config.SectionSourceTLS{MinVersion: "2.0"}})
+	assert.True(t, errors.Is(err, ErrTLSVersion))
+
+	// the test server certificate is not trusted without its CA
+	c, err := NewClient("test", config.SourceAPI{})
+	assert.NoError(t, err)
+	_, err = c.Get(srv.URL)
+	assert.Error(t, err)
+
+	caPath := filepath.Join(t.TempDir(), "ca.pem")
+	ca := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw})
+	assert.NoError(t, ioutil.WriteFile(caPath, ca, 0o600))
+
+	c, err = NewClient("test", config.SourceAPI{TLS: config.SectionSourceTLS{CAPath: caPath, MinVersion: "1.2"}})
+	assert.NoError(t, err)
+	res, err := c.Get(srv.URL)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	ResponseClose(res.Body)
+}
@@ -0,0 +1,65 @@
+package scrape
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/natansdj/go_scrape/config"
+	"github.com/natansdj/go_scrape/logx"
+	"github.com/natansdj/go_scrape/parser"
+)
+
+// FavoriteEndpoint returns the fund list as aaData rows
+const FavoriteEndpoint = "source_json_for_favorite.php"
+
+func init() {
+	Register(indopremier{})
+}
+
+// indopremier request the fund list from the favorite endpoint
+type indopremier struct{}
+
+var _ Source = indopremier{}
+
+func (indopremier) Name() string {
+	return config.DefaultSource
+}
+
+func (indopremier) NewRequest(ctx context.Context, cfg config.SourceAPI, preset config.SectionPreset) (*http.Request, error) {
+	req, err := SourceRequest(cfg, http.MethodGet, FavoriteEndpoint, nil, Values(preset))
+	if err != nil {
+		return nil, err
+	}
+
+	return req.WithContext(ctx), nil
+}
+
+func (indopremier) Parse(ctx context.Context, cfg config.SourceAPI, body []byte) (*Result, error) {
+	aumUnit, err := parser.ParseUnit(cfg.AUMUnit)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := parser.Decode(NewJSONReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	p := parser.New(
+		parser.WithStrict(cfg.Strict),
+		parser.WithAUMUnit(aumUnit),
+	)
+	funds, rowErrs, err := p.ParseContext(ctx, rows)
+	if err != nil {
+		return nil, err
+	}
+	for _, rowErr := range rowErrs {
+		logx.LogError.Warn(rowErr.Error())
+	}
+
+	return &Result{
+		Total:  len(rows),
+		Funds:  funds,
+		Errors: rowErrs,
+	}, nil
+}
@@ -0,0 +1,35 @@
+package scrape
+
+import (
+	"sync"
+	"time"
+)
+
+// latencyWeight is the weight of the last response in the average
+const latencyWeight = 0.2
+
+// upstream keep the moving average of the upstream response time
+var upstream struct {
+	sync.Mutex
+	average time.Duration
+}
+
+// ObserveLatency add the response time of an attempt to the average
+func ObserveLatency(d time.Duration) {
+	upstream.Lock()
+	defer upstream.Unlock()
+
+	if upstream.average == 0 {
+		upstream.average = d
+		return
+	}
+	upstream.average += time.Duration(latencyWeight * float64(d-upstream.average))
+}
+
+// Latency returns the moving average of the upstream response time, zero
+// before the first response.
+func Latency() time.Duration {
+	upstream.Lock()
+	defer upstream.Unlock()
+	return upstream.average
+}
@@ -0,0 +1,18 @@
+package scrape
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestObserveLatency(t *testing.T) {
+	upstream.average = 0
+
+	ObserveLatency(100 * time.Millisecond)
+	assert.Equal(t, 100*time.Millisecond, Latency())
+
+	ObserveLatency(600 * time.Millisecond)
+	assert.Equal(t, 200*time.Millisecond, Latency())
+}
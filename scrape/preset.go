@@ -0,0 +1,238 @@
+package scrape
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/natansdj/go_scrape/config"
+)
+
+const (
+	// SyariahAll include syariah and non syariah products
+	SyariahAll = "all"
+	// SyariahOnly include only syariah products
+	SyariahOnly = "only"
+	// SyariahExclude include only non syariah products
+	SyariahExclude = "exclude"
+)
+
+var (
+	// FundTypes supported by the source
+	FundTypes = []string{"mm", "fi", "balance", "equity"}
+	// HiLoWindows supported by the source
+	HiLoWindows = []string{"1yr", "3yr", "5yr"}
+	// PerformanceTypes supported by the source
+	PerformanceTypes = []string{"nav"}
+)
+
+// ErrUnknownPreset is returned when the preset name is not configured
+var ErrUnknownPreset = errors.New("unknown preset")
+
+// ValidationError is returned when a preset can't be sent to the source
+type ValidationError struct {
+	Field string
+	Msg   string
+}
+
+func (e *ValidationError) Error() string {
+	return e.Field + ": " + e.Msg
+}
+
+// Override is optional preset values from http request, nil value keep
+// the preset value. FundTypes accept repeated or comma separated values.
+type Override struct {
+	FundTypes       []string `json:"fund_types" form:"fund_types"`
+	HiLoSelect      *string  `json:"hiloselect" form:"hiloselect"`
+	PerformanceType *string  `json:"performance_type" form:"performance_type"`
+	Availability    *string  `json:"availability" form:"availability"`
+	AUMLowerCheck   *bool    `json:"aum_lower_check" form:"aum_lower_check"`
+	AUMLowerValue   *int64   `json:"aum_lower_value" form:"aum_lower_value"`
+	AUMBetweenCheck *bool    `json:"aum_between_check" form:"aum_between_check"`
+	AUMBetweenLow   *int64   `json:"aum_between_low" form:"aum_between_low"`
+	AUMBetweenHigh  *int64   `json:"aum_between_high" form:"aum_between_high"`
+	AUMGreaterCheck *bool    `json:"aum_greater_check" form:"aum_greater_check"`
+	AUMGreaterValue *int64   `json:"aum_greater_value" form:"aum_greater_value"`
+	FundNonSyariah  *bool    `json:"fund_non_syariah" form:"fund_non_syariah"`
+	FundSyariah     *bool    `json:"fund_syariah" form:"fund_syariah"`
+	ETFNonSyariah   *bool    `json:"etf_non_syariah" form:"etf_non_syariah"`
+	ETFSyariah      *bool    `json:"etf_syariah" form:"etf_syariah"`
+	// Syariah is a shortcut for the four syariah flags: all, only or exclude
+	Syariah *string `json:"syariah" form:"syariah"`
+}
+
+// Resolve returns the preset by name, empty name returns the default preset.
+func Resolve(cfg config.ConfYaml, name string) (config.SectionPreset, error) {
+	if name == "" {
+		name = cfg.Scrape.DefaultPreset
+	}
+
+	p, ok := cfg.Scrape.Presets[strings.ToLower(name)]
+	if !ok {
+		return p, fmt.Errorf("%w: %s", ErrUnknownPreset, name)
+	}
+
+	// copy the slice, overrides must not change the config
+	p.FundTypes = append([]string(nil), p.FundTypes...)
+
+	return p, nil
+}
+
+// Apply returns a copy of the preset with the override values.
+func (o Override) Apply(p config.SectionPreset) (config.SectionPreset, error) {
+	if len(o.FundTypes) > 0 {
+		p.FundTypes = splitList(o.FundTypes)
+	}
+
+	setString(&p.HiLoSelect, o.HiLoSelect)
+	setString(&p.PerformanceType, o.PerformanceType)
+	setString(&p.Availability, o.Availability)
+	setBool(&p.AUMLowerCheck, o.AUMLowerCheck)
+	setInt(&p.AUMLowerValue, o.AUMLowerValue)
+	setBool(&p.AUMBetweenCheck, o.AUMBetweenCheck)
+	setInt(&p.AUMBetweenLow, o.AUMBetweenLow)
+	setInt(&p.AUMBetweenHigh, o.AUMBetweenHigh)
+	setBool(&p.AUMGreaterCheck, o.AUMGreaterCheck)
+	setInt(&p.AUMGreaterValue, o.AUMGreaterValue)
+
+	if o.Syariah != nil {
+		switch strings.ToLower(*o.Syariah) {
+		case SyariahAll:
+			p.FundSyariah, p.FundNonSyariah = true, true
+			p.ETFSyariah, p.ETFNonSyariah = true, true
+		case SyariahOnly:
+			p.FundSyariah, p.FundNonSyariah = true, false
+			p.ETFSyariah, p.ETFNonSyariah = true, false
+		case SyariahExclude:
+			p.FundSyariah, p.FundNonSyariah = false, true
+			p.ETFSyariah, p.ETFNonSyariah = false, true
+		default:
+			return p, &ValidationError{
+				Field: "syariah",
+				Msg:   fmt.Sprintf("must be one of %s, %s, %s", SyariahAll, SyariahOnly, SyariahExclude),
+			}
+		}
+	}
+
+	setBool(&p.FundNonSyariah, o.FundNonSyariah)
+	setBool(&p.FundSyariah, o.FundSyariah)
+	setBool(&p.ETFNonSyariah, o.ETFNonSyariah)
+	setBool(&p.ETFSyariah, o.ETFSyariah)
+
+	return p, nil
+}
+
+// Validate check the preset before it is sent to the source.
+func Validate(p config.SectionPreset) error {
+	if len(p.FundTypes) == 0 {
+		return &ValidationError{Field: "fund_types", Msg: "at least one fund type is required"}
+	}
+
+	for _, t := range p.FundTypes {
+		if !contains(FundTypes, t) {
+			return &ValidationError{
+				Field: "fund_types",
+				Msg:   fmt.Sprintf("unknown fund type %q, must be one of %s", t, strings.Join(FundTypes, ", ")),
+			}
+		}
+	}
+
+	if !contains(HiLoWindows, p.HiLoSelect) {
+		return &ValidationError{
+			Field: "hiloselect",
+			Msg:   fmt.Sprintf("invalid window %q, must be one of %s", p.HiLoSelect, strings.Join(HiLoWindows, ", ")),
+		}
+	}
+
+	if !contains(PerformanceTypes, p.PerformanceType) {
+		return &ValidationError{
+			Field: "performance_type",
+			Msg:   fmt.Sprintf("invalid value %q, must be one of %s", p.PerformanceType, strings.Join(PerformanceTypes, ", ")),
+		}
+	}
+
+	if p.AUMLowerValue < 0 || p.AUMBetweenLow < 0 || p.AUMBetweenHigh < 0 || p.AUMGreaterValue < 0 {
+		return &ValidationError{Field: "aum", Msg: "value can't be negative"}
+	}
+
+	if p.AUMBetweenCheck && p.AUMBetweenLow > p.AUMBetweenHigh {
+		return &ValidationError{Field: "aum_between_low", Msg: "must not be greater than aum_between_high"}
+	}
+
+	if !p.FundSyariah && !p.FundNonSyariah && !p.ETFSyariah && !p.ETFNonSyariah {
+		return &ValidationError{Field: "syariah", Msg: "at least one syariah flag must be enabled"}
+	}
+
+	return nil
+}
+
+// Values build the query string sent to source_json_for_favorite.php
+func Values(p config.SectionPreset) url.Values {
+	form := url.Values{}
+	form.Add("firstopen", "yes")
+	addCheck(form, "aumlowercheck", p.AUMLowerCheck)
+	form.Add("aumlowervalue", strconv.FormatInt(p.AUMLowerValue, 10))
+	addCheck(form, "aumbetweencheck", p.AUMBetweenCheck)
+	form.Add("aumbetweenlowvalue", strconv.FormatInt(p.AUMBetweenLow, 10))
+	form.Add("aumbetweenhighvalue", strconv.FormatInt(p.AUMBetweenHigh, 10))
+	addCheck(form, "aumgreatercheck", p.AUMGreaterCheck)
+	form.Add("aumgreatervalue", strconv.FormatInt(p.AUMGreaterValue, 10))
+	form.Add("availibility", p.Availability)
+	form.Add("fundtype", strings.Join(p.FundTypes, ","))
+	form.Add("hiloselect", p.HiLoSelect)
+	form.Add("performancetype", p.PerformanceType)
+	addCheck(form, "fundnonsyariah", p.FundNonSyariah)
+	addCheck(form, "fundsyariah", p.FundSyariah)
+	addCheck(form, "etfnonsyariah", p.ETFNonSyariah)
+	addCheck(form, "etfsyariah", p.ETFSyariah)
+
+	return form
+}
+
+// the source only look for the "yes" value, unchecked box is not sent
+func addCheck(form url.Values, key string, checked bool) {
+	if checked {
+		form.Add(key, "yes")
+	}
+}
+
+func splitList(values []string) []string {
+	list := make([]string, 0, len(values))
+	for _, v := range values {
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.ToLower(strings.TrimSpace(item)); item != "" {
+				list = append(list, item)
+			}
+		}
+	}
+	return list
+}
+
+func contains(list []string, val string) bool {
+	for _, v := range list {
+		if v == val {
+			return true
+		}
+	}
+	return false
+}
+
+func setString(dst *string, val *string) {
+	if val != nil {
+		*dst = strings.TrimSpace(*val)
+	}
+}
+
+func setBool(dst *bool, val *bool) {
+	if val != nil {
+		*dst = *val
+	}
+}
+
+func setInt(dst *int64, val *int64) {
+	if val != nil {
+		*dst = *val
+	}
+}
@@ -0,0 +1,102 @@
+package scrape
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/natansdj/go_scrape/config"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testConfig() config.ConfYaml {
+	cfg := config.ConfYaml{}
+	cfg.Scrape.DefaultPreset = "default"
+	cfg.Scrape.Presets = map[string]config.SectionPreset{
+		"default": config.DefaultPreset(),
+	}
+	return cfg
+}
+
+func TestResolve(t *testing.T) {
+	cfg := testConfig()
+
+	p, err := Resolve(cfg, "")
+	assert.NoError(t, err)
+	assert.Equal(t, config.DefaultPreset(), p)
+
+	// override must not change the config
+	p.FundTypes[0] = "changed"
+	assert.Equal(t, "mm", cfg.Scrape.Presets["default"].FundTypes[0])
+
+	_, err = Resolve(cfg, "missing")
+	assert.True(t, errors.Is(err, ErrUnknownPreset))
+}
+
+func TestOverrideApply(t *testing.T) {
+	hilo := "3yr"
+	syariah := SyariahOnly
+	low := int64(100)
+
+	o := Override{
+		FundTypes:     []string{"equity, FI"},
+		HiLoSelect:    &hilo,
+		AUMLowerValue: &low,
+		Syariah:       &syariah,
+	}
+
+	p, err := o.Apply(config.DefaultPreset())
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"equity", "fi"}, p.FundTypes)
+	assert.Equal(t, "3yr", p.HiLoSelect)
+	assert.Equal(t, int64(100), p.AUMLowerValue)
+	assert.True(t, p.FundSyariah)
+	assert.False(t, p.FundNonSyariah)
+	assert.True(t, p.ETFSyariah)
+	assert.False(t, p.ETFNonSyariah)
+
+	invalid := "sometimes"
+	_, err = Override{Syariah: &invalid}.Apply(config.DefaultPreset())
+	assert.Error(t, err)
+}
+
+func TestValidate(t *testing.T) {
+	assert.NoError(t, Validate(config.DefaultPreset()))
+
+	p := config.DefaultPreset()
+	p.FundTypes = []string{"equity", "crypto"}
+	err := Validate(p)
+	var vErr *ValidationError
+	assert.True(t, errors.As(err, &vErr))
+	assert.Equal(t, "fund_types", vErr.Field)
+
+	p = config.DefaultPreset()
+	p.HiLoSelect = "2yr"
+	err = Validate(p)
+	assert.True(t, errors.As(err, &vErr))
+	assert.Equal(t, "hiloselect", vErr.Field)
+
+	p = config.DefaultPreset()
+	p.AUMBetweenLow = 3000
+	assert.Error(t, Validate(p))
+
+	p = config.DefaultPreset()
+	p.FundSyariah, p.FundNonSyariah, p.ETFSyariah, p.ETFNonSyariah = false, false, false, false
+	assert.Error(t, Validate(p))
+}
+
+func TestValues(t *testing.T) {
+	p := config.DefaultPreset()
+	p.FundTypes = []string{"equity"}
+	p.FundNonSyariah = false
+	p.AUMGreaterCheck = false
+
+	form := Values(p)
+	assert.Equal(t, "yes", form.Get("firstopen"))
+	assert.Equal(t, "equity", form.Get("fundtype"))
+	assert.Equal(t, "1yr", form.Get("hiloselect"))
+	assert.Equal(t, "500", form.Get("aumlowervalue"))
+	assert.Equal(t, "yes", form.Get("fundsyariah"))
+	assert.Equal(t, "", form.Get("fundnonsyariah"))
+	assert.Equal(t, "", form.Get("aumgreatercheck"))
+}
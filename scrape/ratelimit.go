@@ -0,0 +1,146 @@
+package scrape
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/natansdj/go_scrape/config"
+	"github.com/natansdj/go_scrape/logx"
+	"github.com/natansdj/go_scrape/status"
+
+	"golang.org/x/time/rate"
+)
+
+// Limiter throttle the requests of a source: a random delay, a token
+// bucket, a global rate shared through the stat storage and a max number
+// of requests in flight.
+type Limiter struct {
+	name     string
+	minDelay time.Duration
+	maxDelay time.Duration
+	bucket   *rate.Limiter
+	global   int64
+	slots    chan struct{}
+}
+
+// NewLimiter returns the Limiter of the source config, nil when the source
+// is not limited.
+func NewLimiter(name string, cfg config.SectionRateLimit) *Limiter {
+	l := &Limiter{
+		name:     name,
+		minDelay: time.Duration(cfg.MinDelay) * time.Millisecond,
+		maxDelay: time.Duration(cfg.MaxDelay) * time.Millisecond,
+		global:   cfg.GlobalRate,
+	}
+
+	if cfg.Rate > 0 {
+		burst := cfg.Burst
+		if burst < 1 {
+			burst = 1
+		}
+		l.bucket = rate.NewLimiter(rate.Limit(cfg.Rate), burst)
+	}
+	if cfg.MaxConcurrent > 0 {
+		l.slots = make(chan struct{}, cfg.MaxConcurrent)
+	}
+
+	if l.minDelay <= 0 && l.maxDelay <= 0 && l.bucket == nil && l.global <= 0 && l.slots == nil {
+		return nil
+	}
+
+	return l
+}
+
+// Wait blocks until the request is allowed or ctx is done, release must be
+// called once the response is read. A nil Limiter doesn't wait.
+func (l *Limiter) Wait(ctx context.Context) (release func(), err error) {
+	if l == nil {
+		return func() {}, nil
+	}
+
+	start := time.Now()
+	defer func() {
+		if waited := time.Since(start); waited >= time.Millisecond && status.StatStorage != nil {
+			status.StatStorage.AddRateLimitWait(waited.Milliseconds())
+		}
+	}()
+
+	if err = sleep(ctx, l.delay()); err != nil {
+		return nil, err
+	}
+
+	if l.bucket != nil {
+		if err = l.bucket.Wait(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	if err = l.waitGlobal(ctx); err != nil {
+		return nil, err
+	}
+
+	if l.slots == nil {
+		return func() {}, nil
+	}
+
+	select {
+	case l.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			<-l.slots
+		})
+	}, nil
+}
+
+// delay returns the random delay before the request
+func (l *Limiter) delay() time.Duration {
+	if l.maxDelay <= l.minDelay {
+		return l.minDelay
+	}
+	return l.minDelay + time.Duration(rand.Int63n(int64(l.maxDelay-l.minDelay)+1))
+}
+
+// waitGlobal wait for a request of the global rate, the request is sent
+// when the storage fails so the source is not blocked by the stat storage.
+func (l *Limiter) waitGlobal(ctx context.Context) error {
+	if l.global <= 0 || status.StatStorage == nil {
+		return nil
+	}
+
+	for {
+		wait, err := status.StatStorage.TakeRateLimit(l.name, l.global, time.Second)
+		if err != nil {
+			logx.LogError.Error("can't take the global rate limit of " + l.name + ": " + err.Error())
+			return nil
+		}
+		if wait <= 0 {
+			return nil
+		}
+		if err = sleep(ctx, wait); err != nil {
+			return err
+		}
+	}
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,123 @@
+package scrape
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/natansdj/go_scrape/config"
+	"github.com/natansdj/go_scrape/status"
+	"github.com/natansdj/go_scrape/storage/memory"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewLimiter(t *testing.T) {
+	assert.Nil(t, NewLimiter("test", config.SectionRateLimit{}))
+
+	// the nil limiter doesn't wait
+	var l *Limiter
+	release, err := l.Wait(context.Background())
+	assert.NoError(t, err)
+	release()
+
+	l = NewLimiter("test", config.SectionRateLimit{MinDelay: 10, MaxDelay: 20})
+	for i := 0; i < 10; i++ {
+		d := l.delay()
+		assert.True(t, d >= 10*time.Millisecond && d <= 20*time.Millisecond)
+	}
+}
+
+func TestLimiterRate(t *testing.T) {
+	status.StatStorage = memory.New()
+	l := NewLimiter("test", config.SectionRateLimit{Rate: 20, Burst: 1})
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		release, err := l.Wait(context.Background())
+		assert.NoError(t, err)
+		release()
+	}
+	assert.True(t, time.Since(start) >= 90*time.Millisecond)
+	assert.True(t, status.StatStorage.GetRateLimitWait() > 0)
+}
+
+func TestLimiterMaxConcurrent(t *testing.T) {
+	l := NewLimiter("test", config.SectionRateLimit{MaxConcurrent: 1})
+
+	release, err := l.Wait(context.Background())
+	assert.NoError(t, err)
+
+	// the slot is taken until it's released
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = l.Wait(ctx)
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	release()
+	release()
+	next, err := l.Wait(context.Background())
+	assert.NoError(t, err)
+	next()
+}
+
+func TestLimiterGlobal(t *testing.T) {
+	status.StatStorage = memory.New()
+	l := NewLimiter("test", config.SectionRateLimit{GlobalRate: 1})
+	other := NewLimiter("test", config.SectionRateLimit{GlobalRate: 1})
+
+	release, err := l.Wait(context.Background())
+	assert.NoError(t, err)
+	release()
+
+	// the second limiter of the source waits for the next window
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	if _, err = other.Wait(ctx); err == nil {
+		// the first request was at the end of the window
+		return
+	}
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
+
+func TestRequestDoRetryLimiter(t *testing.T) {
+	var inflight, peak int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&inflight, 1)
+		defer atomic.AddInt32(&inflight, -1)
+		if n > atomic.LoadInt32(&peak) {
+			atomic.StoreInt32(&peak, n)
+		}
+		time.Sleep(10 * time.Millisecond)
+		_, _ = w.Write([]byte(`ok`))
+	}))
+	defer srv.Close()
+
+	cfg := testConfig()
+	cfg.Source.BaseURI = srv.URL + "/"
+	client, err := NewClient("test", cfg.Source)
+	assert.NoError(t, err)
+
+	p := testPolicy()
+	p.Limiter = NewLimiter("test", config.SectionRateLimit{MaxConcurrent: 1})
+
+	done := make(chan error, 4)
+	for i := 0; i < 4; i++ {
+		go func() {
+			req, err := RequestInit(cfg, http.MethodGet, FavoriteEndpoint, nil)
+			if err != nil {
+				done <- err
+				return
+			}
+			_, err = RequestDoRetry(client, req, p)
+			done <- err
+		}()
+	}
+	for i := 0; i < 4; i++ {
+		assert.NoError(t, <-done)
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&peak))
+}
@@ -0,0 +1,238 @@
+package scrape
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/natansdj/go_scrape/config"
+	"github.com/natansdj/go_scrape/logx"
+	"github.com/natansdj/go_scrape/status"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+//ResponseClose Close response but check errors, used for defer statement
+func ResponseClose(c io.Closer) {
+	err := c.Close()
+	if err != nil {
+		logx.LogError.Fatal(err)
+	}
+}
+
+//RequestInit returns the request of the default source
+//args[0] : qs url.Values
+func RequestInit(cfg config.ConfYaml, method string, endpoint string, body io.Reader, args ...interface{}) (req *http.Request, err error) {
+	return SourceRequest(cfg.Source, method, endpoint, body, args...)
+}
+
+//SourceRequest returns the request of the endpoint under the source base uri
+//with the source headers
+//args[0] : qs url.Values
+func SourceRequest(src config.SourceAPI, method string, endpoint string, body io.Reader, args ...interface{}) (req *http.Request, err error) {
+	urlStr := src.BaseURI + endpoint
+
+	req, err = http.NewRequest(method, urlStr, body)
+	if err != nil {
+		return nil, err
+	}
+
+	//Add shipper API_KEY
+	q := req.URL.Query()
+
+	//Add queryString
+	if len(args) >= 1 {
+		if v, ok := args[0].(url.Values); ok && len(v) > 0 {
+			for i := range v {
+				q.Add(i, v.Get(i))
+			}
+		}
+	}
+
+	req.URL.RawQuery = q.Encode()
+
+	//Add Header
+	req.Header.Add("User-Agent", "Shipper/")
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("Accept", "application/json")
+	for k, v := range src.Headers {
+		req.Header.Set(k, v)
+	}
+
+	return req, nil
+}
+
+//args[0] methodName string
+func RequestDo(client *http.Client, req *http.Request, args ...interface{}) (body []byte, err error) {
+	return RequestDoRetry(client, req, RetryPolicy{}, args...)
+}
+
+//RequestDoRetry attempt the request until it succeeds or the policy gives up
+//args[0] methodName string
+func RequestDoRetry(client *http.Client, req *http.Request, policy RetryPolicy, args ...interface{}) (body []byte, err error) {
+	if req == nil {
+		return body, errors.New("empty request")
+	}
+
+	//Args
+	var methodName string
+	if len(args) >= 1 {
+		if v, ok := args[0].(string); ok {
+			methodName = v
+		}
+	}
+
+	// a fresh response skips the breaker and the limiter
+	key, cached := policy.Cache.lookup(req)
+	if policy.Cache.fresh(cached) {
+		countCache(true)
+		return cached.Body, nil
+	}
+	req = policy.Cache.conditional(req, cached)
+
+	var header http.Header
+	attempts := policy.Attempts()
+	for attempt := 1; ; attempt++ {
+		if err = req.Context().Err(); err != nil {
+			return nil, err
+		}
+
+		if err = policy.Breaker.Allow(); err != nil {
+			return nil, err
+		}
+
+		// the rate limit wait is not part of the attempt timeout
+		var release func()
+		if release, err = policy.Limiter.Wait(req.Context()); err != nil {
+			policy.Breaker.Release()
+			return nil, err
+		}
+		body, header, err = requestAttempt(client, req, policy.AttemptTimeout(), attempt)
+		release()
+
+		switch {
+		case req.Context().Err() != nil:
+			policy.Breaker.Release()
+		case upstreamFailed(err):
+			policy.Breaker.Failure()
+		default:
+			policy.Breaker.Success()
+		}
+		if cached != nil && notModified(err) {
+			policy.Cache.refresh(key, cached, header)
+			countCache(true)
+			return cached.Body, nil
+		}
+		if err == nil {
+			if key != "" {
+				policy.Cache.store(key, body, header)
+				countCache(false)
+			}
+			return body, nil
+		}
+
+		logx.LogError.Error(methodName, fmt.Sprintf(" attempt %d/%d: %v", attempt, attempts, err))
+
+		if attempt >= attempts || !policy.Retryable(err) || req.Context().Err() != nil {
+			return nil, err
+		}
+
+		// the wait past the deadline of the request gives up right away
+		wait := policy.Wait(attempt, err)
+		if deadline, ok := req.Context().Deadline(); ok && time.Now().Add(wait).After(deadline) {
+			return nil, err
+		}
+		if status.StatStorage != nil {
+			status.StatStorage.AddRetryCount(1)
+		}
+		if policy.OnRetry != nil {
+			policy.OnRetry(attempt, err, wait)
+		}
+
+		if req.GetBody != nil {
+			if req.Body, err = req.GetBody(); err != nil {
+				return nil, err
+			}
+		}
+
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+func requestAttempt(client *http.Client, req *http.Request, timeout time.Duration, attempt int) (body []byte, header http.Header, err error) {
+	ctx, cancel := context.WithTimeout(req.Context(), timeout)
+	defer cancel()
+
+	start := time.Now()
+	res, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		// the attempt timeout is the latency of the slow upstream
+		if ctx.Err() == context.DeadlineExceeded && req.Context().Err() == nil {
+			ObserveLatency(time.Since(start))
+		}
+		return nil, nil, err
+	}
+
+	defer ResponseClose(res.Body)
+
+	body, err = ioutil.ReadAll(res.Body)
+	ObserveLatency(time.Since(start))
+
+	logx.LogAccess.Debugf("upstream url=%s attempt=%d status=%d", req.URL, attempt, res.StatusCode)
+
+	if res.StatusCode < 200 || res.StatusCode >= 300 {
+		return nil, res.Header, &StatusError{
+			Code:       res.StatusCode,
+			Status:     res.Status,
+			RetryAfter: parseRetryAfter(res.Header.Get("Retry-After"), time.Now()),
+		}
+	}
+
+	return body, res.Header, err
+}
+
+type Response struct {
+	Headers map[string][]string
+	Body    *JSONReader
+	Status  int
+}
+
+type JSONReader struct {
+	*bytes.Reader
+}
+
+func NewJSONReader(outBytes []byte) *JSONReader {
+	jr := new(JSONReader)
+	jr.Reader = bytes.NewReader(outBytes)
+	return jr
+}
+
+func (js JSONReader) MarshalJSON() ([]byte, error) {
+	data, err := ioutil.ReadAll(js.Reader)
+	if err != nil {
+		return nil, err
+	}
+	data = []byte(`"` + string(data) + `"`)
+	return data, nil
+}
+
+// UnmarshalJSON sets *jr to a copy of data.
+func (jr *JSONReader) UnmarshalJSON(data []byte) error {
+	if jr == nil {
+		return errors.New("json.JSONReader: UnmarshalJSON on nil pointer")
+	}
+	if data == nil {
+		return nil
+	}
+	data = []byte(strings.Trim(string(data), "\""))
+	jr.Reader = bytes.NewReader(data)
+	return nil
+}
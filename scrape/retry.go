@@ -0,0 +1,153 @@
+package scrape
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/natansdj/go_scrape/config"
+)
+
+// DefaultRequestTimeout is the timeout of a single attempt when
+// source.request_timeout is not set.
+const DefaultRequestTimeout = 30 * time.Second
+
+// StatusError is returned when the upstream responds with a non 2xx status.
+type StatusError struct {
+	Code       int
+	Status     string
+	RetryAfter time.Duration
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("unexpected upstream status: %s", e.Status)
+}
+
+// RetryPolicy decide whether and when a failed request is attempted again.
+type RetryPolicy struct {
+	MaxAttempts int
+	Backoff     time.Duration
+	MaxBackoff  time.Duration
+	// Jitter is the random fraction removed from every delay
+	Jitter   float64
+	Statuses map[int]bool
+	// Timeout of a single attempt
+	Timeout time.Duration
+	// OnRetry is called before waiting for the next attempt
+	OnRetry func(attempt int, err error, wait time.Duration)
+	// Limiter of the source, every attempt waits for it
+	Limiter *Limiter
+	// Breaker of the source, it short-circuits the attempts while open
+	Breaker *Breaker
+	// Cache of the source responses, checked before the first attempt
+	Cache *Cache
+}
+
+// NewRetryPolicy returns the retry policy of the source config.
+func NewRetryPolicy(cfg config.SourceAPI) RetryPolicy {
+	p := RetryPolicy{
+		MaxAttempts: cfg.Retry.MaxAttempts,
+		Backoff:     time.Duration(cfg.Retry.Backoff) * time.Millisecond,
+		MaxBackoff:  time.Duration(cfg.Retry.MaxBackoff) * time.Millisecond,
+		Jitter:      cfg.Retry.Jitter,
+		Statuses:    map[int]bool{},
+		Timeout:     time.Duration(cfg.RequestTimeout) * time.Second,
+		Cache:       NewCache(cfg.Cache),
+	}
+	for _, code := range cfg.Retry.Statuses {
+		p.Statuses[code] = true
+	}
+
+	return p
+}
+
+// Attempts returns the total number of attempts, at least one.
+func (p RetryPolicy) Attempts() int {
+	if p.MaxAttempts < 1 {
+		return 1
+	}
+	return p.MaxAttempts
+}
+
+// AttemptTimeout returns the timeout of a single attempt.
+func (p RetryPolicy) AttemptTimeout() time.Duration {
+	if p.Timeout <= 0 {
+		return DefaultRequestTimeout
+	}
+	return p.Timeout
+}
+
+// Retryable report whether the request failed with err may succeed on
+// another attempt. Network errors and timeouts are always retryable,
+// status errors only when the status is in the policy.
+func (p RetryPolicy) Retryable(err error) bool {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return p.Statuses[statusErr.Code]
+	}
+	return err != nil
+}
+
+// Wait returns the delay after the failed attempt, the Retry-After of the
+// response wins over the exponential backoff. Only the backoff is capped by
+// MaxBackoff, the upstream is not attempted before its Retry-After.
+func (p RetryPolicy) Wait(attempt int, err error) time.Duration {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) && statusErr.RetryAfter > 0 {
+		return statusErr.RetryAfter
+	}
+
+	if attempt < 1 {
+		attempt = 1
+	}
+
+	wait := p.Backoff
+	for i := 1; i < attempt && (p.MaxBackoff <= 0 || wait < p.MaxBackoff); i++ {
+		wait *= 2
+	}
+	wait = p.limit(wait)
+
+	if p.Jitter > 0 && wait > 0 {
+		jitter := p.Jitter
+		if jitter > 1 {
+			jitter = 1
+		}
+		wait -= time.Duration(rand.Float64() * jitter * float64(wait))
+	}
+
+	return wait
+}
+
+func (p RetryPolicy) limit(wait time.Duration) time.Duration {
+	if p.MaxBackoff > 0 && wait > p.MaxBackoff {
+		return p.MaxBackoff
+	}
+	return wait
+}
+
+// parseRetryAfter read the Retry-After header, in seconds or as http date.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+
+	if secs, err := strconv.Atoi(value); err == nil {
+		if secs > 0 {
+			return time.Duration(secs) * time.Second
+		}
+		return 0
+	}
+
+	if t, err := http.ParseTime(value); err == nil {
+		if wait := t.Sub(now); wait > 0 {
+			return wait
+		}
+	}
+
+	return 0
+}
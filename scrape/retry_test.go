@@ -0,0 +1,188 @@
+package scrape
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts: 3,
+		Backoff:     time.Millisecond,
+		MaxBackoff:  10 * time.Millisecond,
+		Statuses:    map[int]bool{http.StatusServiceUnavailable: true, http.StatusTooManyRequests: true},
+	}
+}
+
+func TestRetryPolicyWait(t *testing.T) {
+	p := RetryPolicy{Backoff: 100 * time.Millisecond, MaxBackoff: time.Second}
+
+	assert.Equal(t, 100*time.Millisecond, p.Wait(1, errors.New("reset")))
+	assert.Equal(t, 200*time.Millisecond, p.Wait(2, errors.New("reset")))
+	assert.Equal(t, 800*time.Millisecond, p.Wait(4, errors.New("reset")))
+	assert.Equal(t, time.Second, p.Wait(10, errors.New("reset")))
+
+	retryAfter := &StatusError{Code: http.StatusTooManyRequests, RetryAfter: 500 * time.Millisecond}
+	assert.Equal(t, 500*time.Millisecond, p.Wait(1, retryAfter))
+	retryAfter.RetryAfter = time.Minute
+	assert.Equal(t, time.Minute, p.Wait(1, retryAfter))
+
+	p.Jitter = 0.5
+	for i := 0; i < 10; i++ {
+		wait := p.Wait(1, errors.New("reset"))
+		assert.True(t, wait > 50*time.Millisecond && wait <= 100*time.Millisecond, wait)
+	}
+}
+
+func TestRetryPolicyRetryable(t *testing.T) {
+	p := testPolicy()
+
+	assert.True(t, p.Retryable(errors.New("connection reset")))
+	assert.True(t, p.Retryable(&StatusError{Code: http.StatusServiceUnavailable}))
+	assert.False(t, p.Retryable(&StatusError{Code: http.StatusNotFound}))
+	assert.False(t, p.Retryable(nil))
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2026, 1, 2, 9, 0, 0, 0, time.UTC)
+
+	assert.Equal(t, 3*time.Second, parseRetryAfter("3", now))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("-1", now))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("", now))
+	assert.Equal(t, 30*time.Second, parseRetryAfter(now.Add(30*time.Second).Format(http.TimeFormat), now))
+	assert.Equal(t, time.Duration(0), parseRetryAfter("soon", now))
+}
+
+func TestRequestDoRetry(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) < 3 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte(`ok`))
+	}))
+	defer srv.Close()
+
+	cfg := testConfig()
+	cfg.Source.BaseURI = srv.URL + "/"
+	client, err := NewClient("test", cfg.Source)
+	assert.NoError(t, err)
+
+	var retried []int
+	p := testPolicy()
+	p.OnRetry = func(attempt int, err error, wait time.Duration) {
+		retried = append(retried, attempt)
+	}
+
+	req, err := RequestInit(cfg, http.MethodGet, FavoriteEndpoint, nil)
+	assert.NoError(t, err)
+
+	body, err := RequestDoRetry(client, req, p)
+	assert.NoError(t, err)
+	assert.Equal(t, "ok", string(body))
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+	assert.Equal(t, []int{1, 2}, retried)
+}
+
+func TestRequestDoRetryGiveUp(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		if r.URL.Path == "/missing" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	cfg := testConfig()
+	cfg.Source.BaseURI = srv.URL + "/"
+	client, err := NewClient("test", cfg.Source)
+	assert.NoError(t, err)
+
+	req, err := RequestInit(cfg, http.MethodGet, FavoriteEndpoint, nil)
+	assert.NoError(t, err)
+
+	_, err = RequestDoRetry(client, req, testPolicy())
+	var statusErr *StatusError
+	assert.True(t, errors.As(err, &statusErr))
+	assert.Equal(t, http.StatusServiceUnavailable, statusErr.Code)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+
+	atomic.StoreInt32(&calls, 0)
+	req, err = RequestInit(cfg, http.MethodGet, "missing", nil)
+	assert.NoError(t, err)
+
+	_, err = RequestDoRetry(client, req, testPolicy())
+	assert.Error(t, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
+
+func TestRequestDoRetryAfterDeadline(t *testing.T) {
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.Header().Set("Retry-After", "60")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+
+	cfg := testConfig()
+	cfg.Source.BaseURI = srv.URL + "/"
+	client, err := NewClient("test", cfg.Source)
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	req, err := RequestInit(cfg, http.MethodGet, FavoriteEndpoint, nil)
+	assert.NoError(t, err)
+
+	// the Retry-After past the deadline gives up without waiting
+	start := time.Now()
+	_, err = RequestDoRetry(client, req.WithContext(ctx), testPolicy())
+	var statusErr *StatusError
+	assert.True(t, errors.As(err, &statusErr))
+	assert.Equal(t, time.Minute, statusErr.RetryAfter)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	assert.True(t, time.Since(start) < time.Second)
+}
+
+func TestRequestDoRetryCancel(t *testing.T) {
+	var calls int32
+	started := make(chan struct{}, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		started <- struct{}{}
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+
+	cfg := testConfig()
+	cfg.Source.BaseURI = srv.URL + "/"
+	client, err := NewClient("test", cfg.Source)
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req, err := RequestInit(cfg, http.MethodGet, FavoriteEndpoint, nil)
+	assert.NoError(t, err)
+
+	go func() {
+		<-started
+		cancel()
+	}()
+
+	// the cancelled request is not attempted again
+	_, err = RequestDoRetry(client, req.WithContext(ctx), testPolicy())
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
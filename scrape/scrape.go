@@ -0,0 +1,73 @@
+package scrape
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/natansdj/go_scrape/config"
+	"github.com/natansdj/go_scrape/fund"
+	"github.com/natansdj/go_scrape/parser"
+)
+
+// SourceName is the name of the default source
+const SourceName = config.DefaultSource
+
+// Result of a single scrape
+type Result struct {
+	URL    string             `json:"source"`
+	Total  int                `json:"total"`
+	Funds  []fund.Fund        `json:"funds"`
+	Errors []*parser.RowError `json:"errors"`
+}
+
+// Fetch request the fund list of the default source with the preset and
+// parse every row.
+func Fetch(cfg config.ConfYaml, preset config.SectionPreset) (*Result, error) {
+	return FetchWithPolicy(context.Background(), cfg, preset, NewRetryPolicy(cfg.Source))
+}
+
+// FetchWithPolicy is Fetch with a custom retry policy, it stops requesting
+// and parsing once ctx is done. The client, the limiter and the breaker
+// missing in the policy are the ones of DefaultClientFactory.
+func FetchWithPolicy(ctx context.Context, cfg config.ConfYaml, preset config.SectionPreset, policy RetryPolicy) (*Result, error) {
+	src, srcCfg, err := ResolveSource(cfg, SourceName)
+	if err != nil {
+		return nil, err
+	}
+
+	clients := DefaultClientFactory(cfg)
+	client, err := clients.Client(SourceName)
+	if err != nil {
+		return nil, err
+	}
+	if policy.Limiter == nil {
+		policy.Limiter = clients.Limiter(SourceName)
+	}
+	if policy.Breaker == nil {
+		policy.Breaker = clients.Breaker(SourceName)
+	}
+
+	return FetchSource(ctx, client, src, srcCfg, preset, policy)
+}
+
+// FetchSource request the fund list of the source with the client and the
+// preset then parse the response, it stops once ctx is done.
+func FetchSource(ctx context.Context, client *http.Client, src Source, cfg config.SourceAPI, preset config.SectionPreset, policy RetryPolicy) (*Result, error) {
+	req, err := src.NewRequest(ctx, cfg, preset)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := RequestDoRetry(client, req, policy, src.Name())
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := src.Parse(ctx, cfg, body)
+	if err != nil {
+		return nil, err
+	}
+	result.URL = req.URL.String()
+
+	return result, nil
+}
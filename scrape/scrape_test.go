@@ -0,0 +1,53 @@
+package scrape
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/natansdj/go_scrape/config"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFetch(t *testing.T) {
+	var query string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/"+FavoriteEndpoint, r.URL.Path)
+		query = r.URL.RawQuery
+		_, _ = w.Write([]byte(`{"aaData":[` +
+			`["1","A","Fund A","MI","mm","1.000,5","0","0","0","0","0","0","0","0","0","-","0","0","0","0","","","0","1,5"],` +
+			`["2","B","","MI","mm","1","0","0","0","0","0","0","0","0","0","0","0","0","0","0","","","0","1"]` +
+			`]}`))
+	}))
+	defer srv.Close()
+
+	cfg := testConfig()
+	cfg.Source.BaseURI = srv.URL + "/"
+
+	p := config.DefaultPreset()
+	p.FundTypes = []string{"equity"}
+
+	result, err := Fetch(cfg, p)
+	assert.NoError(t, err)
+	assert.Contains(t, query, "fundtype=equity")
+	assert.Equal(t, 2, result.Total)
+	assert.Len(t, result.Funds, 1)
+	assert.Len(t, result.Errors, 1)
+	assert.Equal(t, 1000.5, *result.Funds[0].LastNAV)
+	assert.Equal(t, 1.5e9, *result.Funds[0].AUM)
+	assert.Nil(t, result.Funds[0].Returns.FiveYear)
+}
+
+func TestFetchInvalidBody(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`<html>maintenance</html>`))
+	}))
+	defer srv.Close()
+
+	cfg := testConfig()
+	cfg.Source.BaseURI = srv.URL + "/"
+
+	_, err := Fetch(cfg, config.DefaultPreset())
+	assert.Error(t, err)
+}
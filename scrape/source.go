@@ -0,0 +1,86 @@
+package scrape
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/natansdj/go_scrape/config"
+)
+
+// ErrUnknownSource is returned when the source is not registered or has no
+// config
+var ErrUnknownSource = errors.New("unknown source")
+
+// Source is a fund data provider
+type Source interface {
+	// Name of the source, the key of its sources.<name> config block
+	Name() string
+	// NewRequest returns the upstream request of the preset
+	NewRequest(ctx context.Context, cfg config.SourceAPI, preset config.SectionPreset) (*http.Request, error)
+	// Parse convert the response body into funds
+	Parse(ctx context.Context, cfg config.SourceAPI, body []byte) (*Result, error)
+}
+
+var registry = struct {
+	sync.RWMutex
+	sources map[string]Source
+}{
+	sources: map[string]Source{},
+}
+
+// Register make the source available by its name, it panics when the name
+// is already registered.
+func Register(src Source) {
+	registry.Lock()
+	defer registry.Unlock()
+
+	if _, ok := registry.sources[src.Name()]; ok {
+		panic("scrape: source " + src.Name() + " is registered twice")
+	}
+	registry.sources[src.Name()] = src
+}
+
+// Lookup returns the registered source
+func Lookup(name string) (Source, error) {
+	registry.RLock()
+	defer registry.RUnlock()
+
+	src, ok := registry.sources[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownSource, name)
+	}
+	return src, nil
+}
+
+// Sources returns the names of the registered sources
+func Sources() []string {
+	registry.RLock()
+	defer registry.RUnlock()
+
+	names := make([]string, 0, len(registry.sources))
+	for name := range registry.sources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// ResolveSource returns the registered source with its config
+func ResolveSource(cfg config.ConfYaml, name string) (Source, config.SourceAPI, error) {
+	src, err := Lookup(name)
+	if err != nil {
+		return nil, config.SourceAPI{}, err
+	}
+
+	srcCfg, ok := cfg.SourceConfig(name)
+	if !ok {
+		return nil, config.SourceAPI{}, fmt.Errorf("%w: %s has no config", ErrUnknownSource, name)
+	}
+
+	return src, srcCfg, nil
+}
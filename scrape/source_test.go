@@ -0,0 +1,115 @@
+package scrape
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/natansdj/go_scrape/config"
+	"github.com/natansdj/go_scrape/fund"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// lineSource returns a fund per line of the response body
+type lineSource struct{}
+
+func (lineSource) Name() string {
+	return "lines"
+}
+
+func (lineSource) NewRequest(ctx context.Context, cfg config.SourceAPI, preset config.SectionPreset) (*http.Request, error) {
+	req, err := SourceRequest(cfg, http.MethodGet, "funds.txt", nil)
+	if err != nil {
+		return nil, err
+	}
+	return req.WithContext(ctx), nil
+}
+
+func (lineSource) Parse(ctx context.Context, cfg config.SourceAPI, body []byte) (*Result, error) {
+	lines := strings.Fields(string(body))
+	funds := make([]fund.Fund, 0, len(lines))
+	for _, line := range lines {
+		funds = append(funds, fund.Fund{Name: line})
+	}
+	return &Result{Total: len(lines), Funds: funds}, nil
+}
+
+func init() {
+	Register(lineSource{})
+}
+
+func TestLookup(t *testing.T) {
+	src, err := Lookup(SourceName)
+	assert.NoError(t, err)
+	assert.Equal(t, config.DefaultSource, src.Name())
+
+	_, err = Lookup("missing")
+	assert.True(t, errors.Is(err, ErrUnknownSource))
+
+	assert.Equal(t, []string{config.DefaultSource, "lines"}, Sources())
+	assert.Panics(t, func() {
+		Register(lineSource{})
+	})
+}
+
+func TestResolveSource(t *testing.T) {
+	cfg := testConfig()
+	cfg.Source.BaseURI = "http://default/"
+
+	_, srcCfg, err := ResolveSource(cfg, SourceName)
+	assert.NoError(t, err)
+	assert.Equal(t, "http://default/", srcCfg.BaseURI)
+
+	// registered without config
+	_, _, err = ResolveSource(cfg, "lines")
+	assert.True(t, errors.Is(err, ErrUnknownSource))
+
+	cfg.Sources = map[string]config.SourceAPI{
+		"lines":    {BaseURI: "http://lines/"},
+		"unknown":  {BaseURI: "http://unknown/"},
+		SourceName: {BaseURI: "http://override/"},
+	}
+
+	src, srcCfg, err := ResolveSource(cfg, "lines")
+	assert.NoError(t, err)
+	assert.Equal(t, "lines", src.Name())
+	assert.Equal(t, "http://lines/", srcCfg.BaseURI)
+
+	_, srcCfg, err = ResolveSource(cfg, SourceName)
+	assert.NoError(t, err)
+	assert.Equal(t, "http://override/", srcCfg.BaseURI)
+
+	// configured without implementation
+	_, _, err = ResolveSource(cfg, "unknown")
+	assert.True(t, errors.Is(err, ErrUnknownSource))
+}
+
+func TestFetchSource(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/funds.txt", r.URL.Path)
+		assert.Equal(t, "secret", r.Header.Get("X-Api-Key"))
+		assert.Equal(t, "text/plain", r.Header.Get("Accept"))
+		_, _ = w.Write([]byte("alpha\nbeta\n"))
+	}))
+	defer srv.Close()
+
+	src, err := Lookup("lines")
+	assert.NoError(t, err)
+
+	srcCfg := config.SourceAPI{
+		BaseURI: srv.URL + "/",
+		Headers: map[string]string{"x-api-key": "secret", "accept": "text/plain"},
+	}
+	client, err := NewClient("lines", srcCfg)
+	assert.NoError(t, err)
+
+	result, err := FetchSource(context.Background(), client, src, srcCfg, config.DefaultPreset(), testPolicy())
+	assert.NoError(t, err)
+	assert.Equal(t, srv.URL+"/funds.txt", result.URL)
+	assert.Equal(t, 2, result.Total)
+	assert.Equal(t, "beta", result.Funds[1].Name)
+}
@@ -1,9 +1,13 @@
 package status
 
 import (
+	"errors"
+
 	"github.com/natansdj/go_scrape/config"
 	"github.com/natansdj/go_scrape/logx"
+	"github.com/natansdj/go_scrape/queue"
 	"github.com/natansdj/go_scrape/storage"
+	"github.com/natansdj/go_scrape/storage/boltdb"
 	"github.com/natansdj/go_scrape/storage/memory"
 	"github.com/natansdj/go_scrape/storage/redis"
 
@@ -16,12 +20,27 @@ var Stats *stats.Stats
 // StatStorage implements the storage interface
 var StatStorage storage.Storage
 
+// SnapshotStorage implements the snapshot store interface
+var SnapshotStorage storage.SnapshotStore
+
 // App is status structure
 type App struct {
-	Version    string `json:"version"`
-	QueueMax   int    `json:"queue_max"`
-	QueueUsage int    `json:"queue_usage"`
-	TotalCount int64  `json:"total_count"`
+	Version      string       `json:"version"`
+	QueueMax     int          `json:"queue_max"`
+	QueueUsage   int          `json:"queue_usage"`
+	QueueLanes   []queue.Lane `json:"queue_lanes"`
+	QueueWorkers int          `json:"queue_workers"`
+	TotalCount   int64        `json:"total_count"`
+	SuccessCount int64        `json:"success_count"`
+	FailureCount int64        `json:"failure_count"`
+	RetryCount   int64        `json:"retry_count"`
+	PanicCount   int64        `json:"panic_count"`
+	// RateLimitWait in millisecond
+	RateLimitWait  int64 `json:"rate_limit_wait"`
+	CacheHitCount  int64 `json:"cache_hit_count"`
+	CacheMissCount int64 `json:"cache_miss_count"`
+	// Breakers is the state of the circuit breaker by source
+	Breakers map[string]string `json:"breakers"`
 }
 
 // InitAppStatus for initialize app status
@@ -46,3 +65,27 @@ func InitAppStatus(conf config.ConfYaml) error {
 
 	return nil
 }
+
+// InitSnapshotStorage for initialize scrape history storage
+func InitSnapshotStorage(conf config.ConfYaml) error {
+	logx.LogAccess.Info("Init Snapshot Storage Engine as ", conf.Stat.Snapshot.Engine)
+	switch conf.Stat.Snapshot.Engine {
+	case "redis":
+		SnapshotStorage = redis.NewSnapshot(conf)
+	case "boltdb":
+		SnapshotStorage = boltdb.NewSnapshot(conf)
+	case "", "memory":
+		SnapshotStorage = memory.NewSnapshot()
+	default:
+		logx.LogError.Error("snapshot storage error: can't find storage driver")
+		return errors.New("can't find snapshot storage driver")
+	}
+
+	if err := SnapshotStorage.Init(); err != nil {
+		logx.LogError.Error("snapshot storage error: " + err.Error())
+
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,193 @@
+package boltdb
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/natansdj/go_scrape/config"
+	"github.com/natansdj/go_scrape/storage"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var _ storage.SnapshotStore = (*SnapshotStore)(nil)
+
+var (
+	runsBucket  = []byte("runs")
+	fundsBucket = []byte("funds")
+)
+
+// DefaultPath is the database file when stat.snapshot.path is empty
+const DefaultPath = "go_scrape.db"
+
+// NewSnapshot func implements the snapshot store interface with an
+// embedded bolt database, so a single binary keeps history across restarts.
+func NewSnapshot(config config.ConfYaml) *SnapshotStore {
+	return &SnapshotStore{
+		config: config,
+	}
+}
+
+// SnapshotStore keep runs in "runs" bucket keyed by sortable run id and
+// one nested bucket per fund id in "funds" bucket.
+type SnapshotStore struct {
+	config config.ConfYaml
+	db     *bolt.DB
+}
+
+// Init open the database file and create the buckets.
+func (s *SnapshotStore) Init() error {
+	path := s.config.Stat.Snapshot.Path
+	if path == "" {
+		path = DefaultPath
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return err
+	}
+	s.db = db
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(runsBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(fundsBucket)
+		return err
+	})
+}
+
+// Close the database file
+func (s *SnapshotStore) Close() error {
+	if s.db == nil {
+		return nil
+	}
+
+	return s.db.Close()
+}
+
+// SaveRun store the run and index every fund by id
+func (s *SnapshotStore) SaveRun(run *storage.Snapshot) error {
+	data, err := json.Marshal(run)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(runsBucket).Put([]byte(run.ID), data); err != nil {
+			return err
+		}
+
+		funds := tx.Bucket(fundsBucket)
+		for _, p := range run.Points() {
+			b, err := funds.CreateBucketIfNotExists([]byte(p.Fund.ID))
+			if err != nil {
+				return err
+			}
+			point, err := json.Marshal(p)
+			if err != nil {
+				return err
+			}
+			if err := b.Put([]byte(run.ID), point); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// ListRuns returns runs newest first without funds
+func (s *SnapshotStore) ListRuns(limit int) ([]storage.Snapshot, error) {
+	runs := []storage.Snapshot{}
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(runsBucket).Cursor()
+		for k, v := c.Last(); k != nil; k, v = c.Prev() {
+			if limit > 0 && len(runs) >= limit {
+				break
+			}
+
+			var run storage.Snapshot
+			if err := json.Unmarshal(v, &run); err != nil {
+				return err
+			}
+			runs = append(runs, run.Summary())
+		}
+		return nil
+	})
+
+	return runs, err
+}
+
+// GetRun returns a single run with funds
+func (s *SnapshotStore) GetRun(id string) (*storage.Snapshot, error) {
+	var run *storage.Snapshot
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(runsBucket).Get([]byte(id))
+		if v == nil {
+			return storage.ErrSnapshotNotFound
+		}
+		run = &storage.Snapshot{}
+		return json.Unmarshal(v, run)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return run, nil
+}
+
+// Latest returns the newest run with funds
+func (s *SnapshotStore) Latest() (*storage.Snapshot, error) {
+	var run *storage.Snapshot
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		_, v := tx.Bucket(runsBucket).Cursor().Last()
+		if v == nil {
+			return storage.ErrSnapshotNotFound
+		}
+		run = &storage.Snapshot{}
+		return json.Unmarshal(v, run)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return run, nil
+}
+
+// FundHistory returns the values of a fund newest first
+func (s *SnapshotStore) FundHistory(fundID string, limit int) ([]storage.FundPoint, error) {
+	points := []storage.FundPoint{}
+
+	err := s.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(fundsBucket).Bucket([]byte(fundID))
+		if b == nil {
+			return nil
+		}
+
+		c := b.Cursor()
+		for k, v := c.Last(); k != nil; k, v = c.Prev() {
+			if limit > 0 && len(points) >= limit {
+				break
+			}
+
+			var p storage.FundPoint
+			if err := json.Unmarshal(v, &p); err != nil {
+				return err
+			}
+			points = append(points, p)
+		}
+		return nil
+	})
+
+	return points, err
+}
@@ -0,0 +1,76 @@
+package boltdb
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/natansdj/go_scrape/config"
+	"github.com/natansdj/go_scrape/fund"
+	"github.com/natansdj/go_scrape/storage"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBoltEngine(t *testing.T) {
+	dir, err := ioutil.TempDir("", "go_scrape")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	cfg := config.ConfYaml{}
+	cfg.Stat.Snapshot.Path = filepath.Join(dir, "data", "snapshot.db")
+
+	s := NewSnapshot(cfg)
+	assert.NoError(t, s.Init())
+
+	_, err = s.Latest()
+	assert.Equal(t, storage.ErrSnapshotNotFound, err)
+
+	first := storage.NewSnapshot("indopremier", "default", []fund.Fund{{ID: "1", Name: "A"}, {ID: "2", Name: "B"}})
+	second := storage.NewSnapshot("indopremier", "equity", []fund.Fund{{ID: "1", Name: "A2"}})
+	assert.NoError(t, s.SaveRun(first))
+	assert.NoError(t, s.SaveRun(second))
+	assert.NoError(t, s.Close())
+
+	// history survive restart
+	s = NewSnapshot(cfg)
+	assert.NoError(t, s.Init())
+
+	runs, err := s.ListRuns(0)
+	assert.NoError(t, err)
+	assert.Len(t, runs, 2)
+	assert.Equal(t, second.ID, runs[0].ID)
+	assert.Nil(t, runs[0].Funds)
+
+	runs, err = s.ListRuns(1)
+	assert.NoError(t, err)
+	assert.Len(t, runs, 1)
+
+	latest, err := s.Latest()
+	assert.NoError(t, err)
+	assert.Equal(t, second.ID, latest.ID)
+	assert.Len(t, latest.Funds, 1)
+
+	run, err := s.GetRun(first.ID)
+	assert.NoError(t, err)
+	assert.Len(t, run.Funds, 2)
+
+	_, err = s.GetRun("missing")
+	assert.Equal(t, storage.ErrSnapshotNotFound, err)
+
+	history, err := s.FundHistory("1", 0)
+	assert.NoError(t, err)
+	assert.Len(t, history, 2)
+	assert.Equal(t, "A2", history[0].Fund.Name)
+
+	history, err = s.FundHistory("1", 1)
+	assert.NoError(t, err)
+	assert.Len(t, history, 1)
+
+	history, err = s.FundHistory("3", 0)
+	assert.NoError(t, err)
+	assert.Len(t, history, 0)
+
+	assert.NoError(t, s.Close())
+}
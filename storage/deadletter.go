@@ -0,0 +1,50 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+const (
+	// DeadLetterKeyPrefix is key prefix of a single dead letter
+	DeadLetterKeyPrefix = "go_scrape-dead-letter:"
+	// DeadLetterListKey is key name of the sorted dead letter ids
+	DeadLetterListKey = "go_scrape-dead-letters"
+)
+
+const (
+	// KindScrape is the dead letter kind of a scrape job
+	KindScrape = "scrape"
+	// KindPush is the dead letter kind of a push notification
+	KindPush = "push"
+	// KindRaw is the dead letter kind of a message which can't be decoded,
+	// it can't be requeued
+	KindRaw = "raw"
+)
+
+// ErrDeadLetterNotFound is returned when the dead letter doesn't exist
+var ErrDeadLetterNotFound = errors.New("dead letter not found")
+
+// Attempt is a single failed try of a job
+type Attempt struct {
+	Attempt int       `json:"attempt"`
+	Error   string    `json:"error"`
+	At      time.Time `json:"at"`
+	// Stack of the attempt which panicked
+	Stack string `json:"stack,omitempty"`
+}
+
+// DeadLetter is a job which can't be completed, kept with its payload
+// until it is requeued or purged.
+type DeadLetter struct {
+	ID        string          `json:"id"`
+	Kind      string          `json:"kind"`
+	JobID     string          `json:"job_id,omitempty"`
+	Source    string          `json:"source,omitempty"`
+	Preset    string          `json:"preset,omitempty"`
+	Payload   json.RawMessage `json:"payload"`
+	Error     string          `json:"error"`
+	Attempts  []Attempt       `json:"attempts"`
+	CreatedAt time.Time       `json:"created_at"`
+}
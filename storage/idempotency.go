@@ -0,0 +1,4 @@
+package storage
+
+// IdempotencyKeyPrefix is key prefix of the job id owning an idempotency key
+const IdempotencyKeyPrefix = "go_scrape-idempotency:"
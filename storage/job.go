@@ -0,0 +1,91 @@
+package storage
+
+import (
+	"errors"
+	"time"
+)
+
+const (
+	// JobKeyPrefix is key prefix of a single job
+	JobKeyPrefix = "go_scrape-job:"
+	// JobListKey is key name of the sorted job ids
+	JobListKey = "go_scrape-jobs"
+	// JobStateKeyPrefix is key prefix of the sorted job ids of a state
+	JobStateKeyPrefix = "go_scrape-jobs-state:"
+
+	// JobRetention is how long a job is kept after the last update
+	JobRetention = 7 * 24 * time.Hour
+)
+
+// JobState is the lifecycle state of a queued job
+type JobState string
+
+const (
+	// JobScheduled is held by the queue until it's due
+	JobScheduled JobState = "scheduled"
+	// JobQueued is waiting in the queue
+	JobQueued JobState = "queued"
+	// JobRunning is picked up by a worker
+	JobRunning JobState = "running"
+	// JobSucceeded is finished without error
+	JobSucceeded JobState = "succeeded"
+	// JobFailed is finished with error
+	JobFailed JobState = "failed"
+	// JobRetried failed and is waiting for another attempt
+	JobRetried JobState = "retried"
+	// JobCancelled is stopped before it finished
+	JobCancelled JobState = "cancelled"
+)
+
+// JobStates lists all valid states
+var JobStates = []JobState{JobScheduled, JobQueued, JobRunning, JobSucceeded, JobFailed, JobRetried, JobCancelled}
+
+// ErrJobNotFound is returned when the job doesn't exist
+var ErrJobNotFound = errors.New("job not found")
+
+// IsValid report whether the state is known
+func (s JobState) IsValid() bool {
+	for _, state := range JobStates {
+		if s == state {
+			return true
+		}
+	}
+	return false
+}
+
+// IsFinal report whether the job will not change anymore
+func (s JobState) IsFinal() bool {
+	return s == JobSucceeded || s == JobFailed || s == JobCancelled
+}
+
+// CanMoveTo report whether the job in the state may move into next. The
+// succeeded and cancelled jobs never change, a failed job only runs again
+// when it's delivered again.
+func (s JobState) CanMoveTo(next JobState) bool {
+	switch s {
+	case JobSucceeded, JobCancelled:
+		return false
+	case JobFailed:
+		return next == JobRunning
+	}
+	return true
+}
+
+// Job is the lifecycle record of a queued job
+type Job struct {
+	ID             string     `json:"id"`
+	Source         string     `json:"source"`
+	Preset         string     `json:"preset"`
+	State          JobState   `json:"state"`
+	Attempt        int        `json:"attempt"`
+	Error          string     `json:"error,omitempty"`
+	Stack          string     `json:"stack,omitempty"`
+	RunID          string     `json:"run_id,omitempty"`
+	FundCount      int        `json:"fund_count"`
+	RunAt          *time.Time `json:"run_at,omitempty"`
+	IdempotencyKey string     `json:"idempotency_key,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+	StartedAt      *time.Time `json:"started_at,omitempty"`
+	FinishedAt     *time.Time `json:"finished_at,omitempty"`
+}
@@ -0,0 +1,52 @@
+package memory
+
+import (
+	"testing"
+	"time"
+
+	"github.com/natansdj/go_scrape/storage"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeadLetterEngine(t *testing.T) {
+	memory := New()
+	assert.NoError(t, memory.Init())
+
+	_, err := memory.GetDeadLetter("missing")
+	assert.Equal(t, storage.ErrDeadLetterNotFound, err)
+	assert.Equal(t, storage.ErrDeadLetterNotFound, memory.DeleteDeadLetter("missing"))
+
+	now := time.Now()
+	for _, id := range []string{"1", "2", "3"} {
+		assert.NoError(t, memory.SaveDeadLetter(&storage.DeadLetter{
+			ID:        id,
+			Kind:      storage.KindScrape,
+			Payload:   []byte(`{"preset":"default"}`),
+			Error:     "timeout",
+			CreatedAt: now,
+		}))
+	}
+
+	dl, err := memory.GetDeadLetter("2")
+	assert.NoError(t, err)
+	assert.Equal(t, "timeout", dl.Error)
+
+	dls, err := memory.ListDeadLetters(2)
+	assert.NoError(t, err)
+	assert.Len(t, dls, 2)
+	assert.Equal(t, "3", dls[0].ID)
+
+	assert.NoError(t, memory.DeleteDeadLetter("2"))
+	dls, err = memory.ListDeadLetters(0)
+	assert.NoError(t, err)
+	assert.Len(t, dls, 2)
+
+	count, err := memory.PurgeDeadLetters()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	dls, err = memory.ListDeadLetters(0)
+	assert.NoError(t, err)
+	assert.Len(t, dls, 0)
+}
@@ -0,0 +1,32 @@
+package memory
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIdempotencyKey(t *testing.T) {
+	memory := New()
+	assert.NoError(t, memory.Init())
+
+	owner, err := memory.ClaimIdempotencyKey("key", "1", time.Minute)
+	assert.NoError(t, err)
+	assert.Equal(t, "1", owner)
+
+	owner, err = memory.ClaimIdempotencyKey("key", "2", time.Minute)
+	assert.NoError(t, err)
+	assert.Equal(t, "1", owner)
+
+	assert.NoError(t, memory.DeleteIdempotencyKey("key"))
+	owner, err = memory.ClaimIdempotencyKey("key", "2", time.Millisecond)
+	assert.NoError(t, err)
+	assert.Equal(t, "2", owner)
+
+	// the expired key is claimed again
+	time.Sleep(5 * time.Millisecond)
+	owner, err = memory.ClaimIdempotencyKey("key", "3", time.Minute)
+	assert.NoError(t, err)
+	assert.Equal(t, "3", owner)
+}
@@ -0,0 +1,87 @@
+package memory
+
+import (
+	"testing"
+	"time"
+
+	"github.com/natansdj/go_scrape/storage"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJobEngine(t *testing.T) {
+	memory := New()
+	assert.NoError(t, memory.Init())
+
+	_, err := memory.GetJob("missing")
+	assert.Equal(t, storage.ErrJobNotFound, err)
+
+	now := time.Now()
+	first := &storage.Job{ID: "1", State: storage.JobQueued, CreatedAt: now, UpdatedAt: now}
+	second := &storage.Job{ID: "2", State: storage.JobQueued, CreatedAt: now, UpdatedAt: now}
+	assert.NoError(t, memory.SaveJob(first))
+	assert.NoError(t, memory.SaveJob(second))
+
+	first.State = storage.JobSucceeded
+	first.RunID = "run"
+	assert.NoError(t, memory.SaveJob(first))
+
+	job, err := memory.GetJob("1")
+	assert.NoError(t, err)
+	assert.Equal(t, storage.JobSucceeded, job.State)
+	assert.Equal(t, "run", job.RunID)
+
+	jobs, err := memory.ListJobs("", 0)
+	assert.NoError(t, err)
+	assert.Len(t, jobs, 2)
+	assert.Equal(t, "2", jobs[0].ID)
+
+	jobs, err = memory.ListJobs(storage.JobQueued, 0)
+	assert.NoError(t, err)
+	assert.Len(t, jobs, 1)
+	assert.Equal(t, "2", jobs[0].ID)
+
+	jobs, err = memory.ListJobs("", 1)
+	assert.NoError(t, err)
+	assert.Len(t, jobs, 1)
+
+	memory.Reset()
+	jobs, err = memory.ListJobs("", 0)
+	assert.NoError(t, err)
+	assert.Len(t, jobs, 0)
+}
+
+func TestUpdateJob(t *testing.T) {
+	memory := New()
+	assert.NoError(t, memory.Init())
+
+	assert.NoError(t, memory.UpdateJob("1", func(job *storage.Job) *storage.Job {
+		assert.Nil(t, job)
+		return &storage.Job{ID: "1", State: storage.JobQueued}
+	}))
+
+	assert.NoError(t, memory.UpdateJob("1", func(job *storage.Job) *storage.Job {
+		assert.Equal(t, storage.JobQueued, job.State)
+		job.State = storage.JobCancelled
+		return job
+	}))
+
+	// nil saves nothing
+	assert.NoError(t, memory.UpdateJob("1", func(job *storage.Job) *storage.Job {
+		job.State = storage.JobSucceeded
+		return nil
+	}))
+
+	job, err := memory.GetJob("1")
+	assert.NoError(t, err)
+	assert.Equal(t, storage.JobCancelled, job.State)
+}
+
+func TestJobStateCanMoveTo(t *testing.T) {
+	assert.True(t, storage.JobQueued.CanMoveTo(storage.JobRunning))
+	assert.True(t, storage.JobRunning.CanMoveTo(storage.JobCancelled))
+	assert.True(t, storage.JobFailed.CanMoveTo(storage.JobRunning))
+	assert.False(t, storage.JobFailed.CanMoveTo(storage.JobSucceeded))
+	assert.False(t, storage.JobCancelled.CanMoveTo(storage.JobSucceeded))
+	assert.False(t, storage.JobSucceeded.CanMoveTo(storage.JobRunning))
+}
@@ -1,24 +1,81 @@
 package memory
 
 import (
+	"sync"
 	"sync/atomic"
+	"time"
+
+	"github.com/natansdj/go_scrape/storage"
+)
+
+const (
+	// MaxJobs is the number of job records kept in memory
+	MaxJobs = 10000
+	// MaxDeadLetters is the number of dead letters kept in memory
+	MaxDeadLetters = 10000
+	// MaxResponses is the number of upstream responses kept in memory
+	MaxResponses = 1000
 )
 
 // statApp is app status structure
 type statApp struct {
-	TotalCount int64 `json:"total_count"`
+	TotalCount   int64 `json:"total_count"`
+	SuccessCount int64 `json:"success_count"`
+	FailureCount int64 `json:"failure_count"`
+	RetryCount   int64 `json:"retry_count"`
+	PanicCount   int64 `json:"panic_count"`
+	// RateLimitWait in millisecond
+	RateLimitWait int64 `json:"rate_limit_wait"`
+	CacheHit      int64 `json:"cache_hit"`
+	CacheMiss     int64 `json:"cache_miss"`
 }
 
 // New func implements the storage interface for go_scrape (https://github.com/natansdj/go_scrape)
 func New() *Storage {
 	return &Storage{
-		stat: &statApp{},
+		stat:        &statApp{},
+		jobs:        map[string]*storage.Job{},
+		deadLetters: map[string]*storage.DeadLetter{},
+		idempotency: map[string]idempotencyKey{},
+		rateLimits:  map[string]rateWindow{},
+		responses:   map[string]cachedResponse{},
 	}
 }
 
+// idempotencyKey is the job id owning a key until it expires
+type idempotencyKey struct {
+	id      string
+	expires time.Time
+}
+
+// rateWindow is the request count of the window starting at start
+type rateWindow struct {
+	start time.Time
+	count int64
+}
+
+// cachedResponse is the upstream response kept until it expires
+type cachedResponse struct {
+	resp    storage.Response
+	expires time.Time
+}
+
 // Storage is interface structure
 type Storage struct {
 	stat *statApp
+
+	sync.RWMutex
+	jobs map[string]*storage.Job
+	// jobIDs ordered oldest first
+	jobIDs []string
+
+	deadLetters map[string]*storage.DeadLetter
+	// deadLetterIDs ordered oldest first
+	deadLetterIDs []string
+
+	idempotency map[string]idempotencyKey
+	rateLimits  map[string]rateWindow
+	responses   map[string]cachedResponse
 }
 
 // Init client storage.
@@ -34,6 +91,20 @@ func (s *Storage) Close() error {
 // Reset Client storage.
 func (s *Storage) Reset() {
 	atomic.StoreInt64(&s.stat.TotalCount, 0)
+	atomic.StoreInt64(&s.stat.SuccessCount, 0)
+	atomic.StoreInt64(&s.stat.FailureCount, 0)
+	atomic.StoreInt64(&s.stat.RetryCount, 0)
+	atomic.StoreInt64(&s.stat.PanicCount, 0)
+	atomic.StoreInt64(&s.stat.RateLimitWait, 0)
+	atomic.StoreInt64(&s.stat.CacheHit, 0)
+	atomic.StoreInt64(&s.stat.CacheMiss, 0)
+
+	s.Lock()
+	s.jobs = map[string]*storage.Job{}
+	s.jobIDs = nil
+	s.deadLetters = map[string]*storage.DeadLetter{}
+	s.deadLetterIDs = nil
+	s.Unlock()
 }
 
 // AddTotalCount record push notification count.
@@ -41,9 +112,342 @@ func (s *Storage) AddTotalCount(count int64) {
 	atomic.AddInt64(&s.stat.TotalCount, count)
 }
 
+// AddSuccessCount record succeeded scrape job count.
+func (s *Storage) AddSuccessCount(count int64) {
+	atomic.AddInt64(&s.stat.SuccessCount, count)
+}
+
+// AddFailureCount record failed scrape job count.
+func (s *Storage) AddFailureCount(count int64) {
+	atomic.AddInt64(&s.stat.FailureCount, count)
+}
+
+// AddRetryCount record retried upstream request count.
+func (s *Storage) AddRetryCount(count int64) {
+	atomic.AddInt64(&s.stat.RetryCount, count)
+}
+
+// AddPanicCount record recovered panic count.
+func (s *Storage) AddPanicCount(count int64) {
+	atomic.AddInt64(&s.stat.PanicCount, count)
+}
+
 // GetTotalCount show counts of all notification.
 func (s *Storage) GetTotalCount() int64 {
 	count := atomic.LoadInt64(&s.stat.TotalCount)
 
 	return count
 }
+
+// GetSuccessCount show counts of succeeded scrape job.
+func (s *Storage) GetSuccessCount() int64 {
+	count := atomic.LoadInt64(&s.stat.SuccessCount)
+
+	return count
+}
+
+// GetFailureCount show counts of failed scrape job.
+func (s *Storage) GetFailureCount() int64 {
+	count := atomic.LoadInt64(&s.stat.FailureCount)
+
+	return count
+}
+
+// GetRetryCount show counts of retried upstream request.
+func (s *Storage) GetRetryCount() int64 {
+	count := atomic.LoadInt64(&s.stat.RetryCount)
+
+	return count
+}
+
+// AddRateLimitWait record the time in millisecond spent waiting for the
+// upstream rate limits.
+func (s *Storage) AddRateLimitWait(ms int64) {
+	atomic.AddInt64(&s.stat.RateLimitWait, ms)
+}
+
+// GetRateLimitWait show the time in millisecond spent waiting for the
+// upstream rate limits.
+func (s *Storage) GetRateLimitWait() int64 {
+	return atomic.LoadInt64(&s.stat.RateLimitWait)
+}
+
+// AddCacheHit record upstream responses served from the cache.
+func (s *Storage) AddCacheHit(count int64) {
+	atomic.AddInt64(&s.stat.CacheHit, count)
+}
+
+// AddCacheMiss record upstream responses missing in the cache.
+func (s *Storage) AddCacheMiss(count int64) {
+	atomic.AddInt64(&s.stat.CacheMiss, count)
+}
+
+// GetCacheHit show counts of upstream responses served from the cache.
+func (s *Storage) GetCacheHit() int64 {
+	return atomic.LoadInt64(&s.stat.CacheHit)
+}
+
+// GetCacheMiss show counts of upstream responses missing in the cache.
+func (s *Storage) GetCacheMiss() int64 {
+	return atomic.LoadInt64(&s.stat.CacheMiss)
+}
+
+// GetPanicCount show counts of recovered panic.
+func (s *Storage) GetPanicCount() int64 {
+	count := atomic.LoadInt64(&s.stat.PanicCount)
+
+	return count
+}
+
+// SaveJob create or update the job record.
+func (s *Storage) SaveJob(job *storage.Job) error {
+	s.Lock()
+	defer s.Unlock()
+
+	s.saveJob(job)
+
+	return nil
+}
+
+// UpdateJob change the job record under the lock, fn gets nil when the job
+// doesn't exist and returns the record to save, nil saves nothing.
+func (s *Storage) UpdateJob(id string, fn func(*storage.Job) *storage.Job) error {
+	s.Lock()
+	defer s.Unlock()
+
+	var job *storage.Job
+	if cur, ok := s.jobs[id]; ok {
+		cp := *cur
+		job = &cp
+	}
+
+	if job = fn(job); job != nil {
+		s.saveJob(job)
+	}
+
+	return nil
+}
+
+// saveJob keep a copy of the job, the caller holds the lock
+func (s *Storage) saveJob(job *storage.Job) {
+	cp := *job
+	if _, ok := s.jobs[job.ID]; !ok {
+		s.jobIDs = append(s.jobIDs, job.ID)
+	}
+	s.jobs[job.ID] = &cp
+
+	for len(s.jobIDs) > MaxJobs {
+		delete(s.jobs, s.jobIDs[0])
+		s.jobIDs = s.jobIDs[1:]
+	}
+}
+
+// GetJob returns a single job record.
+func (s *Storage) GetJob(id string) (*storage.Job, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	job, ok := s.jobs[id]
+	if !ok {
+		return nil, storage.ErrJobNotFound
+	}
+
+	cp := *job
+	return &cp, nil
+}
+
+// ListJobs returns jobs newest first, empty state returns every job.
+func (s *Storage) ListJobs(state storage.JobState, limit int) ([]storage.Job, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	jobs := []storage.Job{}
+	for i := len(s.jobIDs) - 1; i >= 0; i-- {
+		if limit > 0 && len(jobs) >= limit {
+			break
+		}
+
+		job := s.jobs[s.jobIDs[i]]
+		if state != "" && job.State != state {
+			continue
+		}
+		jobs = append(jobs, *job)
+	}
+
+	return jobs, nil
+}
+
+// SaveDeadLetter create or update the dead letter.
+func (s *Storage) SaveDeadLetter(dl *storage.DeadLetter) error {
+	s.Lock()
+	defer s.Unlock()
+
+	cp := *dl
+	if _, ok := s.deadLetters[dl.ID]; !ok {
+		s.deadLetterIDs = append(s.deadLetterIDs, dl.ID)
+	}
+	s.deadLetters[dl.ID] = &cp
+
+	for len(s.deadLetterIDs) > MaxDeadLetters {
+		delete(s.deadLetters, s.deadLetterIDs[0])
+		s.deadLetterIDs = s.deadLetterIDs[1:]
+	}
+
+	return nil
+}
+
+// GetDeadLetter returns a single dead letter.
+func (s *Storage) GetDeadLetter(id string) (*storage.DeadLetter, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	dl, ok := s.deadLetters[id]
+	if !ok {
+		return nil, storage.ErrDeadLetterNotFound
+	}
+
+	cp := *dl
+	return &cp, nil
+}
+
+// ListDeadLetters returns dead letters newest first.
+func (s *Storage) ListDeadLetters(limit int) ([]storage.DeadLetter, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	dls := []storage.DeadLetter{}
+	for i := len(s.deadLetterIDs) - 1; i >= 0; i-- {
+		if limit > 0 && len(dls) >= limit {
+			break
+		}
+		dls = append(dls, *s.deadLetters[s.deadLetterIDs[i]])
+	}
+
+	return dls, nil
+}
+
+// DeleteDeadLetter remove a single dead letter.
+func (s *Storage) DeleteDeadLetter(id string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if _, ok := s.deadLetters[id]; !ok {
+		return storage.ErrDeadLetterNotFound
+	}
+
+	delete(s.deadLetters, id)
+	for i, v := range s.deadLetterIDs {
+		if v == id {
+			s.deadLetterIDs = append(s.deadLetterIDs[:i], s.deadLetterIDs[i+1:]...)
+			break
+		}
+	}
+
+	return nil
+}
+
+// PurgeDeadLetters delete every dead letter and returns the count.
+func (s *Storage) PurgeDeadLetters() (int, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	count := len(s.deadLetterIDs)
+	s.deadLetters = map[string]*storage.DeadLetter{}
+	s.deadLetterIDs = nil
+
+	return count, nil
+}
+
+// ClaimIdempotencyKey store the job id under the key for ttl unless the
+// key is owned already, it returns the id of the owner.
+func (s *Storage) ClaimIdempotencyKey(key, id string, ttl time.Duration) (string, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	now := time.Now()
+	for k, v := range s.idempotency {
+		if !v.expires.After(now) {
+			delete(s.idempotency, k)
+		}
+	}
+
+	if v, ok := s.idempotency[key]; ok {
+		return v.id, nil
+	}
+
+	s.idempotency[key] = idempotencyKey{id: id, expires: now.Add(ttl)}
+	return id, nil
+}
+
+// DeleteIdempotencyKey remove the key, so the next claim takes it.
+func (s *Storage) DeleteIdempotencyKey(key string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	delete(s.idempotency, key)
+	return nil
+}
+
+// TakeRateLimit count a request in the current window of the key, it
+// returns the delay until the next window once limit is reached.
+func (s *Storage) TakeRateLimit(key string, limit int64, window time.Duration) (time.Duration, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	now := time.Now()
+	start := now.Truncate(window)
+
+	w := s.rateLimits[key]
+	if !w.start.Equal(start) {
+		w = rateWindow{start: start}
+	}
+	if w.count >= limit {
+		return start.Add(window).Sub(now), nil
+	}
+
+	w.count++
+	s.rateLimits[key] = w
+	return 0, nil
+}
+
+// SaveResponse keep the upstream response under the key for ttl, the
+// expired responses are dropped first then any response once MaxResponses
+// is reached.
+func (s *Storage) SaveResponse(key string, resp *storage.Response, ttl time.Duration) error {
+	s.Lock()
+	defer s.Unlock()
+
+	now := time.Now()
+	if _, ok := s.responses[key]; !ok && len(s.responses) >= MaxResponses {
+		for k, v := range s.responses {
+			if !v.expires.After(now) {
+				delete(s.responses, k)
+			}
+		}
+		for k := range s.responses {
+			if len(s.responses) < MaxResponses {
+				break
+			}
+			delete(s.responses, k)
+		}
+	}
+
+	cp := *resp
+	cp.Body = append([]byte(nil), resp.Body...)
+	s.responses[key] = cachedResponse{resp: cp, expires: now.Add(ttl)}
+	return nil
+}
+
+// GetResponse returns the cached upstream response
+func (s *Storage) GetResponse(key string) (*storage.Response, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	v, ok := s.responses[key]
+	if !ok || !v.expires.After(time.Now()) {
+		return nil, storage.ErrResponseNotFound
+	}
+
+	cp := v.resp
+	return &cp, nil
+}
@@ -21,10 +21,14 @@ func TestMemoryEngine(t *testing.T) {
 	val = memory.GetTotalCount()
 	assert.Equal(t, int64(101), val)
 
+	memory.AddPanicCount(2)
+	assert.Equal(t, int64(2), memory.GetPanicCount())
+
 	// test reset db
 	memory.Reset()
 	val = memory.GetTotalCount()
 	assert.Equal(t, int64(0), val)
+	assert.Equal(t, int64(0), memory.GetPanicCount())
 
 	assert.NoError(t, memory.Close())
 }
@@ -0,0 +1,33 @@
+package memory
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimit(t *testing.T) {
+	memory := New()
+	assert.NoError(t, memory.Init())
+
+	for i := 0; i < 2; i++ {
+		wait, err := memory.TakeRateLimit("indopremier", 2, time.Hour)
+		assert.NoError(t, err)
+		assert.Zero(t, wait)
+	}
+
+	wait, err := memory.TakeRateLimit("indopremier", 2, time.Hour)
+	assert.NoError(t, err)
+	assert.True(t, wait > 0 && wait <= time.Hour)
+
+	// every key has its own window
+	wait, err = memory.TakeRateLimit("other", 2, time.Hour)
+	assert.NoError(t, err)
+	assert.Zero(t, wait)
+
+	memory.AddRateLimitWait(250)
+	assert.Equal(t, int64(250), memory.GetRateLimitWait())
+	memory.Reset()
+	assert.Zero(t, memory.GetRateLimitWait())
+}
@@ -0,0 +1,40 @@
+package memory
+
+import (
+	"testing"
+	"time"
+
+	"github.com/natansdj/go_scrape/storage"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResponse(t *testing.T) {
+	s := New()
+
+	_, err := s.GetResponse("key")
+	assert.Equal(t, storage.ErrResponseNotFound, err)
+
+	resp := &storage.Response{Body: []byte("funds"), ETag: `"v1"`, StoredAt: time.Now()}
+	assert.NoError(t, s.SaveResponse("key", resp, time.Hour))
+	assert.NoError(t, s.SaveResponse("expired", resp, time.Nanosecond))
+
+	// the stored body is a copy
+	resp.Body[0] = 'F'
+	got, err := s.GetResponse("key")
+	assert.NoError(t, err)
+	assert.Equal(t, "funds", string(got.Body))
+	assert.Equal(t, `"v1"`, got.ETag)
+
+	time.Sleep(time.Millisecond)
+	_, err = s.GetResponse("expired")
+	assert.Equal(t, storage.ErrResponseNotFound, err)
+
+	s.AddCacheHit(2)
+	s.AddCacheMiss(1)
+	assert.Equal(t, int64(2), s.GetCacheHit())
+	assert.Equal(t, int64(1), s.GetCacheMiss())
+	s.Reset()
+	assert.Zero(t, s.GetCacheHit())
+	assert.Zero(t, s.GetCacheMiss())
+}
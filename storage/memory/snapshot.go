@@ -0,0 +1,109 @@
+package memory
+
+import (
+	"sync"
+
+	"github.com/natansdj/go_scrape/storage"
+)
+
+var _ storage.SnapshotStore = (*SnapshotStore)(nil)
+
+// NewSnapshot func implements the snapshot store interface in memory
+func NewSnapshot() *SnapshotStore {
+	return &SnapshotStore{
+		history: map[string][]storage.FundPoint{},
+	}
+}
+
+// SnapshotStore keep scrape runs in memory, history is lost on restart.
+type SnapshotStore struct {
+	sync.RWMutex
+	// runs ordered oldest first
+	runs    []*storage.Snapshot
+	history map[string][]storage.FundPoint
+}
+
+// Init client storage.
+func (s *SnapshotStore) Init() error {
+	return nil
+}
+
+// Close the storage connection
+func (s *SnapshotStore) Close() error {
+	return nil
+}
+
+// SaveRun store the run and index every fund by id
+func (s *SnapshotStore) SaveRun(run *storage.Snapshot) error {
+	s.Lock()
+	defer s.Unlock()
+
+	cp := *run
+	s.runs = append(s.runs, &cp)
+	for _, p := range cp.Points() {
+		s.history[p.Fund.ID] = append(s.history[p.Fund.ID], p)
+	}
+
+	return nil
+}
+
+// ListRuns returns runs newest first without funds
+func (s *SnapshotStore) ListRuns(limit int) ([]storage.Snapshot, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	runs := make([]storage.Snapshot, 0, len(s.runs))
+	for i := len(s.runs) - 1; i >= 0; i-- {
+		if limit > 0 && len(runs) >= limit {
+			break
+		}
+		runs = append(runs, s.runs[i].Summary())
+	}
+
+	return runs, nil
+}
+
+// GetRun returns a single run with funds
+func (s *SnapshotStore) GetRun(id string) (*storage.Snapshot, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	for _, run := range s.runs {
+		if run.ID == id {
+			cp := *run
+			return &cp, nil
+		}
+	}
+
+	return nil, storage.ErrSnapshotNotFound
+}
+
+// Latest returns the newest run with funds
+func (s *SnapshotStore) Latest() (*storage.Snapshot, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	if len(s.runs) == 0 {
+		return nil, storage.ErrSnapshotNotFound
+	}
+
+	cp := *s.runs[len(s.runs)-1]
+	return &cp, nil
+}
+
+// FundHistory returns the values of a fund newest first
+func (s *SnapshotStore) FundHistory(fundID string, limit int) ([]storage.FundPoint, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	points := s.history[fundID]
+	result := make([]storage.FundPoint, 0, len(points))
+	for i := len(points) - 1; i >= 0; i-- {
+		if limit > 0 && len(result) >= limit {
+			break
+		}
+		result = append(result, points[i])
+	}
+
+	return result, nil
+}
@@ -0,0 +1,5 @@
+package storage
+
+// RateLimitKeyPrefix is key prefix of the request count of a rate limit
+// window
+const RateLimitKeyPrefix = "go_scrape-rate-limit:"
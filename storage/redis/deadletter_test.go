@@ -0,0 +1,66 @@
+package redis
+
+import (
+	"testing"
+	"time"
+
+	"github.com/natansdj/go_scrape/config"
+	"github.com/natansdj/go_scrape/storage"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeadLetterEngine(t *testing.T) {
+	mr, err := miniredis.Run()
+	assert.NoError(t, err)
+	defer mr.Close()
+
+	cfg := config.ConfYaml{}
+	cfg.Stat.Redis.Addr = mr.Addr()
+
+	redis := New(cfg)
+	assert.NoError(t, redis.Init())
+
+	_, err = redis.GetDeadLetter("missing")
+	assert.Equal(t, storage.ErrDeadLetterNotFound, err)
+	assert.Equal(t, storage.ErrDeadLetterNotFound, redis.DeleteDeadLetter("missing"))
+
+	now := time.Now()
+	for i, id := range []string{"1", "2", "3"} {
+		assert.NoError(t, redis.SaveDeadLetter(&storage.DeadLetter{
+			ID:        id,
+			Kind:      storage.KindScrape,
+			Payload:   []byte(`{"preset":"default"}`),
+			Error:     "timeout",
+			Attempts:  []storage.Attempt{{Attempt: 1, Error: "timeout", At: now}},
+			CreatedAt: now.Add(time.Duration(i) * time.Second),
+		}))
+	}
+
+	dl, err := redis.GetDeadLetter("2")
+	assert.NoError(t, err)
+	assert.Equal(t, "timeout", dl.Error)
+	assert.JSONEq(t, `{"preset":"default"}`, string(dl.Payload))
+	assert.Len(t, dl.Attempts, 1)
+
+	dls, err := redis.ListDeadLetters(2)
+	assert.NoError(t, err)
+	assert.Len(t, dls, 2)
+	assert.Equal(t, "3", dls[0].ID)
+
+	assert.NoError(t, redis.DeleteDeadLetter("2"))
+	dls, err = redis.ListDeadLetters(0)
+	assert.NoError(t, err)
+	assert.Len(t, dls, 2)
+
+	count, err := redis.PurgeDeadLetters()
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	dls, err = redis.ListDeadLetters(0)
+	assert.NoError(t, err)
+	assert.Len(t, dls, 0)
+
+	assert.NoError(t, redis.Close())
+}
@@ -0,0 +1,44 @@
+package redis
+
+import (
+	"testing"
+	"time"
+
+	"github.com/natansdj/go_scrape/config"
+	"github.com/natansdj/go_scrape/storage"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIdempotencyKey(t *testing.T) {
+	mr, err := miniredis.Run()
+	assert.NoError(t, err)
+	defer mr.Close()
+
+	cfg := config.ConfYaml{}
+	cfg.Stat.Redis.Addr = mr.Addr()
+
+	redis := New(cfg)
+	assert.NoError(t, redis.Init())
+
+	owner, err := redis.ClaimIdempotencyKey("key", "1", time.Minute)
+	assert.NoError(t, err)
+	assert.Equal(t, "1", owner)
+	assert.Equal(t, time.Minute, mr.TTL(storage.IdempotencyKeyPrefix+"key"))
+
+	owner, err = redis.ClaimIdempotencyKey("key", "2", time.Minute)
+	assert.NoError(t, err)
+	assert.Equal(t, "1", owner)
+
+	// the expired key is claimed again
+	mr.FastForward(time.Minute)
+	owner, err = redis.ClaimIdempotencyKey("key", "2", time.Minute)
+	assert.NoError(t, err)
+	assert.Equal(t, "2", owner)
+
+	assert.NoError(t, redis.DeleteIdempotencyKey("key"))
+	owner, err = redis.ClaimIdempotencyKey("key", "3", time.Minute)
+	assert.NoError(t, err)
+	assert.Equal(t, "3", owner)
+}
@@ -0,0 +1,104 @@
+package redis
+
+import (
+	"testing"
+	"time"
+
+	"github.com/natansdj/go_scrape/config"
+	"github.com/natansdj/go_scrape/storage"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJobEngine(t *testing.T) {
+	mr, err := miniredis.Run()
+	assert.NoError(t, err)
+	defer mr.Close()
+
+	cfg := config.ConfYaml{}
+	cfg.Stat.Redis.Addr = mr.Addr()
+
+	redis := New(cfg)
+	assert.NoError(t, redis.Init())
+
+	_, err = redis.GetJob("missing")
+	assert.Equal(t, storage.ErrJobNotFound, err)
+
+	now := time.Now()
+	first := &storage.Job{ID: "1", State: storage.JobQueued, CreatedAt: now, UpdatedAt: now}
+	second := &storage.Job{ID: "2", State: storage.JobQueued, CreatedAt: now.Add(time.Second), UpdatedAt: now}
+	assert.NoError(t, redis.SaveJob(first))
+	assert.NoError(t, redis.SaveJob(second))
+
+	first.State = storage.JobFailed
+	first.Error = "timeout"
+	assert.NoError(t, redis.SaveJob(first))
+
+	job, err := redis.GetJob("1")
+	assert.NoError(t, err)
+	assert.Equal(t, storage.JobFailed, job.State)
+	assert.Equal(t, "timeout", job.Error)
+	assert.True(t, mr.TTL(storage.JobKeyPrefix+"1") > 0)
+
+	jobs, err := redis.ListJobs("", 0)
+	assert.NoError(t, err)
+	assert.Len(t, jobs, 2)
+	assert.Equal(t, "2", jobs[0].ID)
+
+	jobs, err = redis.ListJobs(storage.JobQueued, 0)
+	assert.NoError(t, err)
+	assert.Len(t, jobs, 1)
+	assert.Equal(t, "2", jobs[0].ID)
+
+	jobs, err = redis.ListJobs(storage.JobFailed, 1)
+	assert.NoError(t, err)
+	assert.Len(t, jobs, 1)
+	assert.Equal(t, "1", jobs[0].ID)
+
+	assert.NoError(t, redis.Close())
+}
+
+func TestUpdateJob(t *testing.T) {
+	mr, err := miniredis.Run()
+	assert.NoError(t, err)
+	defer mr.Close()
+
+	cfg := config.ConfYaml{}
+	cfg.Stat.Redis.Addr = mr.Addr()
+
+	redis := New(cfg)
+	assert.NoError(t, redis.Init())
+
+	now := time.Now()
+	assert.NoError(t, redis.UpdateJob("1", func(job *storage.Job) *storage.Job {
+		assert.Nil(t, job)
+		return &storage.Job{ID: "1", State: storage.JobRunning, CreatedAt: now}
+	}))
+
+	// the record changed during the update is read again
+	var calls int
+	assert.NoError(t, redis.UpdateJob("1", func(job *storage.Job) *storage.Job {
+		calls++
+		if calls == 1 {
+			assert.Equal(t, storage.JobRunning, job.State)
+			assert.NoError(t, redis.SaveJob(&storage.Job{ID: "1", State: storage.JobCancelled, CreatedAt: now}))
+		}
+		if !job.State.CanMoveTo(storage.JobSucceeded) {
+			return nil
+		}
+		job.State = storage.JobSucceeded
+		return job
+	}))
+	assert.Equal(t, 2, calls)
+
+	job, err := redis.GetJob("1")
+	assert.NoError(t, err)
+	assert.Equal(t, storage.JobCancelled, job.State)
+
+	jobs, err := redis.ListJobs(storage.JobCancelled, 0)
+	assert.NoError(t, err)
+	assert.Len(t, jobs, 1)
+
+	assert.NoError(t, redis.Close())
+}
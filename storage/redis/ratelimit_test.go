@@ -0,0 +1,45 @@
+package redis
+
+import (
+	"testing"
+	"time"
+
+	"github.com/natansdj/go_scrape/config"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimit(t *testing.T) {
+	mr, err := miniredis.Run()
+	assert.NoError(t, err)
+	defer mr.Close()
+
+	cfg := config.ConfYaml{}
+	cfg.Stat.Redis.Addr = mr.Addr()
+
+	// two instances share the window
+	first := New(cfg)
+	assert.NoError(t, first.Init())
+	second := New(cfg)
+	assert.NoError(t, second.Init())
+
+	wait, err := first.TakeRateLimit("indopremier", 2, time.Hour)
+	assert.NoError(t, err)
+	assert.Zero(t, wait)
+	wait, err = second.TakeRateLimit("indopremier", 2, time.Hour)
+	assert.NoError(t, err)
+	assert.Zero(t, wait)
+
+	wait, err = first.TakeRateLimit("indopremier", 2, time.Hour)
+	assert.NoError(t, err)
+	assert.True(t, wait > 0 && wait <= time.Hour)
+
+	first.AddRateLimitWait(250)
+	second.AddRateLimitWait(100)
+	assert.Equal(t, int64(350), first.GetRateLimitWait())
+
+	mr.Close()
+	_, err = first.TakeRateLimit("indopremier", 2, time.Hour)
+	assert.Error(t, err)
+}
@@ -1,7 +1,9 @@
 package redis
 
 import (
+	"encoding/json"
 	"strconv"
+	"time"
 
 	"github.com/natansdj/go_scrape/config"
 	"github.com/natansdj/go_scrape/storage"
@@ -51,6 +53,13 @@ func (s *Storage) Close() error {
 // Reset Client storage.
 func (s *Storage) Reset() {
 	s.client.Set(storage.TotalCountKey, int64(0), 0)
+	s.client.Set(storage.SuccessCountKey, int64(0), 0)
+	s.client.Set(storage.FailureCountKey, int64(0), 0)
+	s.client.Set(storage.RetryCountKey, int64(0), 0)
+	s.client.Set(storage.PanicCountKey, int64(0), 0)
+	s.client.Set(storage.RateLimitWaitKey, int64(0), 0)
+	s.client.Set(storage.CacheHitKey, int64(0), 0)
+	s.client.Set(storage.CacheMissKey, int64(0), 0)
 }
 
 // AddTotalCount record push notification count.
@@ -58,6 +67,26 @@ func (s *Storage) AddTotalCount(count int64) {
 	s.client.IncrBy(storage.TotalCountKey, count)
 }
 
+// AddSuccessCount record succeeded scrape job count.
+func (s *Storage) AddSuccessCount(count int64) {
+	s.client.IncrBy(storage.SuccessCountKey, count)
+}
+
+// AddFailureCount record failed scrape job count.
+func (s *Storage) AddFailureCount(count int64) {
+	s.client.IncrBy(storage.FailureCountKey, count)
+}
+
+// AddRetryCount record retried upstream request count.
+func (s *Storage) AddRetryCount(count int64) {
+	s.client.IncrBy(storage.RetryCountKey, count)
+}
+
+// AddPanicCount record recovered panic count.
+func (s *Storage) AddPanicCount(count int64) {
+	s.client.IncrBy(storage.PanicCountKey, count)
+}
+
 // GetTotalCount show counts of all notification.
 func (s *Storage) GetTotalCount() int64 {
 	var count int64
@@ -65,3 +94,362 @@ func (s *Storage) GetTotalCount() int64 {
 
 	return count
 }
+
+// GetSuccessCount show counts of succeeded scrape job.
+func (s *Storage) GetSuccessCount() int64 {
+	var count int64
+	s.getInt64(storage.SuccessCountKey, &count)
+
+	return count
+}
+
+// GetFailureCount show counts of failed scrape job.
+func (s *Storage) GetFailureCount() int64 {
+	var count int64
+	s.getInt64(storage.FailureCountKey, &count)
+
+	return count
+}
+
+// GetRetryCount show counts of retried upstream request.
+func (s *Storage) GetRetryCount() int64 {
+	var count int64
+	s.getInt64(storage.RetryCountKey, &count)
+
+	return count
+}
+
+// AddRateLimitWait record the time in millisecond spent waiting for the
+// upstream rate limits.
+func (s *Storage) AddRateLimitWait(ms int64) {
+	s.client.IncrBy(storage.RateLimitWaitKey, ms)
+}
+
+// GetRateLimitWait show the time in millisecond spent waiting for the
+// upstream rate limits.
+func (s *Storage) GetRateLimitWait() int64 {
+	var ms int64
+	s.getInt64(storage.RateLimitWaitKey, &ms)
+
+	return ms
+}
+
+// AddCacheHit record upstream responses served from the cache.
+func (s *Storage) AddCacheHit(count int64) {
+	s.client.IncrBy(storage.CacheHitKey, count)
+}
+
+// AddCacheMiss record upstream responses missing in the cache.
+func (s *Storage) AddCacheMiss(count int64) {
+	s.client.IncrBy(storage.CacheMissKey, count)
+}
+
+// GetCacheHit show counts of upstream responses served from the cache.
+func (s *Storage) GetCacheHit() int64 {
+	var count int64
+	s.getInt64(storage.CacheHitKey, &count)
+
+	return count
+}
+
+// GetCacheMiss show counts of upstream responses missing in the cache.
+func (s *Storage) GetCacheMiss() int64 {
+	var count int64
+	s.getInt64(storage.CacheMissKey, &count)
+
+	return count
+}
+
+// GetPanicCount show counts of recovered panic.
+func (s *Storage) GetPanicCount() int64 {
+	var count int64
+	s.getInt64(storage.PanicCountKey, &count)
+
+	return count
+}
+
+// maxJobUpdates is how many times UpdateJob tries again when the job
+// record changed during the update
+const maxJobUpdates = 100
+
+// SaveJob create or update the job record, records expire after
+// storage.JobRetention.
+func (s *Storage) SaveJob(job *storage.Job) error {
+	pipe := s.client.TxPipeline()
+	if err := saveJob(pipe, job); err != nil {
+		return err
+	}
+	_, err := pipe.Exec()
+
+	return err
+}
+
+// UpdateJob change the job record while it's watched, the update is tried
+// again when the record changed meanwhile. fn gets nil when the job doesn't
+// exist and returns the record to save, nil saves nothing.
+func (s *Storage) UpdateJob(id string, fn func(*storage.Job) *storage.Job) error {
+	update := func(tx *redis.Tx) error {
+		job, err := getJob(tx, id)
+		if err == storage.ErrJobNotFound {
+			job = nil
+		} else if err != nil {
+			return err
+		}
+
+		if job = fn(job); job == nil {
+			return nil
+		}
+
+		_, err = tx.TxPipelined(func(pipe redis.Pipeliner) error {
+			return saveJob(pipe, job)
+		})
+		return err
+	}
+
+	for i := 0; i < maxJobUpdates; i++ {
+		err := s.client.Watch(update, storage.JobKeyPrefix+id)
+		if err != redis.TxFailedErr {
+			return err
+		}
+	}
+
+	return redis.TxFailedErr
+}
+
+// saveJob add the commands saving the job record to the pipeline
+func saveJob(pipe redis.Pipeliner, job *storage.Job) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+
+	score := float64(job.CreatedAt.UnixNano())
+	expired := strconv.FormatInt(time.Now().Add(-storage.JobRetention).UnixNano(), 10)
+
+	pipe.Set(storage.JobKeyPrefix+job.ID, data, storage.JobRetention)
+	pipe.ZAdd(storage.JobListKey, &redis.Z{Score: score, Member: job.ID})
+	pipe.ZRemRangeByScore(storage.JobListKey, "-inf", expired)
+	for _, state := range storage.JobStates {
+		key := storage.JobStateKeyPrefix + string(state)
+		if state == job.State {
+			pipe.ZAdd(key, &redis.Z{Score: score, Member: job.ID})
+		} else {
+			pipe.ZRem(key, job.ID)
+		}
+		pipe.ZRemRangeByScore(key, "-inf", expired)
+	}
+
+	return nil
+}
+
+// GetJob returns a single job record.
+func (s *Storage) GetJob(id string) (*storage.Job, error) {
+	return getJob(s.client, id)
+}
+
+func getJob(c redis.Cmdable, id string) (*storage.Job, error) {
+	data, err := c.Get(storage.JobKeyPrefix + id).Bytes()
+	if err == redis.Nil {
+		return nil, storage.ErrJobNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	job := &storage.Job{}
+	if err := json.Unmarshal(data, job); err != nil {
+		return nil, err
+	}
+
+	return job, nil
+}
+
+// ListJobs returns jobs newest first, empty state returns every job.
+func (s *Storage) ListJobs(state storage.JobState, limit int) ([]storage.Job, error) {
+	key := storage.JobListKey
+	if state != "" {
+		key = storage.JobStateKeyPrefix + string(state)
+	}
+
+	ids, err := s.client.ZRevRange(key, 0, stop(limit)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	jobs := make([]storage.Job, 0, len(ids))
+	for _, id := range ids {
+		job, err := s.GetJob(id)
+		if err == storage.ErrJobNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, *job)
+	}
+
+	return jobs, nil
+}
+
+// SaveDeadLetter create or update the dead letter, dead letters never
+// expire.
+func (s *Storage) SaveDeadLetter(dl *storage.DeadLetter) error {
+	data, err := json.Marshal(dl)
+	if err != nil {
+		return err
+	}
+
+	pipe := s.client.TxPipeline()
+	pipe.Set(storage.DeadLetterKeyPrefix+dl.ID, data, 0)
+	pipe.ZAdd(storage.DeadLetterListKey, &redis.Z{Score: float64(dl.CreatedAt.UnixNano()), Member: dl.ID})
+	_, err = pipe.Exec()
+
+	return err
+}
+
+// GetDeadLetter returns a single dead letter.
+func (s *Storage) GetDeadLetter(id string) (*storage.DeadLetter, error) {
+	data, err := s.client.Get(storage.DeadLetterKeyPrefix + id).Bytes()
+	if err == redis.Nil {
+		return nil, storage.ErrDeadLetterNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	dl := &storage.DeadLetter{}
+	if err := json.Unmarshal(data, dl); err != nil {
+		return nil, err
+	}
+
+	return dl, nil
+}
+
+// ListDeadLetters returns dead letters newest first.
+func (s *Storage) ListDeadLetters(limit int) ([]storage.DeadLetter, error) {
+	ids, err := s.client.ZRevRange(storage.DeadLetterListKey, 0, stop(limit)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	dls := make([]storage.DeadLetter, 0, len(ids))
+	for _, id := range ids {
+		dl, err := s.GetDeadLetter(id)
+		if err == storage.ErrDeadLetterNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		dls = append(dls, *dl)
+	}
+
+	return dls, nil
+}
+
+// DeleteDeadLetter remove a single dead letter.
+func (s *Storage) DeleteDeadLetter(id string) error {
+	pipe := s.client.TxPipeline()
+	del := pipe.Del(storage.DeadLetterKeyPrefix + id)
+	pipe.ZRem(storage.DeadLetterListKey, id)
+	if _, err := pipe.Exec(); err != nil {
+		return err
+	}
+
+	if del.Val() == 0 {
+		return storage.ErrDeadLetterNotFound
+	}
+
+	return nil
+}
+
+// PurgeDeadLetters delete every dead letter and returns the count.
+func (s *Storage) PurgeDeadLetters() (int, error) {
+	ids, err := s.client.ZRange(storage.DeadLetterListKey, 0, -1).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	pipe := s.client.TxPipeline()
+	for _, id := range ids {
+		pipe.Del(storage.DeadLetterKeyPrefix + id)
+	}
+	pipe.Del(storage.DeadLetterListKey)
+	_, err = pipe.Exec()
+
+	return len(ids), err
+}
+
+// ClaimIdempotencyKey store the job id under the key for ttl unless the
+// key is owned already, it returns the id of the owner.
+func (s *Storage) ClaimIdempotencyKey(key, id string, ttl time.Duration) (string, error) {
+	// the owner may expire between SETNX and GET, then claim it again
+	for {
+		ok, err := s.client.SetNX(storage.IdempotencyKeyPrefix+key, id, ttl).Result()
+		if err != nil {
+			return "", err
+		}
+		if ok {
+			return id, nil
+		}
+
+		owner, err := s.client.Get(storage.IdempotencyKeyPrefix + key).Result()
+		if err != redis.Nil {
+			return owner, err
+		}
+	}
+}
+
+// DeleteIdempotencyKey remove the key, so the next claim takes it.
+func (s *Storage) DeleteIdempotencyKey(key string) error {
+	return s.client.Del(storage.IdempotencyKeyPrefix + key).Err()
+}
+
+// TakeRateLimit count a request in the current window of the key, it
+// returns the delay until the next window once limit is reached. The
+// window is shared by every instance using the redis server.
+func (s *Storage) TakeRateLimit(key string, limit int64, window time.Duration) (time.Duration, error) {
+	now := time.Now()
+	start := now.Truncate(window)
+	windowKey := storage.RateLimitKeyPrefix + key + ":" + strconv.FormatInt(start.UnixNano(), 10)
+
+	pipe := s.client.TxPipeline()
+	incr := pipe.Incr(windowKey)
+	pipe.Expire(windowKey, 2*window)
+	if _, err := pipe.Exec(); err != nil {
+		return 0, err
+	}
+
+	if incr.Val() > limit {
+		return start.Add(window).Sub(now), nil
+	}
+	return 0, nil
+}
+
+// SaveResponse keep the upstream response under the key for ttl.
+func (s *Storage) SaveResponse(key string, resp *storage.Response, ttl time.Duration) error {
+	data, err := json.Marshal(resp)
+	if err != nil {
+		return err
+	}
+
+	return s.client.Set(storage.ResponseKeyPrefix+key, data, ttl).Err()
+}
+
+// GetResponse returns the cached upstream response
+func (s *Storage) GetResponse(key string) (*storage.Response, error) {
+	data, err := s.client.Get(storage.ResponseKeyPrefix + key).Bytes()
+	if err == redis.Nil {
+		return nil, storage.ErrResponseNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &storage.Response{}
+	if err := json.Unmarshal(data, resp); err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
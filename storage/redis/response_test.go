@@ -0,0 +1,49 @@
+package redis
+
+import (
+	"testing"
+	"time"
+
+	"github.com/natansdj/go_scrape/config"
+	"github.com/natansdj/go_scrape/storage"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResponse(t *testing.T) {
+	mr, err := miniredis.Run()
+	assert.NoError(t, err)
+	defer mr.Close()
+
+	cfg := config.ConfYaml{}
+	cfg.Stat.Redis.Addr = mr.Addr()
+
+	s := New(cfg)
+	assert.NoError(t, s.Init())
+
+	_, err = s.GetResponse("key")
+	assert.Equal(t, storage.ErrResponseNotFound, err)
+
+	stored := time.Now().Truncate(time.Second)
+	resp := &storage.Response{Body: []byte("funds"), LastModified: "Mon, 02 Jan 2006 15:04:05 GMT", StoredAt: stored}
+	assert.NoError(t, s.SaveResponse("key", resp, time.Minute))
+
+	got, err := s.GetResponse("key")
+	assert.NoError(t, err)
+	assert.Equal(t, "funds", string(got.Body))
+	assert.Equal(t, resp.LastModified, got.LastModified)
+	assert.True(t, stored.Equal(got.StoredAt))
+
+	mr.FastForward(time.Minute)
+	_, err = s.GetResponse("key")
+	assert.Equal(t, storage.ErrResponseNotFound, err)
+
+	s.AddCacheHit(2)
+	s.AddCacheMiss(1)
+	assert.Equal(t, int64(2), s.GetCacheHit())
+	assert.Equal(t, int64(1), s.GetCacheMiss())
+	s.Reset()
+	assert.Zero(t, s.GetCacheHit())
+	assert.Zero(t, s.GetCacheMiss())
+}
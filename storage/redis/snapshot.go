@@ -0,0 +1,150 @@
+package redis
+
+import (
+	"encoding/json"
+
+	"github.com/natansdj/go_scrape/config"
+	"github.com/natansdj/go_scrape/storage"
+
+	"github.com/go-redis/redis/v7"
+)
+
+var _ storage.SnapshotStore = (*SnapshotStore)(nil)
+
+// NewSnapshot func implements the snapshot store interface with redis
+func NewSnapshot(config config.ConfYaml) *SnapshotStore {
+	return &SnapshotStore{
+		config: config,
+	}
+}
+
+// SnapshotStore keep every run as json and sorted sets as index
+type SnapshotStore struct {
+	config config.ConfYaml
+	client *redis.Client
+}
+
+// Init client storage.
+func (s *SnapshotStore) Init() error {
+	s.client = redis.NewClient(&redis.Options{
+		Addr:     s.config.Stat.Redis.Addr,
+		Password: s.config.Stat.Redis.Password,
+		DB:       s.config.Stat.Redis.DB,
+	})
+	_, err := s.client.Ping().Result()
+
+	return err
+}
+
+// Close the storage connection
+func (s *SnapshotStore) Close() error {
+	if s.client == nil {
+		return nil
+	}
+
+	return s.client.Close()
+}
+
+// SaveRun store the run and index every fund by id
+func (s *SnapshotStore) SaveRun(run *storage.Snapshot) error {
+	data, err := json.Marshal(run)
+	if err != nil {
+		return err
+	}
+
+	score := float64(run.CreatedAt.UnixNano())
+
+	pipe := s.client.TxPipeline()
+	pipe.Set(storage.SnapshotKeyPrefix+run.ID, data, 0)
+	pipe.ZAdd(storage.SnapshotListKey, &redis.Z{Score: score, Member: run.ID})
+	for _, p := range run.Points() {
+		point, err := json.Marshal(p)
+		if err != nil {
+			return err
+		}
+		pipe.ZAdd(storage.FundHistoryKeyPrefix+p.Fund.ID, &redis.Z{Score: score, Member: point})
+	}
+	_, err = pipe.Exec()
+
+	return err
+}
+
+// ListRuns returns runs newest first without funds
+func (s *SnapshotStore) ListRuns(limit int) ([]storage.Snapshot, error) {
+	ids, err := s.client.ZRevRange(storage.SnapshotListKey, 0, stop(limit)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	runs := make([]storage.Snapshot, 0, len(ids))
+	for _, id := range ids {
+		run, err := s.GetRun(id)
+		if err == storage.ErrSnapshotNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		runs = append(runs, run.Summary())
+	}
+
+	return runs, nil
+}
+
+// GetRun returns a single run with funds
+func (s *SnapshotStore) GetRun(id string) (*storage.Snapshot, error) {
+	data, err := s.client.Get(storage.SnapshotKeyPrefix + id).Bytes()
+	if err == redis.Nil {
+		return nil, storage.ErrSnapshotNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	run := &storage.Snapshot{}
+	if err := json.Unmarshal(data, run); err != nil {
+		return nil, err
+	}
+
+	return run, nil
+}
+
+// Latest returns the newest run with funds
+func (s *SnapshotStore) Latest() (*storage.Snapshot, error) {
+	ids, err := s.client.ZRevRange(storage.SnapshotListKey, 0, 0).Result()
+	if err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return nil, storage.ErrSnapshotNotFound
+	}
+
+	return s.GetRun(ids[0])
+}
+
+// FundHistory returns the values of a fund newest first
+func (s *SnapshotStore) FundHistory(fundID string, limit int) ([]storage.FundPoint, error) {
+	members, err := s.client.ZRevRange(storage.FundHistoryKeyPrefix+fundID, 0, stop(limit)).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	points := make([]storage.FundPoint, 0, len(members))
+	for _, m := range members {
+		var p storage.FundPoint
+		if err := json.Unmarshal([]byte(m), &p); err != nil {
+			return nil, err
+		}
+		points = append(points, p)
+	}
+
+	return points, nil
+}
+
+// stop convert limit into the ZRANGE stop index, zero means no limit
+func stop(limit int) int64 {
+	if limit <= 0 {
+		return -1
+	}
+	return int64(limit - 1)
+}
@@ -0,0 +1,57 @@
+package redis
+
+import (
+	"testing"
+
+	"github.com/natansdj/go_scrape/config"
+	"github.com/natansdj/go_scrape/fund"
+	"github.com/natansdj/go_scrape/storage"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSnapshotEngine(t *testing.T) {
+	mr, err := miniredis.Run()
+	assert.NoError(t, err)
+	defer mr.Close()
+
+	cfg := config.ConfYaml{}
+	cfg.Stat.Redis.Addr = mr.Addr()
+
+	s := NewSnapshot(cfg)
+	assert.NoError(t, s.Init())
+
+	_, err = s.Latest()
+	assert.Equal(t, storage.ErrSnapshotNotFound, err)
+
+	first := storage.NewSnapshot("indopremier", "default", []fund.Fund{{ID: "1", Name: "A"}, {ID: "2", Name: "B"}})
+	second := storage.NewSnapshot("indopremier", "equity", []fund.Fund{{ID: "1", Name: "A2"}})
+	assert.NoError(t, s.SaveRun(first))
+	assert.NoError(t, s.SaveRun(second))
+
+	runs, err := s.ListRuns(0)
+	assert.NoError(t, err)
+	assert.Len(t, runs, 2)
+	assert.Equal(t, second.ID, runs[0].ID)
+	assert.Nil(t, runs[0].Funds)
+
+	latest, err := s.Latest()
+	assert.NoError(t, err)
+	assert.Equal(t, second.ID, latest.ID)
+	assert.Len(t, latest.Funds, 1)
+
+	_, err = s.GetRun("missing")
+	assert.Equal(t, storage.ErrSnapshotNotFound, err)
+
+	history, err := s.FundHistory("1", 1)
+	assert.NoError(t, err)
+	assert.Len(t, history, 1)
+	assert.Equal(t, "A2", history[0].Fund.Name)
+
+	history, err = s.FundHistory("1", 0)
+	assert.NoError(t, err)
+	assert.Len(t, history, 2)
+
+	assert.NoError(t, s.Close())
+}
@@ -0,0 +1,21 @@
+package storage
+
+import (
+	"errors"
+	"time"
+)
+
+// ResponseKeyPrefix is key prefix of a cached upstream response
+const ResponseKeyPrefix = "go_scrape-response:"
+
+// ErrResponseNotFound is returned when the response is not cached
+var ErrResponseNotFound = errors.New("response not found")
+
+// Response is an upstream response kept for the next identical requests,
+// its validators allow a conditional request once it's stale.
+type Response struct {
+	Body         []byte    `json:"body"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"last_modified,omitempty"`
+	StoredAt     time.Time `json:"stored_at"`
+}
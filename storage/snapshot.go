@@ -0,0 +1,99 @@
+package storage
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/natansdj/go_scrape/fund"
+)
+
+const (
+	// SnapshotListKey is key name of the sorted run ids
+	SnapshotListKey = "go_scrape-snapshots"
+	// SnapshotKeyPrefix is key prefix of a single run
+	SnapshotKeyPrefix = "go_scrape-snapshot:"
+	// FundHistoryKeyPrefix is key prefix of the history of a single fund
+	FundHistoryKeyPrefix = "go_scrape-fund-history:"
+)
+
+// ErrSnapshotNotFound is returned when the run doesn't exist
+var ErrSnapshotNotFound = errors.New("snapshot not found")
+
+// Snapshot is the result of a single scrape run
+type Snapshot struct {
+	ID        string      `json:"id"`
+	Source    string      `json:"source"`
+	Preset    string      `json:"preset"`
+	CreatedAt time.Time   `json:"created_at"`
+	FundCount int         `json:"fund_count"`
+	Funds     []fund.Fund `json:"funds,omitempty"`
+}
+
+// FundPoint is the value of a fund in a single run
+type FundPoint struct {
+	RunID     string    `json:"run_id"`
+	CreatedAt time.Time `json:"created_at"`
+	Fund      fund.Fund `json:"fund"`
+}
+
+// SnapshotStore keep the history of scrape runs
+type SnapshotStore interface {
+	Init() error
+	// SaveRun store the run and index every fund by id
+	SaveRun(*Snapshot) error
+	// ListRuns returns runs newest first without funds
+	ListRuns(limit int) ([]Snapshot, error)
+	GetRun(id string) (*Snapshot, error)
+	// Latest returns the newest run with funds
+	Latest() (*Snapshot, error)
+	// FundHistory returns the values of a fund newest first
+	FundHistory(fundID string, limit int) ([]FundPoint, error)
+	Close() error
+}
+
+// NewSnapshot returns a run with sortable id, ids of runs created later
+// compare greater.
+func NewSnapshot(source, preset string, funds []fund.Fund) *Snapshot {
+	now := time.Now().UTC()
+
+	return &Snapshot{
+		ID:        NewSortableID(now),
+		Source:    source,
+		Preset:    preset,
+		CreatedAt: now,
+		FundCount: len(funds),
+		Funds:     funds,
+	}
+}
+
+// NewSortableID returns a time ordered unique id.
+func NewSortableID(t time.Time) string {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+
+	return fmt.Sprintf("%019d-%s", t.UnixNano(), hex.EncodeToString(b))
+}
+
+// Summary returns a copy of the run without funds
+func (s Snapshot) Summary() Snapshot {
+	s.Funds = nil
+	return s
+}
+
+// Points returns the funds of the run as history points
+func (s *Snapshot) Points() []FundPoint {
+	points := make([]FundPoint, 0, len(s.Funds))
+	for _, f := range s.Funds {
+		points = append(points, FundPoint{
+			RunID:     s.ID,
+			CreatedAt: s.CreatedAt,
+			Fund:      f,
+		})
+	}
+	return points
+}
@@ -1,8 +1,25 @@
 package storage
 
+import "time"
+
 const (
 	// TotalCountKey is key name for total count of storage
 	TotalCountKey = "go_scrape-total-count"
+	// SuccessCountKey is key name for succeeded scrape job count of storage
+	SuccessCountKey = "go_scrape-success-count"
+	// FailureCountKey is key name for failed scrape job count of storage
+	FailureCountKey = "go_scrape-failure-count"
+	// RetryCountKey is key name for retried upstream request count of storage
+	RetryCountKey = "go_scrape-retry-count"
+	// PanicCountKey is key name for recovered panic count of storage
+	PanicCountKey = "go_scrape-panic-count"
+	// RateLimitWaitKey is key name for the time in millisecond spent waiting
+	// for the upstream rate limits
+	RateLimitWaitKey = "go_scrape-rate-limit-wait"
+	// CacheHitKey is key name for upstream responses served from the cache
+	CacheHitKey = "go_scrape-cache-hit"
+	// CacheMissKey is key name for upstream responses missing in the cache
+	CacheMissKey = "go_scrape-cache-miss"
 )
 
 // Storage interface
@@ -10,6 +27,45 @@ type Storage interface {
 	Init() error
 	Reset()
 	AddTotalCount(int64)
+	AddSuccessCount(int64)
+	AddFailureCount(int64)
+	AddRetryCount(int64)
+	AddPanicCount(int64)
+	AddRateLimitWait(int64)
+	AddCacheHit(int64)
+	AddCacheMiss(int64)
 	GetTotalCount() int64
+	GetSuccessCount() int64
+	GetFailureCount() int64
+	GetRetryCount() int64
+	GetPanicCount() int64
+	GetRateLimitWait() int64
+	GetCacheHit() int64
+	GetCacheMiss() int64
+	// SaveJob create or update the job record
+	SaveJob(*Job) error
+	GetJob(id string) (*Job, error)
+	// UpdateJob change the job record atomically, fn gets nil when the job
+	// doesn't exist and returns the record to save, nil saves nothing
+	UpdateJob(id string, fn func(*Job) *Job) error
+	// ListJobs returns jobs newest first, empty state returns every job
+	ListJobs(state JobState, limit int) ([]Job, error)
+	SaveDeadLetter(*DeadLetter) error
+	GetDeadLetter(id string) (*DeadLetter, error)
+	// ListDeadLetters returns dead letters newest first
+	ListDeadLetters(limit int) ([]DeadLetter, error)
+	DeleteDeadLetter(id string) error
+	// PurgeDeadLetters delete every dead letter and returns the count
+	PurgeDeadLetters() (int, error)
+	// ClaimIdempotencyKey store the job id under the key for ttl unless the
+	// key is owned already, it returns the id of the owner
+	ClaimIdempotencyKey(key, id string, ttl time.Duration) (string, error)
+	DeleteIdempotencyKey(key string) error
+	// TakeRateLimit count a request in the current window of the key, it
+	// returns the delay until the next window once limit is reached
+	TakeRateLimit(key string, limit int64, window time.Duration) (time.Duration, error)
+	// SaveResponse keep the upstream response under the key for ttl
+	SaveResponse(key string, resp *Response, ttl time.Duration) error
+	GetResponse(key string) (*Response, error)
 	Close() error
 }